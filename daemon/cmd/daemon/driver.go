@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+// Valores de -driver.
+const (
+	driverAuto = "auto" // el de la plataforma (SendInput en Windows, uinput en Linux)
+	driverFake = "fake" // no mueve nada: registra y loguea cada llamada
+)
+
+// newDriver arma el driver pedido con -driver.
+func newDriver(name string) (input.InputDriver, error) {
+	switch name {
+	case "", driverAuto:
+		return platformDriver()
+	case driverFake:
+		f := input.NewFake()
+		f.OnCall = func(c input.Call) { log.Printf("[fake] %s", c) }
+		log.Printf("[boot] driver fake: el input se registra pero no llega al sistema")
+		return f, nil
+	default:
+		return nil, fmt.Errorf("driver desconocido %q (%s o %s)", name, driverAuto, driverFake)
+	}
+}
+
+// writeFakeLog guarda en path lo que registró el driver fake (para CI).
+func writeFakeLog(f *input.Fake, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.WriteJSON(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
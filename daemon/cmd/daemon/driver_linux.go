@@ -0,0 +1,12 @@
+package main
+
+import "deskcontrol/daemon/internal/input"
+
+// platformDriver: teclado y mouse virtuales por /dev/uinput.
+func platformDriver() (input.InputDriver, error) {
+	u, err := input.NewUinput()
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
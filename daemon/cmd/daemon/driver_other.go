@@ -0,0 +1,14 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+func platformDriver() (input.InputDriver, error) {
+	return nil, fmt.Errorf("no hay driver de input para %s (probar con -driver fake)", runtime.GOOS)
+}
@@ -0,0 +1,5 @@
+package main
+
+import "deskcontrol/daemon/internal/input"
+
+func platformDriver() (input.InputDriver, error) { return input.New(), nil }
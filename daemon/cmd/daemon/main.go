@@ -1,95 +1,197 @@
+// Command daemon es DeskControl sin UI: WS + discovery con la misma config
+// (tabla settings) y los mismos usuarios que deskcontrol-ui, más lo que se
+// pise con un archivo, variables DESKCONTROL_* o flags.
+//
+//	daemon -config deskcontrol.toml -ws-port 54545 -encrypt-tls
+//
+// SIGINT/SIGTERM lo detienen; SIGHUP imprime un código de emparejamiento nuevo.
+// Con -driver fake no se toca el input real (CI, demos, desarrollo del móvil).
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/config"
+	"deskcontrol/daemon/internal/discovery"
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/pairing"
+	"deskcontrol/daemon/internal/ws"
 )
 
-func logsDir() (string, error) {
-	dir, err := appDataDir()
-	if err != nil {
-		return "", err
+// shutdownTimeout: cuánto esperamos a que las sesiones se cierren al salir
+const shutdownTimeout = 3 * time.Second
+
+func main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "archivo de config (.toml, .yaml o .json)")
+	showQR := fs.Bool("qr", true, "imprimir el QR de emparejamiento en la terminal")
+	pairTTL := fs.Duration("pair-ttl", ws.DefaultPairingTTL, "validez del código de emparejamiento (con TLS)")
+	logFile := fs.Bool("log-file", true, "guardar también los logs en <config>/DeskControl/logs")
+	driver := fs.String("driver", driverAuto, "driver de input: auto (el de la plataforma) o fake (sólo registra)")
+	fakeLog := fs.String("fake-log", "", "con -driver fake, archivo donde volcar las llamadas en JSON al salir")
+	overrides := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Uso: %s [flags]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "La config sale de la tabla settings (la de la UI); el archivo, las variables\n")
+		fmt.Fprintf(fs.Output(), "%s<CLAVE> (p.ej. %sWS_PORT) y los flags la pisan en ese orden.\n\n", envPrefix, envPrefix)
+		fs.PrintDefaults()
 	}
-	ld := filepath.Join(dir, "logs")
-	if err := os.MkdirAll(ld, 0o755); err != nil {
-		return "", err
+	_ = fs.Parse(os.Args[1:])
+
+	// timestamps siempre
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if *logFile {
+		closeFn, err := InitFileLogging(nil)
+		if err != nil {
+			log.Printf("[boot] InitFileLogging error: %v", err)
+		} else {
+			defer closeFn()
+		}
 	}
-	return ld, nil
-}
 
-func currentLogFilePath() (string, error) {
-	ld, err := logsDir()
-	if err != nil {
-		return "", err
+	opts := runOptions{
+		configFile: *configFile,
+		overrides:  *overrides,
+		showQR:     *showQR,
+		pairTTL:    *pairTTL,
+		driver:     *driver,
+		fakeLog:    *fakeLog,
+	}
+	if err := run(opts); err != nil {
+		log.Printf("[boot] %v", err)
+		os.Exit(1)
 	}
-	name := "deskcontrol-" + time.Now().Format("2006-01-02") + ".log"
-	return filepath.Join(ld, name), nil
 }
 
-func InitFileLogging(hub io.Writer) (func(), error) {
-	p, err := currentLogFilePath()
-	if err != nil {
-		return func() {}, err
+type runOptions struct {
+	configFile string
+	overrides  []override
+	showQR     bool
+	pairTTL    time.Duration
+	driver     string
+	fakeLog    string
+}
+
+func run(o runOptions) error {
+	if o.fakeLog != "" && o.driver != driverFake {
+		return fmt.Errorf("-fake-log sólo tiene sentido con -driver %s", driverFake)
 	}
-	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	cfg, err := effectiveConfig(o.configFile, o.overrides)
 	if err != nil {
-		return func() {}, err
+		return fmt.Errorf("config: %w", err)
+	}
+	if p, err := config.DBPath(); err == nil {
+		log.Printf("[boot] settings=%s file=%q", p, o.configFile)
 	}
 
-	mw := io.MultiWriter(os.Stdout, f)
-	if hub != nil {
-		mw = io.MultiWriter(os.Stdout, f, hub)
+	if err := PurgeOldLogs(cfg.LogRetentionDays); err != nil {
+		log.Printf("[boot] PurgeOldLogs error: %v", err)
+	}
+	if _, err := audit.Purge(cfg.AuditRetentionDays); err != nil {
+		log.Printf("[boot] audit.Purge error: %v", err)
 	}
-	log.SetOutput(mw)
 
-	return func() { _ = f.Close() }, nil
-}
+	driver, err := newDriver(o.driver)
+	if err != nil {
+		return err
+	}
+	fake, _ := driver.(*input.Fake)
+	if c, ok := driver.(io.Closer); ok {
+		defer c.Close()
+	}
 
-func PurgeOldLogs(retentionDays int) error {
-	if retentionDays <= 0 {
-		return nil
+	srv := cfg.NewServer(driver)
+	if cfg.RequireApproval {
+		// sin UI no hay quién apruebe: lo que no esté en approved_clients se rechaza
+		log.Printf("[core] require_approval sin UI: sólo entran los dispositivos ya aprobados")
 	}
-	ld, err := logsDir()
-	if err != nil {
+	if err := srv.Start(); err != nil {
 		return err
 	}
-	entries, err := os.ReadDir(ld)
+	log.Printf("[core] running WS=%s UDP=%d (bind=%s) tls=%v token=%v account=%v",
+		cfg.WSAddr(), cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS, cfg.RequireToken, cfg.RequireAccount)
+
+	// discovery es opcional, sin él el teléfono igual conecta por IP/QR
+	disc, err := discovery.StartUDP(cfg.DiscoveryAnnounce(), cfg.WSPort, cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS)
 	if err != nil {
-		return err
+		log.Printf("[core] discovery error: %v", err)
 	}
-	cutoff := time.Now().Add(-time.Duration(retentionDays) * 24 * time.Hour)
-	for _, e := range entries {
-		if e.IsDir() {
+
+	printPairing(cfg, o.showQR, o.pairTTL)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			printPairing(cfg, o.showQR, o.pairTTL)
 			continue
 		}
-		info, err := e.Info()
-		if err != nil {
-			continue
+		log.Printf("[core] %v: stopping", sig)
+		break
+	}
+	signal.Stop(sigs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if disc != nil {
+		if err := disc.Shutdown(ctx); err != nil {
+			log.Printf("[core] discovery shutdown: %v", err)
 		}
-		if info.ModTime().Before(cutoff) {
-			_ = os.Remove(filepath.Join(ld, e.Name()))
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[core] ws shutdown: %v", err)
+	}
+	if fake != nil && o.fakeLog != "" {
+		if err := writeFakeLog(fake, o.fakeLog); err != nil {
+			log.Printf("[core] fake log: %v", err)
+		} else {
+			log.Printf("[core] fake log: %d llamadas en %s", len(fake.Calls()), o.fakeLog)
 		}
 	}
+	log.Printf("[core] stopped ✅")
 	return nil
 }
 
-func DeleteAllLogs() error {
-	ld, err := logsDir()
-	if err != nil {
-		return err
+// printPairing saca por stdout el payload del QR (y el QR). Con TLS pide un
+// código de un solo uso; el teléfono lo cambia por su credencial.
+func printPairing(cfg config.AppConfig, showQR bool, ttl time.Duration) {
+	secret := ""
+	var code ws.PairingCode
+	if cfg.EncryptTrafficTLS {
+		pc, err := ws.NewPairingCode(ttl)
+		if err != nil {
+			log.Printf("[pair] NewPairingCode error: %v", err)
+			return
+		}
+		code, secret = pc, pc.Secret
 	}
-	entries, err := os.ReadDir(ld)
+	payload, err := pairing.Payload(cfg, secret)
 	if err != nil {
-		return err
+		log.Printf("[pair] payload error: %v", err)
+		return
 	}
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+
+	out := os.Stdout
+	fmt.Fprintln(out)
+	if showQR {
+		if qr, err := pairing.QRText(payload); err != nil {
+			log.Printf("[pair] QR error: %v", err)
+		} else {
+			fmt.Fprint(out, qr)
 		}
-		_ = os.Remove(filepath.Join(ld, e.Name()))
 	}
-	return nil
+	fmt.Fprintf(out, "Emparejar: %s\n", payload)
+	if secret != "" {
+		fmt.Fprintf(out, "Código: %s %s (vence en %s, un solo uso)\n", code.Code[:3], code.Code[3:], ttl.Round(time.Second))
+	}
+	fmt.Fprintln(out)
 }
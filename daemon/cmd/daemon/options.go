@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"deskcontrol/daemon/internal/config"
+)
+
+// envPrefix: DESKCONTROL_WS_PORT=54545 pisa ws_port.
+const envPrefix = "DESKCONTROL_"
+
+// override es un -flag de config (p.ej. -ws-port 54545); se aplican al final,
+// en el orden en que se pasaron.
+type override struct{ key, value string }
+
+// configFlags registra un flag por cada config.Field ("ws_port" -> -ws-port).
+func configFlags(fs *flag.FlagSet) *[]override {
+	var out []override
+	for _, f := range config.Fields() {
+		key := f.Key
+		name := strings.ReplaceAll(key, "_", "-")
+		set := func(v string) error {
+			out = append(out, override{key, v})
+			return nil
+		}
+		if f.IsBool() {
+			fs.BoolFunc(name, f.Help, set)
+		} else {
+			fs.Func(name, f.Help, set)
+		}
+	}
+	return &out
+}
+
+// loadConfigFile lee un .toml, .yaml/.yml o .json con las mismas claves que
+// la tabla settings (ws_port, encrypt_tls, ...).
+func loadConfigFile(path string) (map[string]any, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		err = toml.Unmarshal(raw, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &values)
+	case ".json":
+		err = json.Unmarshal(raw, &values)
+	default:
+		return nil, fmt.Errorf("%s: formato desconocido %q (toml, yaml o json)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// se acepta también ws-port
+	out := make(map[string]any, len(values))
+	for k, v := range values {
+		out[strings.ReplaceAll(k, "-", "_")] = v
+	}
+	return out, nil
+}
+
+// envOverrides devuelve las DESKCONTROL_* que corresponden a un campo.
+func envOverrides() []override {
+	var out []override
+	for _, f := range config.Fields() {
+		if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(f.Key)); ok {
+			out = append(out, override{f.Key, v})
+		}
+	}
+	return out
+}
+
+// effectiveConfig: settings de deskcontrol.db (lo mismo que ve la UI) y
+// encima, en este orden, el archivo, el entorno y los flags. No se guarda:
+// los cambios de la UI siguen en settings y los overrides son del proceso.
+func effectiveConfig(file string, flags []override) (config.AppConfig, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return cfg, fmt.Errorf("settings: %w", err)
+	}
+
+	if file != "" {
+		values, err := loadConfigFile(file)
+		if err != nil {
+			return cfg, err
+		}
+		if err := cfg.SetAll(values); err != nil {
+			return cfg, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	for _, o := range envOverrides() {
+		if err := cfg.Set(o.key, o.value); err != nil {
+			return cfg, fmt.Errorf("variable %s%s: %w", envPrefix, strings.ToUpper(o.key), err)
+		}
+	}
+	for _, o := range flags {
+		if err := cfg.Set(o.key, o.value); err != nil {
+			return cfg, fmt.Errorf("flag: %w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.ApplyPolicy(); err != nil {
+		return cfg, err
+	}
+	if err := config.EnsureTLSCertKey(&cfg); err != nil {
+		return cfg, fmt.Errorf("TLS cert: %w", err)
+	}
+	return cfg, nil
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"time"
 
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/config"
 	"deskcontrol/daemon/internal/loghub"
 )
 
@@ -39,14 +41,17 @@ func main() {
 	log.Println("[boot] logger initialized (file + ui hub) ✅")
 
 	// Cargar config para poder purgar logs antiguos (si falla, igual seguimos)
-	cfg, err := LoadConfig()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("[boot] LoadConfig error: %v", err)
-		cfg = defaultConfig()
+		log.Printf("[boot] config.Load error: %v", err)
+		cfg = config.Default()
 	}
 	if err := PurgeOldLogs(cfg.LogRetentionDays); err != nil {
 		log.Printf("[boot] PurgeOldLogs error: %v", err)
 	}
+	if _, err := audit.Purge(cfg.AuditRetentionDays); err != nil {
+		log.Printf("[boot] audit.Purge error: %v", err)
+	}
 
 	// Arrancar UI (no debe reconfigurar el logger)
 	runUI(opts, hub)
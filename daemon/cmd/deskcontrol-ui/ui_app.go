@@ -1,15 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"os"
 	"time"
 
-	"deskcontrol/daemon/internal/discovery"
-	"deskcontrol/daemon/internal/input"
-	"deskcontrol/daemon/internal/ws"
-
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
@@ -33,44 +28,6 @@ type HubIface interface {
 	Clear()
 }
 
-func startCoreFromConfig() {
-	cfg, err := LoadConfig()
-	if err != nil {
-		log.Printf("[ui] LoadConfig error: %v (usando default)", err)
-		cfg = defaultConfig()
-	}
-
-	name, _ := os.Hostname()
-	if name == "" {
-		name = "DeskControl-PC"
-	}
-
-	// ✅ StartUDP ahora requiere 5 args: (name, wsPort, udpPort, bindIP, tlsOn)
-	go discovery.StartUDP(name, cfg.WSPort, cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS)
-
-	// WS
-	driver := input.New()
-
-	addr := fmt.Sprintf(":%d", cfg.WSPort)
-	if ip := cfg.ListenIP; ip != "" && ip != "0.0.0.0" {
-		addr = fmt.Sprintf("%s:%d", ip, cfg.WSPort)
-	}
-
-	sec := ws.SecurityConfig{
-		RequireTLS:     cfg.EncryptTrafficTLS,
-		CertPath:       cfg.TLSCertPath,
-		KeyPath:        cfg.TLSKeyPath,
-		RequireToken:   cfg.RequireToken,
-		Token:          cfg.Token,
-		RequireAccount: cfg.RequireAccount,
-	}
-
-	log.Printf("[core] running WS=%s UDP=%d (bind=%s) tls=%v token=%v account=%v",
-		addr, cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS, cfg.RequireToken, cfg.RequireAccount)
-
-	go ws.Start(addr, driver, sec)
-}
-
 func runUI(opts UIOpts, hub HubIface) {
 	state := &UIState{ShowUI: true}
 
@@ -88,20 +45,35 @@ func runUI(opts UIOpts, hub HubIface) {
 
 	// ✅ Core (WS + UDP) antes de mostrar UI
 	log.Printf("[ui] starting core…")
-	startCoreFromConfig()
+	if err := startCoreFromConfig(); err != nil {
+		log.Printf("[ui] core start error: %v", err)
+	}
+	defer stopCore()
+
+	// ✅ Cambios de config se aplican en caliente (sin reiniciar el proceso)
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go watchConfig(watchCtx)
 
 	logsTab := buildLogsTab(a, w, hub, state, opts.MaxUILines, opts.Tick)
 	configTab := buildConfigTab(opts.AppRunName, w)
 
 	// ✅ NUEVA pestaña dedicada
 	usersTab := buildUsersTab(w)
+	macrosTab := buildMacrosTab(w)
+	auditTab := buildAuditTab(w)
+	devicesTab := buildDevicesTab(w)
 
 	tabs := container.NewAppTabs(
 		container.NewTabItem("Logs", logsTab),
 		container.NewTabItem("Config", configTab),
 		container.NewTabItem("Usuarios", usersTab),
+		container.NewTabItem("Dispositivos", devicesTab),
+		container.NewTabItem("Macros", macrosTab),
+		container.NewTabItem("Auditoría", auditTab),
 	)
 	w.SetContent(tabs)
+	setApprovalWindow(w, state)
 
 	// Tray
 	if desk, ok := a.(desktop.App); ok {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"deskcontrol/daemon/internal/ws"
+)
+
+// ventana donde se muestran las preguntas de aprobación (la setea runUI)
+var approvalUI struct {
+	mu    sync.Mutex
+	w     fyne.Window
+	state *UIState
+}
+
+func setApprovalWindow(w fyne.Window, state *UIState) {
+	approvalUI.mu.Lock()
+	defer approvalUI.mu.Unlock()
+	approvalUI.w = w
+	approvalUI.state = state
+}
+
+// promptApproval es el ws.Server.SetApprovalPrompt de la UI: avisa por la
+// bandeja y abre la ventana con la pregunta. Si todavía no hay ventana se
+// deniega (el ws lo haría igual al vencer el plazo).
+func promptApproval(req ws.ApprovalRequest) {
+	approvalUI.mu.Lock()
+	w, state := approvalUI.w, approvalUI.state
+	approvalUI.mu.Unlock()
+	if w == nil {
+		_ = ws.ResolveApproval(req.SessionID, ws.Deny)
+		return
+	}
+
+	who := approvalWho(req)
+	a := fyne.CurrentApp()
+	a.SendNotification(fyne.NewNotification("DeskControl: conexión nueva", who+" quiere controlar este PC"))
+	a.Driver().DoFromGoroutine(func() {
+		if state != nil {
+			state.ShowUI = true
+		}
+		w.Show()
+		w.RequestFocus()
+		showApprovalDialog(req, who, w)
+	}, false)
+}
+
+func approvalWho(req ws.ApprovalRequest) string {
+	name := req.DeviceName
+	if name == "" {
+		name = "Dispositivo sin nombre"
+	}
+	return fmt.Sprintf("%s (%s)", name, req.RemoteAddr)
+}
+
+func approvalPending(sessionID string) bool {
+	for _, p := range ws.PendingApprovals() {
+		if p.SessionID == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+func showApprovalDialog(req ws.ApprovalRequest, who string, w fyne.Window) {
+	countdown := widget.NewLabel("")
+	refresh := func() {
+		left := time.Until(req.Deadline).Round(time.Second)
+		if left < 0 {
+			left = 0
+		}
+		countdown.SetText(fmt.Sprintf("Se rechaza sola en %s", left))
+	}
+	refresh()
+
+	details := fmt.Sprintf("%s pide acceso.", who)
+	if req.Client != "" {
+		details += "\nCliente: " + req.Client
+	}
+	if req.DeviceID != "" {
+		details += "\nDispositivo emparejado: " + req.DeviceID
+	} else {
+		details += "\nSin credencial de dispositivo: 'Permitir siempre' recuerda la IP."
+	}
+	msg := widget.NewLabel(details)
+	msg.Wrapping = fyne.TextWrapWord
+
+	var d dialog.Dialog
+	stop := make(chan struct{})
+	var once sync.Once
+	answer := func(dec ws.ApprovalDecision) {
+		once.Do(func() { close(stop) })
+		d.Hide()
+		if err := ws.ResolveApproval(req.SessionID, dec); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}
+
+	btnOnce := widget.NewButton("Permitir una vez", func() { answer(ws.ApproveOnce) })
+	btnAlways := widget.NewButton("Permitir siempre", func() { answer(ws.ApproveAlways) })
+	btnDeny := widget.NewButton("Denegar", func() { answer(ws.Deny) })
+	btnDeny.Importance = widget.DangerImportance
+
+	content := container.NewVBox(msg, countdown, container.NewHBox(btnOnce, btnAlways, btnDeny))
+	d = dialog.NewCustomWithoutButtons("Aprobar conexión", content, w)
+
+	// el ws deniega solo al vencer el plazo (o la conexión se corta); acá
+	// sólo cerramos la pregunta
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fyne.CurrentApp().Driver().DoFromGoroutine(func() {
+					refresh()
+					if !time.Now().Before(req.Deadline) || !approvalPending(req.SessionID) {
+						once.Do(func() { close(stop) })
+						d.Hide()
+					}
+				}, false)
+			}
+		}
+	}()
+	d.Show()
+}
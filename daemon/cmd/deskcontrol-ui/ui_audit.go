@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"deskcontrol/daemon/internal/audit"
+)
+
+// maxAuditRows: lo que se muestra en la lista; exportar no tiene límite.
+const maxAuditRows = 1000
+
+// auditUser registra un cambio hecho a un usuario desde la UI.
+func auditUser(username, format string, args ...any) {
+	audit.Recordf(audit.UserChanged, "", "", audit.LocalAddr, "user=%s %s", username, fmt.Sprintf(format, args...))
+}
+
+// buildAuditTab: eventos de seguridad de audit_events con filtros y exportación.
+func buildAuditTab(w fyne.Window) fyne.CanvasObject {
+	var events []audit.Event
+
+	status := widget.NewLabel("")
+
+	const allTypes = "(todos)"
+	typeSel := widget.NewSelect(append([]string{allTypes}, audit.Types...), nil)
+	typeSel.SetSelected(allTypes)
+
+	ranges := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"Últimas 24 h", 24 * time.Hour},
+		{"Últimos 7 días", 7 * 24 * time.Hour},
+		{"Últimos 30 días", 30 * 24 * time.Hour},
+		{"Todo", 0},
+	}
+	rangeNames := make([]string, len(ranges))
+	for i, r := range ranges {
+		rangeNames[i] = r.name
+	}
+	rangeSel := widget.NewSelect(rangeNames, nil)
+	rangeSel.SetSelected(ranges[1].name)
+
+	userEntry := widget.NewEntry()
+	userEntry.SetPlaceHolder("usuario")
+	textEntry := widget.NewEntry()
+	textEntry.SetPlaceHolder("texto en detalle / IP / sesión")
+
+	filter := func() audit.Filter {
+		f := audit.Filter{Username: userEntry.Text, Text: textEntry.Text}
+		if typeSel.Selected != allTypes {
+			f.Type = typeSel.Selected
+		}
+		for _, r := range ranges {
+			if r.name == rangeSel.Selected && r.d > 0 {
+				f.Since = time.Now().Add(-r.d)
+			}
+		}
+		return f
+	}
+
+	list := widget.NewList(
+		func() int { return len(events) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			e := events[i]
+			parts := []string{e.Time().Format("2006-01-02 15:04:05"), e.Type}
+			if e.Username != "" {
+				parts = append(parts, "user="+e.Username)
+			}
+			if e.RemoteAddr != "" {
+				parts = append(parts, e.RemoteAddr)
+			}
+			if e.Details != "" {
+				parts = append(parts, e.Details)
+			}
+			obj.(*widget.Label).SetText(strings.Join(parts, " — "))
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		e := events[id]
+		txt := widget.NewMultiLineEntry()
+		txt.SetText(fmt.Sprintf("Hora: %s\nTipo: %s\nUsuario: %s\nOrigen: %s\nSesión: %s\n\n%s",
+			e.Time().Format(time.RFC3339), e.Type, e.Username, e.RemoteAddr, e.SessionID, e.Details))
+		txt.Wrapping = fyne.TextWrapWord
+		d := dialog.NewCustom(fmt.Sprintf("Evento #%d", e.ID), "Cerrar", txt, w)
+		d.Resize(fyne.NewSize(560, 320))
+		d.Show()
+		list.UnselectAll()
+	}
+
+	reload := func() {
+		f := filter()
+		f.Limit = maxAuditRows
+		es, err := audit.Query(f)
+		if err != nil {
+			status.SetText("Error cargando auditoría: " + err.Error())
+			return
+		}
+		events = es
+		list.Refresh()
+		msg := fmt.Sprintf("%d evento(s)", len(events))
+		if len(events) == maxAuditRows {
+			msg += fmt.Sprintf(" (se muestran los %d más nuevos; exportar incluye todos)", maxAuditRows)
+		}
+		status.SetText(msg)
+	}
+
+	export := func(ext string, write func(*bytes.Buffer, []audit.Event) error) {
+		es, err := audit.Query(filter())
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		var buf bytes.Buffer
+		if err := write(&buf, es); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		d := dialog.NewFileSave(func(wc fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if wc == nil {
+				return
+			}
+			defer wc.Close()
+			if _, err := wc.Write(buf.Bytes()); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			status.SetText(fmt.Sprintf("Exportados %d evento(s) ✅", len(es)))
+		}, w)
+		d.SetFileName("deskcontrol-auditoria-" + time.Now().Format("20060102-1504") + ext)
+		d.Show()
+	}
+
+	btnSearch := widget.NewButton("Buscar", reload)
+	btnCSV := widget.NewButton("Exportar CSV", func() {
+		export(".csv", func(b *bytes.Buffer, es []audit.Event) error { return audit.WriteCSV(b, es) })
+	})
+	btnJSON := widget.NewButton("Exportar JSON", func() {
+		export(".json", func(b *bytes.Buffer, es []audit.Event) error { return audit.WriteJSON(b, es) })
+	})
+
+	reload()
+
+	filters := container.NewGridWithColumns(4, typeSel, rangeSel, userEntry, textEntry)
+
+	return container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Auditoría", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			filters,
+			container.NewHBox(btnSearch, btnCSV, btnJSON),
+		),
+		status, nil, nil,
+		list,
+	)
+}
@@ -6,47 +6,23 @@ import (
 	"strconv"
 	"strings"
 
+	"deskcontrol/daemon/internal/config"
 	"deskcontrol/daemon/internal/startup"
+	"deskcontrol/daemon/internal/ws"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func showPairQRDialog(title string, png []byte, payload string, w fyne.Window) {
-	res := fyne.NewStaticResource("pair.png", png)
-	img := canvas.NewImageFromResource(res)
-	img.FillMode = canvas.ImageFillContain
-	img.SetMinSize(fyne.NewSize(320, 320))
-
-	entry := widget.NewMultiLineEntry()
-	entry.SetText(payload)
-	entry.Disable()
-
-	btnCopy := widget.NewButton("Copiar", func() {
-		w.Clipboard().SetContent(payload)
-	})
-
-	content := container.NewVBox(
-		widget.NewLabelWithStyle("Escanea para emparejar", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
-		container.NewCenter(img),
-		widget.NewLabel("Texto (por si el QR falla):"),
-		entry,
-		btnCopy,
-	)
-
-	dialog.NewCustom(title, "Cerrar", content, w).Show()
-}
-
 func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 	// ---- Load current config ----
-	cfg, err := LoadConfig()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("[config] LoadConfig error: %v", err)
-		cfg = defaultConfig()
+		log.Printf("[config] config.Load error: %v", err)
+		cfg = config.Default()
 	}
 
 	// ---- Autostart status ----
@@ -111,6 +87,10 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 	entryUDPPort := widget.NewEntry()
 	entryUDPPort.SetText(strconv.Itoa(cfg.UDPPort))
 
+	entryDiscName := widget.NewEntry()
+	entryDiscName.SetPlaceHolder("(nombre del equipo)")
+	entryDiscName.SetText(cfg.DiscoveryName)
+
 	checkTLS := widget.NewCheck("Cifrar tráfico (TLS / wss) — si está activado, ws:// NO conectará", func(bool) {})
 	checkTLS.SetChecked(cfg.EncryptTrafficTLS)
 
@@ -135,38 +115,44 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 
 	checkRequireToken := widget.NewCheck("Requerir token para conectar", func(v bool) {
 		cfg.RequireToken = v
-		_ = SaveConfig(cfg)
+		_ = config.Save(cfg)
 	})
 	checkRequireToken.SetChecked(cfg.RequireToken)
 
-	// ✅ NUEVO: Solo mostrar QR (no regenera token)
-	btnShowQR := widget.NewButton("Ver QR actual", func() {
+	// el token compartido sólo sirve para pair_request; revocar un teléfono no
+	// obliga a regenerarlo
+	checkDeviceCred := widget.NewCheck("Sólo dispositivos emparejados (el token compartido sólo sirve para emparejar)", nil)
+	checkDeviceCred.SetChecked(cfg.RequireDeviceCredential)
+
+	// conexiones nuevas: preguntar en el PC antes de dejarlas usar el mouse/teclado
+	checkApproval := widget.NewCheck("Aprobar en el PC los dispositivos/IPs nuevos", nil)
+	checkApproval.SetChecked(cfg.RequireApproval)
+	entryApprovalTimeout := widget.NewEntry()
+	entryApprovalTimeout.SetText(strconv.Itoa(cfg.ApprovalTimeoutSec))
+
+	// QR con un código de emparejamiento de un solo uso (no regenera token)
+	btnShowQR := widget.NewButton("QR de emparejamiento", func() {
 		if cfg.EncryptTrafficTLS && strings.TrimSpace(cfg.Token) == "" {
 			dialog.ShowInformation("No hay token",
-				"Primero genera un token para emparejar.\n\nDespués reinicia DeskControl para que el daemon use el token actualizado.",
+				"Primero genera un token para emparejar.\n\nEl daemon lo usa apenas se guarda.",
 				w,
 			)
 			return
 		}
 
-		png, payload, err := tokenQRPNGForConfig(cfg)
-		if err != nil {
-			dialog.ShowError(err, w)
-			return
-		}
-		showPairQRDialog("QR de Emparejamiento", png, payload, w)
+		showPairingDialog("QR de Emparejamiento", cfg, w)
 	})
 
-	// ✅ MEJORADO: Genera + QR + alerta reinicio (y ofrece reiniciar)
+	// ✅ MEJORADO: Genera + QR (el daemon toma el token nuevo al guardar)
 	btnGenToken := widget.NewButton("Generar token + QR", func() {
 		dialog.ShowConfirm("Regenerar token",
-			"Esto cambiará el token de emparejamiento.\n\nLuego DEBES reiniciar DeskControl para que el daemon aplique el nuevo token.\n\n¿Deseas continuar?",
+			"Esto cambiará el token compartido.\n\nLos dispositivos emparejados siguen con su propia credencial; los clientes que usan el token compartido tendrán que emparejarse de nuevo.\n\n¿Deseas continuar?",
 			func(ok bool) {
 				if !ok {
 					return
 				}
 
-				tok, err := generateTokenURLSafe(32)
+				tok, err := config.GenerateToken(32)
 				if err != nil {
 					dialog.ShowError(err, w)
 					return
@@ -176,30 +162,13 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 				cfg.RequireToken = true
 				checkRequireToken.SetChecked(true)
 
-				if err := SaveConfig(cfg); err != nil {
+				if err := config.Save(cfg); err != nil {
 					dialog.ShowError(err, w)
 					return
 				}
 				refreshTokenLabel()
 
-				png, payload, err := tokenQRPNGForConfig(cfg)
-				if err != nil {
-					dialog.ShowError(err, w)
-					return
-				}
-				showPairQRDialog("Nuevo QR (token actualizado)", png, payload, w)
-
-				dialog.ShowConfirm("Reinicio requerido",
-					"Token actualizado ✅\n\nPara que el daemon use el nuevo token debes reiniciar DeskControl.\n\n¿Reiniciar ahora?",
-					func(ok2 bool) {
-						if !ok2 {
-							return
-						}
-						if err := restartSelf(); err != nil {
-							dialog.ShowError(err, w)
-						}
-					}, w,
-				)
+				showPairingDialog("Nuevo QR (token actualizado)", cfg, w)
 			},
 			w,
 		)
@@ -208,7 +177,7 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 	// ---- Account (only TLS) ----
 	checkRequireAccount := widget.NewCheck("Requerir cuenta (Basic Auth) — SOLO con TLS", func(v bool) {
 		cfg.RequireAccount = v
-		_ = SaveConfig(cfg)
+		_ = config.Save(cfg)
 	})
 	checkRequireAccount.SetChecked(cfg.RequireAccount)
 
@@ -262,30 +231,56 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 			cfg.RequireAccount = true
 			checkRequireAccount.SetChecked(true)
 
-			if err := SaveConfig(cfg); err != nil {
+			if err := config.Save(cfg); err != nil {
 				dialog.ShowError(err, w)
 				return
 			}
 
-			dialog.ShowConfirm("Cuenta guardada",
-				"Cuenta guardada ✅\n\nDebes reiniciar DeskControl para aplicar.\n\n¿Reiniciar ahora?",
-				func(ok2 bool) {
-					if !ok2 {
-						return
-					}
-					if err := restartSelf(); err != nil {
-						dialog.ShowError(err, w)
-					}
-				}, w)
+			dialog.ShowInformation("Cuenta guardada", "Cuenta guardada ✅\n\nSe aplica a los próximos logins.", w)
 		}, w)
 		d.Resize(fyne.NewSize(420, 260))
 		d.Show()
 	})
 
+	// permisos de quien se conecta sin login (sin TLS o sin "Requerir cuenta")
+	checkGuestPerms := widget.NewCheckGroup(ws.AllPermissions, nil)
+	checkGuestPerms.Horizontal = true
+	checkGuestPerms.SetSelected(strings.Split(cfg.GuestPermissions, ","))
+
+	// intentos de login fallidos
+	entryAuthMax := widget.NewEntry()
+	entryAuthMax.SetPlaceHolder("0 = nunca bloquear")
+	entryAuthMax.SetText(strconv.Itoa(cfg.AuthMaxFailures))
+
+	entryAuthLockout := widget.NewEntry()
+	entryAuthLockout.SetText(strconv.Itoa(cfg.AuthLockoutMin))
+
+	entryAuthSession := widget.NewEntry()
+	entryAuthSession.SetPlaceHolder("0 = nunca cerrar")
+	entryAuthSession.SetText(strconv.Itoa(cfg.AuthSessionMaxFailures))
+
+	// ---- Entrada ----
+	entryHoldTimeout := widget.NewEntry()
+	entryHoldTimeout.SetPlaceHolder("0 = nunca")
+	entryHoldTimeout.SetText(strconv.Itoa(cfg.HoldTimeoutSec))
+
+	// ---- Conexión (heartbeat) ----
+	entryPing := widget.NewEntry()
+	entryPing.SetPlaceHolder("0 = sin ping")
+	entryPing.SetText(strconv.Itoa(cfg.PingIntervalSec))
+
+	entryIdle := widget.NewEntry()
+	entryIdle.SetPlaceHolder("0 = nunca")
+	entryIdle.SetText(strconv.Itoa(cfg.IdleTimeoutSec))
+
 	// ---- Logs ----
 	entryRetention := widget.NewEntry()
 	entryRetention.SetText(strconv.Itoa(cfg.LogRetentionDays))
 
+	entryAuditRetention := widget.NewEntry()
+	entryAuditRetention.SetPlaceHolder("0 = guardar siempre")
+	entryAuditRetention.SetText(strconv.Itoa(cfg.AuditRetentionDays))
+
 	btnPurge := widget.NewButton("Purgar logs antiguos ahora", func() {
 		if err := PurgeOldLogs(cfg.LogRetentionDays); err != nil {
 			dialog.ShowError(err, w)
@@ -321,40 +316,60 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 		if n, err := strconv.Atoi(strings.TrimSpace(entryUDPPort.Text)); err == nil {
 			ncfg.UDPPort = n
 		}
+		ncfg.DiscoveryName = strings.TrimSpace(entryDiscName.Text)
 
 		ncfg.EncryptTrafficTLS = checkTLS.Checked
 		ncfg.TLSCertPath = strings.TrimSpace(entryCert.Text)
 		ncfg.TLSKeyPath = strings.TrimSpace(entryKey.Text)
 
 		ncfg.RequireToken = checkRequireToken.Checked
+		ncfg.RequireDeviceCredential = checkDeviceCred.Checked
+		ncfg.RequireApproval = checkApproval.Checked
+		if n, err := strconv.Atoi(strings.TrimSpace(entryApprovalTimeout.Text)); err == nil {
+			ncfg.ApprovalTimeoutSec = n
+		}
 		ncfg.RequireAccount = checkRequireAccount.Checked
+		ncfg.GuestPermissions = strings.Join(checkGuestPerms.Selected, ",")
+		if n, err := strconv.Atoi(strings.TrimSpace(entryAuthMax.Text)); err == nil {
+			ncfg.AuthMaxFailures = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryAuthLockout.Text)); err == nil {
+			ncfg.AuthLockoutMin = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryAuthSession.Text)); err == nil {
+			ncfg.AuthSessionMaxFailures = n
+		}
 
 		if n, err := strconv.Atoi(strings.TrimSpace(entryRetention.Text)); err == nil {
 			ncfg.LogRetentionDays = n
 		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryAuditRetention.Text)); err == nil {
+			ncfg.AuditRetentionDays = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryHoldTimeout.Text)); err == nil {
+			ncfg.HoldTimeoutSec = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryPing.Text)); err == nil {
+			ncfg.PingIntervalSec = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(entryIdle.Text)); err == nil {
+			ncfg.IdleTimeoutSec = n
+		}
 
 		// regla: si TLS se apaga, no permitimos cuentas
 		if !ncfg.EncryptTrafficTLS {
 			ncfg.RequireAccount = false
 		}
 
-		if err := SaveConfig(ncfg); err != nil {
+		if err := config.Save(ncfg); err != nil {
 			dialog.ShowError(err, w)
 			return
 		}
 
 		cfg = ncfg
 		refreshTokenLabel()
-		dialog.ShowConfirm("Configuración",
-			"Guardado ✅\n\nPara aplicar red/TLS/auth debes reiniciar DeskControl.\n\n¿Reiniciar ahora?",
-			func(ok bool) {
-				if !ok {
-					return
-				}
-				if err := restartSelf(); err != nil {
-					dialog.ShowError(err, w)
-				}
-			}, w)
+		dialog.ShowInformation("Configuración",
+			"Guardado ✅\n\nSe aplica en caliente: si cambió IP/puerto/TLS, los clientes conectados reciben aviso para reconectar.", w)
 	}
 
 	btnSave := widget.NewButton("Guardar configuración", saveCfg)
@@ -392,6 +407,7 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 			widget.NewFormItem("IP de escucha", entryListenIP),
 			widget.NewFormItem("Puerto WebSocket", entryWSPort),
 			widget.NewFormItem("Puerto UDP (discovery)", entryUDPPort),
+			widget.NewFormItem("Nombre (discovery)", entryDiscName),
 		),
 		checkTLS,
 		widget.NewForm(
@@ -403,16 +419,36 @@ func buildConfigTab(appRunName string, w fyne.Window) fyne.CanvasObject {
 		widget.NewLabelWithStyle("Emparejamiento (token)", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		tokenLabel,
 		container.NewHBox(btnShowQR, btnGenToken, checkRequireToken),
+		checkDeviceCred,
+		checkApproval,
+		widget.NewForm(
+			widget.NewFormItem("Rechazar si nadie aprueba en (seg.)", entryApprovalTimeout),
+		),
 		widget.NewSeparator(),
 
 		widget.NewLabelWithStyle("Cuenta (solo TLS)", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		checkRequireAccount,
 		btnCreateAccount,
+		widget.NewForm(
+			widget.NewFormItem("Permisos sin login", checkGuestPerms),
+			widget.NewFormItem("Bloquear IP/usuario tras (logins fallidos)", entryAuthMax),
+			widget.NewFormItem("Duración del bloqueo (min.)", entryAuthLockout),
+			widget.NewFormItem("Cerrar la conexión tras (logins fallidos)", entryAuthSession),
+		),
+		widget.NewSeparator(),
+
+		widget.NewLabelWithStyle("Entrada / conexión", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewForm(
+			widget.NewFormItem("Soltar teclas/botones apretados tras (seg. sin actividad)", entryHoldTimeout),
+			widget.NewFormItem("Ping a los clientes cada (seg.)", entryPing),
+			widget.NewFormItem("Cortar sesión sin tráfico tras (seg.)", entryIdle),
+		),
 		widget.NewSeparator(),
 
 		widget.NewLabelWithStyle("Logs", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		widget.NewForm(
 			widget.NewFormItem("Borrar logs después de (días)", entryRetention),
+			widget.NewFormItem("Borrar auditoría después de (días)", entryAuditRetention),
 		),
 		container.NewHBox(btnPurge, btnDeleteAllLogs),
 		widget.NewSeparator(),
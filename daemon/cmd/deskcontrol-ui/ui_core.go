@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/config"
+	"deskcontrol/daemon/internal/discovery"
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+	"deskcontrol/daemon/internal/ws"
+)
+
+// coreState es el "core" del daemon (WS + discovery UDP) que corre dentro de la UI.
+// La config se aplica en caliente (applyLocked): token/cuenta/nombre/logs sin
+// cortar a nadie y los cambios de listener migrando con aviso a los clientes.
+type coreState struct {
+	mu     sync.Mutex
+	driver input.InputDriver
+	wsSrv  *ws.Server
+	disc   *discovery.Responder
+	cfg    config.AppConfig
+}
+
+var core = &coreState{}
+
+const (
+	// shutdownTimeout: cuánto esperamos a que las sesiones se cierren al parar el core
+	shutdownTimeout = 3 * time.Second
+
+	// reconnectGrace: tiempo entre el aviso "status: reconnect" y el cambio de listener
+	reconnectGrace = 1500 * time.Millisecond
+
+	// configPollInterval: cada cuánto miramos si la config cambió desde afuera
+	configPollInterval = 3 * time.Second
+)
+
+// startCoreFromConfig carga la config guardada y levanta WS + UDP.
+func startCoreFromConfig() error {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("[ui] config.Load error: %v (usando default)", err)
+		cfg = config.Default()
+	}
+
+	core.mu.Lock()
+	defer core.mu.Unlock()
+	if core.driver == nil {
+		core.driver = input.New()
+	}
+
+	log.Printf("[core] running WS=%s UDP=%d (bind=%s) tls=%v token=%v account=%v",
+		cfg.WSAddr(), cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS, cfg.RequireToken, cfg.RequireAccount)
+
+	core.cfg = cfg
+	srv, err := startWS(core.driver, cfg)
+	if err != nil {
+		return err
+	}
+	core.wsSrv = srv
+	core.startDiscoveryLocked(cfg)
+	return nil
+}
+
+// startWS levanta el listener WS con todo lo que sale de cfg.
+func startWS(driver input.InputDriver, cfg config.AppConfig) (*ws.Server, error) {
+	srv := cfg.NewServer(driver)
+	srv.SetApprovalPrompt(promptApproval)
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// startDiscoveryLocked: discovery es opcional, sin él el teléfono igual conecta por IP/QR.
+func (c *coreState) startDiscoveryLocked(cfg config.AppConfig) {
+	disc, err := discovery.StartUDP(cfg.DiscoveryAnnounce(), cfg.WSPort, cfg.UDPPort, cfg.ListenIP, cfg.EncryptTrafficTLS)
+	if err != nil {
+		log.Printf("[core] discovery error: %v", err)
+		return
+	}
+	c.disc = disc
+}
+
+func (c *coreState) stopDiscoveryLocked(ctx context.Context) {
+	if c.disc == nil {
+		return
+	}
+	if err := c.disc.Shutdown(ctx); err != nil {
+		log.Printf("[core] discovery shutdown: %v", err)
+	}
+	c.disc = nil
+}
+
+func shutdownWS(srv *ws.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[core] ws shutdown: %v", err)
+	}
+}
+
+// stopCore detiene WS + UDP (al salir de la app).
+func stopCore() {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	core.stopDiscoveryLocked(ctx)
+	if core.wsSrv != nil {
+		shutdownWS(core.wsSrv)
+		core.wsSrv = nil
+	}
+}
+
+// applyConfig aplica cfg al core que está corriendo.
+func applyConfig(cfg config.AppConfig) error {
+	core.mu.Lock()
+	defer core.mu.Unlock()
+	return core.applyLocked(cfg)
+}
+
+func (c *coreState) applyLocked(cfg config.AppConfig) error {
+	prev := c.cfg
+
+	if c.wsSrv == nil {
+		// el core no llegó a levantar (p.ej. puerto ocupado al inicio): reintentar
+		srv, err := startWS(c.driver, cfg)
+		if err != nil {
+			return err
+		}
+		c.wsSrv = srv
+	} else if config.ListenerChanged(prev, cfg) {
+		if err := c.moveListenerLocked(prev, cfg); err != nil {
+			return err
+		}
+	} else {
+		if !reflect.DeepEqual(prev.Security(), cfg.Security()) {
+			c.wsSrv.SetSecurity(cfg.Security())
+		}
+		if prev.HoldTimeoutSec != cfg.HoldTimeoutSec {
+			c.wsSrv.SetHoldTimeout(cfg.HoldTimeout())
+		}
+		if prev.PingIntervalSec != cfg.PingIntervalSec || prev.IdleTimeoutSec != cfg.IdleTimeoutSec {
+			c.wsSrv.SetHeartbeat(cfg.Heartbeat())
+		}
+		if prev.AuthLimits() != cfg.AuthLimits() {
+			c.wsSrv.SetAuthLimits(cfg.AuthLimits())
+		}
+	}
+
+	// ---- discovery ----
+	if c.disc == nil || prev.UDPPort != cfg.UDPPort || prev.ListenIP != cfg.ListenIP {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		c.stopDiscoveryLocked(ctx)
+		cancel()
+		c.startDiscoveryLocked(cfg)
+	} else {
+		c.disc.SetAnnounce(cfg.DiscoveryAnnounce(), cfg.WSPort, cfg.EncryptTrafficTLS)
+	}
+
+	// ---- logs ----
+	if prev.LogRetentionDays != cfg.LogRetentionDays {
+		if err := PurgeOldLogs(cfg.LogRetentionDays); err != nil {
+			log.Printf("[core] PurgeOldLogs error: %v", err)
+		}
+	}
+	if prev.AuditRetentionDays != cfg.AuditRetentionDays {
+		if _, err := audit.Purge(cfg.AuditRetentionDays); err != nil {
+			log.Printf("[core] audit.Purge error: %v", err)
+		}
+	}
+
+	c.cfg = cfg
+	log.Printf("[core] config applied ✅ WS=%s UDP=%d tls=%v token=%v account=%v name=%q",
+		cfg.WSAddr(), cfg.UDPPort, cfg.EncryptTrafficTLS, cfg.RequireToken, cfg.RequireAccount, cfg.DiscoveryAnnounce())
+	return nil
+}
+
+// moveListenerLocked pasa el WS a la nueva dirección/TLS avisando a los clientes.
+// Si la dirección es otra, el listener nuevo se abre ANTES de cerrar el viejo;
+// si es la misma (p.ej. sólo TLS on/off) hay que cerrar primero.
+func (c *coreState) moveListenerLocked(prev, cfg config.AppConfig) error {
+	old := c.wsSrv
+	newAddr := cfg.WSAddr()
+	sameAddr := prev.WSAddr() == newAddr
+
+	var next *ws.Server
+	if !sameAddr {
+		srv, err := startWS(c.driver, cfg)
+		if err != nil {
+			return err
+		}
+		next = srv
+	}
+
+	n := old.Broadcast(protocol.Status{
+		Type:   protocol.TypeStatus,
+		State:  protocol.StatusReconnect,
+		Reason: "config",
+		Port:   cfg.WSPort,
+		TLS:    cfg.EncryptTrafficTLS,
+		InMs:   int(reconnectGrace / time.Millisecond),
+	})
+	log.Printf("[core] listener change %s -> %s (tls=%v): %d client(s) notified",
+		prev.WSAddr(), newAddr, cfg.EncryptTrafficTLS, n)
+	if n > 0 {
+		time.Sleep(reconnectGrace)
+	}
+
+	shutdownWS(old)
+
+	if next == nil {
+		srv, err := startWS(c.driver, cfg)
+		if err != nil {
+			log.Printf("[core] new listener failed: %v (restoring previous)", err)
+			if back, err2 := startWS(c.driver, prev); err2 == nil {
+				c.wsSrv = back
+			} else {
+				log.Printf("[core] restoring previous listener failed: %v", err2)
+				c.wsSrv = nil
+			}
+			return err
+		}
+		next = srv
+	}
+	c.wsSrv = next
+	return nil
+}
+
+// watchConfig aplica la config cada vez que config.Save guarda o que la tabla
+// settings cambia desde afuera (se revisa cada configPollInterval).
+func watchConfig(ctx context.Context) {
+	last, err := config.Fingerprint()
+	if err != nil {
+		log.Printf("[core] settings fingerprint error: %v", err)
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-config.Saved():
+		case <-ticker.C:
+		}
+
+		fp, err := config.Fingerprint()
+		if err != nil || fp == last {
+			continue
+		}
+		last = fp
+
+		cfg, err := config.Load()
+		if err != nil {
+			log.Printf("[core] reload: LoadConfig error: %v", err)
+			continue
+		}
+		log.Printf("[core] config change detected, applying…")
+		if err := applyConfig(cfg); err != nil {
+			log.Printf("[core] reload error: %v", err)
+		}
+	}
+}
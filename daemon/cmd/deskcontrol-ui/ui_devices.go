@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"deskcontrol/daemon/internal/ws"
+)
+
+// buildDevicesTab: teléfonos emparejados (tabla devices), cada uno con su
+// credencial. Revocar uno corta sus sesiones sin tocar a los demás.
+func buildDevicesTab(w fyne.Window) fyne.CanvasObject {
+	var devices []ws.Device
+	online := map[string]int{}
+	selected := -1
+
+	status := widget.NewLabel("")
+
+	fmtTime := func(unix int64) string {
+		if unix <= 0 {
+			return "nunca"
+		}
+		return time.Unix(unix, 0).Format("2006-01-02 15:04")
+	}
+
+	list := widget.NewList(
+		func() int { return len(devices) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			d := devices[i]
+			txt := fmt.Sprintf("%s — emparejado: %s — último uso: %s", d.Name, fmtTime(d.CreatedAt), fmtTime(d.LastUsedAt))
+			switch {
+			case d.Revoked():
+				txt += " — REVOCADO " + fmtTime(d.RevokedAt)
+			case online[d.ID] > 0:
+				txt += fmt.Sprintf(" — conectado (%d)", online[d.ID])
+			}
+			obj.(*widget.Label).SetText(txt)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) { selected = id }
+	list.OnUnselected = func(widget.ListItemID) { selected = -1 }
+
+	reload := func() {
+		ds, err := ws.ListDevices()
+		if err != nil {
+			status.SetText("Error cargando dispositivos: " + err.Error())
+			return
+		}
+		devices = ds
+		online = map[string]int{}
+		for _, s := range ws.ListSessions() {
+			if s.DeviceID != "" {
+				online[s.DeviceID]++
+			}
+		}
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		active := 0
+		for _, d := range devices {
+			if !d.Revoked() {
+				active++
+			}
+		}
+		status.SetText(fmt.Sprintf("%d dispositivo(s) activo(s), %d conectado(s)", active, len(online)))
+	}
+
+	current := func() (ws.Device, bool) {
+		if selected < 0 || selected >= len(devices) {
+			dialog.ShowInformation("Dispositivos", "Selecciona un dispositivo primero.", w)
+			return ws.Device{}, false
+		}
+		return devices[selected], true
+	}
+
+	btnRefresh := widget.NewButton("Refrescar", reload)
+
+	btnRename := widget.NewButton("Renombrar", func() {
+		d, ok := current()
+		if !ok {
+			return
+		}
+		name := widget.NewEntry()
+		name.SetText(d.Name)
+		dialog.ShowForm("Renombrar dispositivo", "Guardar", "Cancelar",
+			[]*widget.FormItem{widget.NewFormItem("Nombre", name)},
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := ws.RenameDevice(d.ID, name.Text); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				reload()
+			}, w)
+	})
+
+	btnRevoke := widget.NewButton("Revocar", func() {
+		d, ok := current()
+		if !ok {
+			return
+		}
+		if d.Revoked() {
+			dialog.ShowInformation("Dispositivos", "Ese dispositivo ya está revocado.", w)
+			return
+		}
+		msg := fmt.Sprintf("¿Revocar %q?\n\nSu credencial deja de servir y se cortan sus conexiones abiertas.\nPara volver a usarlo hay que emparejarlo de nuevo.", d.Name)
+		dialog.ShowConfirm("Revocar dispositivo", msg, func(ok bool) {
+			if !ok {
+				return
+			}
+			n, err := ws.RevokeDevice(d.ID)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+			status.SetText(fmt.Sprintf("%q revocado, %d sesión(es) cortada(s) ✅", d.Name, n))
+		}, w)
+	})
+
+	btnDelete := widget.NewButton("Borrar", func() {
+		d, ok := current()
+		if !ok {
+			return
+		}
+		dialog.ShowConfirm("Borrar dispositivo", fmt.Sprintf("¿Borrar %q de la lista?", d.Name), func(ok bool) {
+			if !ok {
+				return
+			}
+			if _, err := ws.DeleteDevice(d.ID); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+		}, w)
+	})
+
+	reload()
+
+	help := widget.NewLabel("Cada teléfono recibe su propia credencial al emparejarse con el QR (código de un solo uso, sólo con TLS).\n" +
+		"Con 'Sólo dispositivos emparejados' (pestaña Config) el token compartido ya no da acceso por sí solo.")
+	help.Wrapping = fyne.TextWrapWord
+
+	devicesPanel := container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Dispositivos", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			help,
+			container.NewHBox(btnRefresh, btnRename, btnRevoke, btnDelete),
+		),
+		status, nil, nil,
+		list,
+	)
+
+	split := container.NewVSplit(devicesPanel, buildApprovedPanel(w))
+	split.Offset = 0.65
+	return split
+}
+
+// buildApprovedPanel: dispositivos/IPs con "Permitir siempre" (tabla
+// approved_clients); sólo importa con 'Aprobar en el PC' activado.
+func buildApprovedPanel(w fyne.Window) fyne.CanvasObject {
+	var approved []ws.ApprovedClient
+	selected := -1
+
+	status := widget.NewLabel("")
+
+	list := widget.NewList(
+		func() int { return len(approved) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			a := approved[i]
+			kind := "IP"
+			if a.Kind == "device" {
+				kind = "Dispositivo"
+			}
+			txt := fmt.Sprintf("%s %s", kind, a.Value)
+			if a.Name != "" {
+				txt += fmt.Sprintf(" (%s)", a.Name)
+			}
+			txt += " — desde " + time.Unix(a.CreatedAt, 0).Format("2006-01-02 15:04")
+			if a.LastIP != "" && a.Kind == "device" {
+				txt += " — IP " + a.LastIP
+			}
+			obj.(*widget.Label).SetText(txt)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) { selected = id }
+	list.OnUnselected = func(widget.ListItemID) { selected = -1 }
+
+	reload := func() {
+		as, err := ws.ListApprovedClients()
+		if err != nil {
+			status.SetText("Error cargando aprobados: " + err.Error())
+			return
+		}
+		approved = as
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		status.SetText(fmt.Sprintf("%d aprobado(s)", len(approved)))
+	}
+
+	btnRefresh := widget.NewButton("Refrescar", reload)
+
+	btnForget := widget.NewButton("Volver a preguntar", func() {
+		if selected < 0 || selected >= len(approved) {
+			dialog.ShowInformation("Aprobados", "Selecciona uno primero.", w)
+			return
+		}
+		if err := ws.ForgetApprovedClient(approved[selected].Key); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		reload()
+	})
+
+	reload()
+
+	return container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Aprobados siempre (no piden aprobación)", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			container.NewHBox(btnRefresh, btnForget),
+		),
+		status, nil, nil,
+		list,
+	)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"deskcontrol/daemon/internal/ws"
+)
+
+// buildMacrosTab: macros guardadas en el daemon (las mismas que ve el teléfono
+// con macro_list). Se pueden ver, renombrar, borrar, importar y exportar en JSON.
+func buildMacrosTab(w fyne.Window) fyne.CanvasObject {
+	var macros []ws.Macro
+	selected := -1
+
+	status := widget.NewLabel("")
+
+	list := widget.NewList(
+		func() int { return len(macros) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			m := macros[i]
+			upd := time.Unix(m.UpdatedAt, 0).Format("2006-01-02 15:04")
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s — %d paso(s) — %s", m.Name, len(m.Steps), upd))
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) { selected = id }
+	list.OnUnselected = func(widget.ListItemID) { selected = -1 }
+
+	reload := func() {
+		ms, err := ws.ListMacros()
+		if err != nil {
+			status.SetText("Error cargando macros: " + err.Error())
+			return
+		}
+		macros = ms
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		status.SetText(fmt.Sprintf("%d macro(s)", len(macros)))
+	}
+
+	current := func() (ws.Macro, bool) {
+		if selected < 0 || selected >= len(macros) {
+			dialog.ShowInformation("Macros", "Selecciona una macro primero.", w)
+			return ws.Macro{}, false
+		}
+		return macros[selected], true
+	}
+
+	btnRefresh := widget.NewButton("Refrescar", reload)
+
+	btnView := widget.NewButton("Ver JSON", func() {
+		m, ok := current()
+		if !ok {
+			return
+		}
+		b, _ := json.MarshalIndent(m.Steps, "", "  ")
+		txt := widget.NewMultiLineEntry()
+		txt.SetText(string(b))
+		txt.Wrapping = fyne.TextWrapOff
+		d := dialog.NewCustom(m.Name, "Cerrar", container.NewVScroll(txt), w)
+		d.Resize(fyne.NewSize(600, 450))
+		d.Show()
+	})
+
+	btnRename := widget.NewButton("Renombrar", func() {
+		m, ok := current()
+		if !ok {
+			return
+		}
+		e := widget.NewEntry()
+		e.SetText(m.Name)
+		dialog.ShowForm("Renombrar macro", "Guardar", "Cancelar",
+			[]*widget.FormItem{widget.NewFormItem("Nombre", e)},
+			func(ok bool) {
+				if !ok || strings.TrimSpace(e.Text) == m.Name {
+					return
+				}
+				if err := ws.RenameMacro(m.Name, e.Text); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				reload()
+			}, w)
+	})
+
+	btnDelete := widget.NewButton("Borrar", func() {
+		m, ok := current()
+		if !ok {
+			return
+		}
+		dialog.ShowConfirm("Borrar macro", fmt.Sprintf("¿Borrar la macro %q?", m.Name), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := ws.DeleteMacro(m.Name); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+		}, w)
+	})
+
+	btnImport := widget.NewButton("Importar JSON", func() {
+		dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if r == nil {
+				return
+			}
+			defer r.Close()
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			in, err := parseMacrosJSON(data)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			for _, m := range in {
+				if err := ws.ValidateMacro(ws.DefaultRegistry, m); err != nil {
+					dialog.ShowError(fmt.Errorf("macro %q: %w", m.Name, err), w)
+					return
+				}
+			}
+
+			dialog.ShowConfirm("Importar macros",
+				fmt.Sprintf("Se importarán %d macro(s).\n\nLas que tengan el mismo nombre se reemplazan.\n\n¿Continuar?", len(in)),
+				func(ok bool) {
+					if !ok {
+						return
+					}
+					for _, m := range in {
+						if err := ws.SaveMacro(m); err != nil {
+							dialog.ShowError(fmt.Errorf("macro %q: %w", m.Name, err), w)
+							break
+						}
+					}
+					reload()
+				}, w)
+		}, w)
+	})
+
+	btnExport := widget.NewButton("Exportar JSON", func() {
+		if len(macros) == 0 {
+			dialog.ShowInformation("Macros", "No hay macros para exportar.", w)
+			return
+		}
+		out := macros
+		if selected >= 0 && selected < len(macros) {
+			out = macros[selected : selected+1]
+		}
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		d := dialog.NewFileSave(func(wc fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if wc == nil {
+				return
+			}
+			defer wc.Close()
+			if _, err := wc.Write(b); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			status.SetText(fmt.Sprintf("Exportadas %d macro(s) ✅", len(out)))
+		}, w)
+		d.SetFileName("deskcontrol-macros.json")
+		d.Show()
+	})
+
+	reload()
+
+	help := widget.NewLabel("Las macros se crean desde el teléfono (macro_save) o importando JSON.\n" +
+		"Exportar: si hay una macro seleccionada se exporta sólo esa; si no, todas.")
+	help.Wrapping = fyne.TextWrapWord
+
+	return container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Macros", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			help,
+			container.NewHBox(btnRefresh, btnView, btnRename, btnDelete, btnImport, btnExport),
+		),
+		status, nil, nil,
+		list,
+	)
+}
+
+// parseMacrosJSON acepta una lista de macros o una sola macro.
+func parseMacrosJSON(data []byte) ([]ws.Macro, error) {
+	var list []ws.Macro
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+	var one ws.Macro
+	if err := json.Unmarshal(data, &one); err != nil {
+		return nil, fmt.Errorf("JSON de macros inválido: %w", err)
+	}
+	return []ws.Macro{one}, nil
+}
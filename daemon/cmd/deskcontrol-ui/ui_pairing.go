@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"deskcontrol/daemon/internal/config"
+	"deskcontrol/daemon/internal/pairing"
+	"deskcontrol/daemon/internal/ws"
+)
+
+// showPairingDialog muestra el QR de emparejamiento. Con TLS pide un código
+// de un solo uso (ws.NewPairingCode) con cuenta regresiva y botón para otro;
+// al cerrar el diálogo el código se anula.
+func showPairingDialog(title string, cfg config.AppConfig, w fyne.Window) {
+	img := canvas.NewImageFromResource(nil)
+	img.FillMode = canvas.ImageFillContain
+	img.SetMinSize(fyne.NewSize(320, 320))
+
+	entry := widget.NewMultiLineEntry()
+	entry.Disable()
+	var payload string
+
+	codeLabel := widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{Bold: true, Monospace: true})
+	countdown := widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{})
+
+	var code ws.PairingCode
+	render := func() error {
+		secret := ""
+		if cfg.EncryptTrafficTLS {
+			pc, err := ws.NewPairingCode(ws.DefaultPairingTTL)
+			if err != nil {
+				return err
+			}
+			code, secret = pc, pc.Secret
+			codeLabel.SetText("Código: " + pc.Code[:3] + " " + pc.Code[3:])
+		}
+		png, p, err := pairing.QRPNG(cfg, secret)
+		if err != nil {
+			return err
+		}
+		payload = p
+		img.Resource = fyne.NewStaticResource("pair.png", png)
+		img.Refresh()
+		entry.SetText(payload)
+		return nil
+	}
+	refreshCountdown := func() {
+		if !cfg.EncryptTrafficTLS {
+			return
+		}
+		if _, ok := ws.CurrentPairingCode(); !ok {
+			countdown.SetText("Código vencido o ya usado — pide uno nuevo")
+			return
+		}
+		left := code.Remaining().Round(time.Second)
+		countdown.SetText(fmt.Sprintf("Vence en %d:%02d", int(left.Minutes()), int(left.Seconds())%60))
+	}
+
+	if err := render(); err != nil {
+		dialog.ShowError(err, w)
+		return
+	}
+	refreshCountdown()
+
+	btnCopy := widget.NewButton("Copiar", func() {
+		w.Clipboard().SetContent(payload)
+	})
+	btnNew := widget.NewButton("Nuevo código", func() {
+		if err := render(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		refreshCountdown()
+	})
+
+	content := container.NewVBox(
+		widget.NewLabelWithStyle("Escanea para emparejar", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		container.NewCenter(img),
+	)
+	if cfg.EncryptTrafficTLS {
+		content.Add(codeLabel)
+		content.Add(countdown)
+	}
+	content.Add(widget.NewLabel("Texto (por si el QR falla):"))
+	content.Add(entry)
+	if cfg.EncryptTrafficTLS {
+		content.Add(container.NewHBox(btnCopy, btnNew))
+	} else {
+		content.Add(btnCopy)
+	}
+
+	stop := make(chan struct{})
+	d := dialog.NewCustom(title, "Cerrar", content, w)
+	d.SetOnClosed(func() {
+		close(stop)
+		if cfg.EncryptTrafficTLS {
+			ws.CancelPairingCode()
+		}
+	})
+
+	if cfg.EncryptTrafficTLS {
+		ticker := time.NewTicker(time.Second)
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					fyne.CurrentApp().Driver().DoFromGoroutine(refreshCountdown, false)
+				}
+			}
+		}()
+	}
+	d.Show()
+}
@@ -1,49 +1,341 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
+	"golang.org/x/crypto/bcrypt"
+
+	"deskcontrol/daemon/internal/ws"
 )
 
-// buildUsersTab: pestaña dedicada para administración de usuarios.
-// Por ahora es “sólo UI” (placeholder). En el siguiente paso la conectamos a SQLite.
+// buildUsersTab: usuarios de la tabla users (los que usa auth_login con TLS +
+// "Requerir cuenta") con su contraseña, estado y permisos.
 func buildUsersTab(w fyne.Window) fyne.CanvasObject {
-	info := widget.NewLabel(
-		"Usuarios (solo TLS)\n\n" +
-			"Meta:\n" +
-			"• Crear/editar/desactivar usuarios\n" +
-			"• Ver conexiones activas y cortar sesiones\n" +
-			"• Solo aplica si TLS está activado y 'Requerir login' está ON\n",
+	var users []UserRecord
+	selected := -1
+
+	status := widget.NewLabel("")
+
+	list := widget.NewList(
+		func() int { return len(users) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			u := users[i]
+			last := "nunca"
+			if u.LastLoginAt > 0 {
+				last = time.Unix(u.LastLoginAt, 0).Format("2006-01-02 15:04")
+			}
+			txt := fmt.Sprintf("%s — permisos: %s — último login: %s", u.Username, permsLabel(u.Permissions), last)
+			if u.Disabled {
+				txt += " — DESACTIVADO"
+			}
+			obj.(*widget.Label).SetText(txt)
+		},
 	)
+	list.OnSelected = func(id widget.ListItemID) { selected = id }
+	list.OnUnselected = func(widget.ListItemID) { selected = -1 }
+
+	reload := func() {
+		us, err := LoadUsers()
+		if err != nil {
+			status.SetText("Error cargando usuarios: " + err.Error())
+			return
+		}
+		users = us
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		status.SetText(fmt.Sprintf("%d usuario(s)", len(users)))
+	}
+
+	current := func() (UserRecord, bool) {
+		if selected < 0 || selected >= len(users) {
+			dialog.ShowInformation("Usuarios", "Selecciona un usuario primero.", w)
+			return UserRecord{}, false
+		}
+		return users[selected], true
+	}
 
-	btnHow := widget.NewButton("¿Qué falta para que funcione?", func() {
-		dialog.ShowInformation(
-			"Pendiente",
-			"En el siguiente paso vamos a:\n\n"+
-				"1) Crear una tabla users en SQLite\n"+
-				"2) Agregar CRUD aquí (crear/listar/desactivar/borrar)\n"+
-				"3) Cambiar el daemon para autenticar por WS (auth_login) usando esa tabla\n"+
-				"   (más escalable que BasicAuth HTTP).\n",
-			w,
+	btnRefresh := widget.NewButton("Refrescar", reload)
+
+	btnNew := widget.NewButton("Nuevo", func() {
+		u := widget.NewEntry()
+		u.SetPlaceHolder("usuario")
+		p1 := widget.NewPasswordEntry()
+		p1.SetPlaceHolder("contraseña")
+		p2 := widget.NewPasswordEntry()
+		p2.SetPlaceHolder("repetir contraseña")
+		perms, permsBox := permissionsEditor(ws.PermAdmin)
+
+		form := widget.NewForm(
+			widget.NewFormItem("Usuario", u),
+			widget.NewFormItem("Contraseña", p1),
+			widget.NewFormItem("Confirmar", p2),
+			widget.NewFormItem("Permisos", permsBox),
 		)
+		d := dialog.NewCustomConfirm("Nuevo usuario", "Crear", "Cancelar", form, func(ok bool) {
+			if !ok {
+				return
+			}
+			name := strings.TrimSpace(u.Text)
+			if name == "" {
+				dialog.ShowError(fmt.Errorf("usuario requerido"), w)
+				return
+			}
+			for _, x := range users {
+				if strings.EqualFold(x.Username, name) {
+					dialog.ShowError(fmt.Errorf("ya existe el usuario %q", x.Username), w)
+					return
+				}
+			}
+			hash, err := hashPassword(p1.Text, p2.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			rec := UserRecord{Username: name, PasswordHash: hash, Permissions: strings.Join(perms.Selected, ",")}
+			if err := UpsertUser(rec); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+		}, w)
+		d.Resize(fyne.NewSize(520, 340))
+		d.Show()
 	})
 
-	btnGoConfig := widget.NewButton("Ir a Config (activar TLS / login)", func() {
-		dialog.ShowInformation(
-			"Tip",
-			"Activa TLS y luego habilita 'Requerir login' (lo vamos a mover a Config).\n"+
-				"Después creas usuarios aquí.",
-			w,
-		)
+	btnPerms := widget.NewButton("Permisos", func() {
+		u, ok := current()
+		if !ok {
+			return
+		}
+		perms, permsBox := permissionsEditor(u.Permissions)
+		d := dialog.NewCustomConfirm("Permisos de "+u.Username, "Guardar", "Cancelar", permsBox, func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := SetUserPermissions(u.Username, strings.Join(perms.Selected, ",")); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+		}, w)
+		d.Resize(fyne.NewSize(520, 240))
+		d.Show()
+	})
+
+	btnPassword := widget.NewButton("Cambiar contraseña", func() {
+		u, ok := current()
+		if !ok {
+			return
+		}
+		p1 := widget.NewPasswordEntry()
+		p2 := widget.NewPasswordEntry()
+		dialog.ShowForm("Contraseña de "+u.Username, "Guardar", "Cancelar",
+			[]*widget.FormItem{
+				widget.NewFormItem("Contraseña", p1),
+				widget.NewFormItem("Confirmar", p2),
+			},
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				hash, err := hashPassword(p1.Text, p2.Text)
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				if err := UpdateUserPassword(u.Username, hash); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				status.SetText("Contraseña actualizada ✅")
+			}, w)
 	})
 
-	return container.NewVScroll(
+	btnToggle := widget.NewButton("Activar/Desactivar", func() {
+		u, ok := current()
+		if !ok {
+			return
+		}
+		if err := SetUserDisabled(u.Username, !u.Disabled); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		reload()
+	})
+
+	btnDelete := widget.NewButton("Borrar", func() {
+		u, ok := current()
+		if !ok {
+			return
+		}
+		dialog.ShowConfirm("Borrar usuario", fmt.Sprintf("¿Borrar el usuario %q?", u.Username), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := DeleteUser(u.Username); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			reload()
+		}, w)
+	})
+
+	reload()
+
+	help := widget.NewLabel("Los usuarios sólo se piden con TLS activado y 'Requerir cuenta' ON (pestaña Config).\n" +
+		"Permisos: media = sólo teclas multimedia; pointer = mouse; keyboard = teclado; apps = apps_list/app_action; " +
+		"macros = guardar/grabar macros; admin = todo. Los cambios de permisos aplican al instante a las sesiones abiertas.")
+	help.Wrapping = fyne.TextWrapWord
+
+	usersPanel := container.NewBorder(
 		container.NewVBox(
 			widget.NewLabelWithStyle("Usuarios", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
-			info,
-			container.NewHBox(btnHow, btnGoConfig),
+			help,
+			container.NewHBox(btnRefresh, btnNew, btnPerms, btnPassword, btnToggle, btnDelete),
 		),
+		status, nil, nil,
+		list,
 	)
+
+	split := container.NewVSplit(usersPanel, buildLockoutsPanel(w))
+	split.Offset = 0.65
+	return split
+}
+
+// buildLockoutsPanel: IPs/usuarios bloqueados por logins fallidos (tabla
+// auth_lockouts), con botón para desbloquear a mano.
+func buildLockoutsPanel(w fyne.Window) fyne.CanvasObject {
+	var locks []ws.Lockout
+	selected := -1
+
+	status := widget.NewLabel("")
+
+	list := widget.NewList(
+		func() int { return len(locks) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, obj fyne.CanvasObject) {
+			l := locks[i]
+			state := "vencido"
+			if l.Active() {
+				state = "bloqueado hasta " + time.Unix(l.LockedUntil, 0).Format("2006-01-02 15:04:05")
+			}
+			kind := "IP"
+			if l.Kind == "user" {
+				kind = "Usuario"
+			}
+			txt := fmt.Sprintf("%s %s — %d fallo(s) — %s", kind, l.Value, l.Failures, state)
+			if l.LastIP != "" && l.Kind == "user" {
+				txt += " — desde " + l.LastIP
+			}
+			obj.(*widget.Label).SetText(txt)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) { selected = id }
+	list.OnUnselected = func(widget.ListItemID) { selected = -1 }
+
+	reload := func() {
+		ls, err := ws.ListLockouts()
+		if err != nil {
+			status.SetText("Error cargando bloqueos: " + err.Error())
+			return
+		}
+		locks = ls
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		active := 0
+		for _, l := range locks {
+			if l.Active() {
+				active++
+			}
+		}
+		status.SetText(fmt.Sprintf("%d bloqueo(s) vigente(s)", active))
+	}
+
+	btnRefresh := widget.NewButton("Refrescar", reload)
+
+	btnUnlock := widget.NewButton("Desbloquear", func() {
+		if selected < 0 || selected >= len(locks) {
+			dialog.ShowInformation("Bloqueos", "Selecciona un bloqueo primero.", w)
+			return
+		}
+		if err := ws.Unlock(locks[selected].Key); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		reload()
+	})
+
+	btnPurge := widget.NewButton("Limpiar vencidos", func() {
+		if _, err := ws.PurgeExpiredLockouts(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		reload()
+	})
+
+	reload()
+
+	return container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Bloqueos por logins fallidos", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			container.NewHBox(btnRefresh, btnUnlock, btnPurge),
+		),
+		status, nil, nil,
+		list,
+	)
+}
+
+// permissionsEditor arma los checks de permisos con un selector de perfiles
+// ("Sólo multimedia", ...) que los completa.
+func permissionsEditor(initial string) (*widget.CheckGroup, fyne.CanvasObject) {
+	checks := widget.NewCheckGroup(ws.AllPermissions, nil)
+	checks.Horizontal = true
+	if perms, err := ws.ParsePermissions(initial); err == nil {
+		checks.SetSelected(perms)
+	}
+
+	names := make([]string, 0, len(ws.PermissionPresets))
+	for _, p := range ws.PermissionPresets {
+		names = append(names, p.Name)
+	}
+	preset := widget.NewSelect(names, func(name string) {
+		for _, p := range ws.PermissionPresets {
+			if p.Name == name {
+				checks.SetSelected(p.Perms)
+			}
+		}
+	})
+	preset.PlaceHolder = "Perfil…"
+
+	return checks, container.NewVBox(preset, checks)
+}
+
+func permsLabel(perms string) string {
+	if strings.TrimSpace(perms) == "" {
+		return "ninguno"
+	}
+	return perms
+}
+
+func hashPassword(p1, p2 string) (string, error) {
+	if p1 == "" || p2 == "" {
+		return "", fmt.Errorf("contraseña requerida")
+	}
+	if p1 != p2 {
+		return "", fmt.Errorf("las contraseñas no coinciden")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(p1), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
 }
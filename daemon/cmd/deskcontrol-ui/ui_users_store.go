@@ -3,11 +3,14 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"deskcontrol/daemon/internal/ws"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -15,6 +18,7 @@ type UserRecord struct {
 	Username     string
 	PasswordHash string
 	Disabled     bool
+	Permissions  string // "media,pointer", ... (ver ws.AllPermissions)
 	CreatedAt    int64
 	LastLoginAt  int64
 }
@@ -69,11 +73,44 @@ CREATE TABLE IF NOT EXISTS users (
   password_hash TEXT NOT NULL,
   disabled INTEGER NOT NULL DEFAULT 0,
   created_at INTEGER NOT NULL,
-  last_login_at INTEGER NOT NULL DEFAULT 0
+  last_login_at INTEGER NOT NULL DEFAULT 0,
+  permissions TEXT NOT NULL DEFAULT 'admin'
 );
 
 CREATE INDEX IF NOT EXISTS idx_users_disabled ON users(disabled);
 `)
+	if err != nil {
+		return err
+	}
+	return ensurePermissionsColumn(db)
+}
+
+// ensurePermissionsColumn: DBs creadas antes de los permisos no tienen la
+// columna; los usuarios que ya estaban quedan como admin.
+func ensurePermissionsColumn(db *sql.DB) error {
+	rows, err := db.Query(`PRAGMA table_info(users);`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			def              sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &def, &pk); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, "permissions") {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN permissions TEXT NOT NULL DEFAULT 'admin';`)
 	return err
 }
 
@@ -85,7 +122,7 @@ func LoadUsers() ([]UserRecord, error) {
 	defer db.Close()
 
 	rows, err := db.Query(`
-SELECT username, password_hash, disabled, created_at, last_login_at
+SELECT username, password_hash, disabled, permissions, created_at, last_login_at
 FROM users
 ORDER BY LOWER(username) ASC;
 `)
@@ -98,7 +135,7 @@ ORDER BY LOWER(username) ASC;
 	for rows.Next() {
 		var u UserRecord
 		var disabledInt int
-		if err := rows.Scan(&u.Username, &u.PasswordHash, &disabledInt, &u.CreatedAt, &u.LastLoginAt); err != nil {
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &disabledInt, &u.Permissions, &u.CreatedAt, &u.LastLoginAt); err != nil {
 			return nil, err
 		}
 		u.Disabled = disabledInt != 0
@@ -115,6 +152,10 @@ func UpsertUser(rec UserRecord) error {
 	if strings.TrimSpace(rec.PasswordHash) == "" {
 		return fmt.Errorf("password_hash requerido")
 	}
+	perms, err := ws.ParsePermissions(rec.Permissions)
+	if err != nil {
+		return err
+	}
 
 	db, err := openUsersDB()
 	if err != nil {
@@ -134,14 +175,18 @@ func UpsertUser(rec UserRecord) error {
 
 	// SQLite UPSERT
 	_, err = db.Exec(`
-INSERT INTO users(username, password_hash, disabled, created_at, last_login_at)
-VALUES (?, ?, ?, ?, COALESCE(NULLIF(?,0),0))
+INSERT INTO users(username, password_hash, disabled, permissions, created_at, last_login_at)
+VALUES (?, ?, ?, ?, ?, COALESCE(NULLIF(?,0),0))
 ON CONFLICT(username) DO UPDATE SET
   password_hash=excluded.password_hash,
-  disabled=excluded.disabled;
-`, rec.Username, rec.PasswordHash, disabledInt, rec.CreatedAt, rec.LastLoginAt)
-
-	return err
+  disabled=excluded.disabled,
+  permissions=excluded.permissions;
+`, rec.Username, rec.PasswordHash, disabledInt, perms.String(), rec.CreatedAt, rec.LastLoginAt)
+	if err != nil {
+		return err
+	}
+	auditUser(rec.Username, "guardado disabled=%v permissions=%s", rec.Disabled, perms)
+	return nil
 }
 
 func SetUserDisabled(username string, disabled bool) error {
@@ -169,6 +214,40 @@ func SetUserDisabled(username string, disabled bool) error {
 	if n == 0 {
 		return fmt.Errorf("usuario no encontrado: %s", username)
 	}
+	auditUser(username, "disabled=%v", disabled)
+	return nil
+}
+
+// SetUserPermissions guarda los permisos y los aplica a las sesiones abiertas
+// del usuario (no hace falta que vuelva a loguearse).
+func SetUserPermissions(username, permissions string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username requerido")
+	}
+	perms, err := ws.ParsePermissions(permissions)
+	if err != nil {
+		return err
+	}
+
+	db, err := openUsersDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`UPDATE users SET permissions=? WHERE username=?;`, perms.String(), username)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("usuario no encontrado: %s", username)
+	}
+	if live := ws.SetUserPermissions(username, perms); live > 0 {
+		log.Printf("[users] permissions user=%q -> %s (sesiones abiertas: %d)", username, perms, live)
+	}
+	auditUser(username, "permissions=%s", perms)
 	return nil
 }
 
@@ -195,6 +274,7 @@ func UpdateUserPassword(username, passwordHash string) error {
 	if n == 0 {
 		return fmt.Errorf("usuario no encontrado: %s", username)
 	}
+	auditUser(username, "contraseña cambiada")
 	return nil
 }
 
@@ -218,5 +298,6 @@ func DeleteUser(username string) error {
 	if n == 0 {
 		return fmt.Errorf("usuario no encontrado: %s", username)
 	}
+	auditUser(username, "borrado")
 	return nil
 }
@@ -0,0 +1,228 @@
+// Package audit guarda eventos de seguridad (logins, cortes de sesión, cambios
+// de config, ...) en la tabla audit_events de deskcontrol.db. A diferencia del
+// log diario, tiene su propia retención y se puede filtrar y exportar.
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Tipos de evento.
+const (
+	Login            = "login"
+	LoginFailed      = "login_failed"
+	Lockout          = "lockout"
+	Unlock           = "unlock"
+	SessionClosed    = "session_closed" // la cortamos nosotros por logins fallidos
+	SessionKick      = "session_kick"   // DropSession desde la UI
+	AppAction        = "app_action"
+	PermissionDenied = "permission_denied"
+	TokenRegenerated = "token_regenerated"
+	ConfigChanged    = "config_changed"
+	UserChanged      = "user_changed"
+	DevicePaired     = "device_paired"
+	DeviceRevoked    = "device_revoked"
+	DeviceChanged    = "device_changed" // renombrado, borrado o sin aprobación
+	ApprovalGranted  = "approval_granted"
+	ApprovalDenied   = "approval_denied"
+)
+
+// Types en el orden en que se muestran en el filtro de la UI.
+var Types = []string{
+	Login, LoginFailed, Lockout, Unlock, SessionClosed, SessionKick,
+	AppAction, PermissionDenied, TokenRegenerated, ConfigChanged, UserChanged,
+	DevicePaired, DeviceRevoked, DeviceChanged, ApprovalGranted, ApprovalDenied,
+}
+
+// LocalAddr es el RemoteAddr de lo que se hizo desde la UI del PC.
+const LocalAddr = "local"
+
+// DefaultRetentionDays: cuánto se guarda si la config no dice otra cosa.
+const DefaultRetentionDays = 90
+
+// Event es una fila de audit_events. At es unix en milisegundos.
+type Event struct {
+	ID         int64  `json:"id"`
+	At         int64  `json:"at"`
+	SessionID  string `json:"session_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Type       string `json:"type"`
+	Details    string `json:"details,omitempty"`
+}
+
+// Time devuelve At como time.Time.
+func (e Event) Time() time.Time { return time.UnixMilli(e.At) }
+
+func dbPath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), "deskcontrol.db"), nil
+}
+
+func openDB() (*sql.DB, error) {
+	p, err := dbPath()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(p))
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS audit_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  at INTEGER NOT NULL,
+  session_id TEXT NOT NULL DEFAULT '',
+  username TEXT NOT NULL DEFAULT '',
+  remote_addr TEXT NOT NULL DEFAULT '',
+  type TEXT NOT NULL,
+  details TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_at ON audit_events(at);
+CREATE INDEX IF NOT EXISTS idx_audit_type ON audit_events(type);
+`)
+	return err
+}
+
+// Record guarda e (At = ahora si viene en 0). Un error sólo se loguea: la
+// auditoría nunca debe hacer fallar lo que se está auditando.
+func Record(e Event) {
+	if e.At == 0 {
+		e.At = time.Now().UnixMilli()
+	}
+	if err := insert(e); err != nil {
+		log.Printf("[audit] record %s error: %v", e.Type, err)
+	}
+}
+
+// Recordf es Record con details formateado.
+func Recordf(typ, sessionID, username, remoteAddr, format string, args ...any) {
+	Record(Event{
+		SessionID:  sessionID,
+		Username:   username,
+		RemoteAddr: remoteAddr,
+		Type:       typ,
+		Details:    fmt.Sprintf(format, args...),
+	})
+}
+
+func insert(e Event) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+INSERT INTO audit_events(at, session_id, username, remote_addr, type, details)
+VALUES (?, ?, ?, ?, ?, ?);
+`, e.At, e.SessionID, e.Username, e.RemoteAddr, e.Type, e.Details)
+	return err
+}
+
+// Filter limita Query. Los campos vacíos/cero no filtran.
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	Type     string
+	Username string // exacto, sin distinguir mayúsculas
+	Text     string // se busca en details, remote_addr y session_id
+	Limit    int    // 0 = sin límite
+}
+
+// Query devuelve los eventos que cumplen f, los más nuevos primero.
+func Query(f Filter) ([]Event, error) {
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var where []string
+	var args []any
+	if !f.Since.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "at < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+	if f.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, f.Type)
+	}
+	if u := strings.TrimSpace(f.Username); u != "" {
+		where = append(where, "username = ? COLLATE NOCASE")
+		args = append(args, u)
+	}
+	if t := strings.TrimSpace(f.Text); t != "" {
+		like := "%" + t + "%"
+		where = append(where, "(details LIKE ? OR remote_addr LIKE ? OR session_id LIKE ?)")
+		args = append(args, like, like, like)
+	}
+
+	q := `SELECT id, at, session_id, username, remote_addr, type, details FROM audit_events`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY at DESC, id DESC"
+	if f.Limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.At, &e.SessionID, &e.Username, &e.RemoteAddr, &e.Type, &e.Details); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Purge borra los eventos con más de retentionDays días (0 = no borra nada).
+func Purge(retentionDays int) (int64, error) {
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).UnixMilli()
+	res, err := db.Exec(`DELETE FROM audit_events WHERE at < ?;`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteCSV exporta events con encabezado; la hora va en RFC3339 local para
+// que se lea sin convertir nada.
+func WriteCSV(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "time", "type", "username", "remote_addr", "session_id", "details"}); err != nil {
+		return err
+	}
+	for _, e := range events {
+		rec := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.Time().Format(time.RFC3339),
+			e.Type,
+			e.Username,
+			e.RemoteAddr,
+			e.SessionID,
+			e.Details,
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON exporta events como un array JSON indentado.
+func WriteJSON(w io.Writer, events []Event) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
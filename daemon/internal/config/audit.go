@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"deskcontrol/daemon/internal/audit"
+)
+
+// secretConfigFields no se escriben en la auditoría, sólo que cambiaron.
+var secretConfigFields = map[string]bool{"Token": true, "PasswordHash": true}
+
+// configDiff lista los campos de AppConfig que cambiaron ("WSPort: 1 -> 2").
+func configDiff(prev, cfg AppConfig) []string {
+	a, b := reflect.ValueOf(prev), reflect.ValueOf(cfg)
+	t := a.Type()
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		x, y := a.Field(i).Interface(), b.Field(i).Interface()
+		if reflect.DeepEqual(x, y) {
+			continue
+		}
+		if secretConfigFields[name] {
+			out = append(out, name+": (cambiado)")
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s: %v -> %v", name, x, y))
+	}
+	return out
+}
+
+// auditChange registra lo que Save cambió. El token nuevo no se
+// guarda, sólo una huella corta para poder correlacionar.
+func auditChange(prev, cfg AppConfig) {
+	if prev.Token != cfg.Token {
+		details := "token borrado"
+		if cfg.Token != "" {
+			sum := sha256.Sum256([]byte(cfg.Token))
+			details = "huella=" + hex.EncodeToString(sum[:4])
+		}
+		audit.Record(audit.Event{Type: audit.TokenRegenerated, RemoteAddr: audit.LocalAddr, Details: details})
+	}
+	if diff := configDiff(prev, cfg); len(diff) > 0 {
+		audit.Record(audit.Event{Type: audit.ConfigChanged, RemoteAddr: audit.LocalAddr, Details: strings.Join(diff, "; ")})
+	}
+}
@@ -0,0 +1,176 @@
+// Package config es la configuración de DeskControl (tabla settings de
+// deskcontrol.db) compartida por la UI y el daemon headless.
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/ws"
+)
+
+type AppConfig struct {
+	ListenIP          string
+	WSPort            int
+	UDPPort           int
+	EncryptTrafficTLS bool
+
+	TLSCertPath string
+	TLSKeyPath  string
+
+	// Pairing / auth
+	Token        string // shared token (QR)
+	RequireToken bool   // si true, WS requiere token
+
+	// si true, el token compartido sólo sirve para emparejar; después cada
+	// dispositivo se conecta con su credencial (pestaña Dispositivos)
+	RequireDeviceCredential bool
+
+	// si true, los dispositivos/IPs nuevos esperan que se los apruebe en el
+	// PC; sin respuesta en ApprovalTimeoutSec se rechazan
+	RequireApproval    bool
+	ApprovalTimeoutSec int
+
+	RequireAccount bool   // si true, WS requiere BasicAuth (solo TLS)
+	Username       string // optional
+	PasswordHash   string // bcrypt hash string
+
+	LogRetentionDays int
+
+	// Días que se guardan los eventos de auditoría (0 = para siempre)
+	AuditRetentionDays int
+
+	// Nombre anunciado por discovery UDP ("" = hostname)
+	DiscoveryName string
+
+	// Segundos sin mensajes tras los cuales se sueltan teclas/botones apretados (0 = nunca)
+	HoldTimeoutSec int
+
+	// Heartbeat: ping del server cada N seg; se corta la sesión tras N seg sin tráfico (0 = off)
+	PingIntervalSec int
+	IdleTimeoutSec  int
+
+	// Permisos de las sesiones sin login ("media,pointer", "admin", ...)
+	GuestPermissions string
+
+	// Fuerza bruta en el login: fallos hasta bloquear IP/usuario (0 = nunca),
+	// minutos de bloqueo y fallos por conexión antes de cerrarla (0 = nunca)
+	AuthMaxFailures        int
+	AuthLockoutMin         int
+	AuthSessionMaxFailures int
+}
+
+// Default es la config de fábrica (lo que no está en settings).
+func Default() AppConfig {
+	return AppConfig{
+		ListenIP:           "0.0.0.0",
+		WSPort:             54545,
+		UDPPort:            54546,
+		EncryptTrafficTLS:  false,
+		TLSCertPath:        "",
+		TLSKeyPath:         "",
+		Token:              "",
+		RequireToken:       false,
+		RequireAccount:     false,
+		Username:           "",
+		PasswordHash:       "",
+		LogRetentionDays:   7,
+		AuditRetentionDays: 90,
+		DiscoveryName:      "",
+		HoldTimeoutSec:     30,
+		PingIntervalSec:    15,
+		IdleTimeoutSec:     60,
+		GuestPermissions:   "admin",
+		ApprovalTimeoutSec: 30,
+
+		AuthMaxFailures:        5,
+		AuthLockoutMin:         15,
+		AuthSessionMaxFailures: 3,
+	}
+}
+
+// WSAddr es la dirección del listener WS.
+func (cfg AppConfig) WSAddr() string {
+	addr := fmt.Sprintf(":%d", cfg.WSPort)
+	if ip := cfg.ListenIP; ip != "" && ip != "0.0.0.0" {
+		addr = fmt.Sprintf("%s:%d", ip, cfg.WSPort)
+	}
+	return addr
+}
+
+// Security es lo que el ws.Server exige a las conexiones según cfg.
+func (cfg AppConfig) Security() ws.SecurityConfig {
+	return ws.SecurityConfig{
+		RequireTLS:     cfg.EncryptTrafficTLS,
+		CertPath:       cfg.TLSCertPath,
+		KeyPath:        cfg.TLSKeyPath,
+		RequireToken:   cfg.RequireToken,
+		Token:          cfg.Token,
+		RequireAccount: cfg.RequireAccount,
+
+		RequireDeviceCredential: cfg.RequireDeviceCredential,
+		RequireApproval:         cfg.RequireApproval,
+		ApprovalTimeout:         time.Duration(cfg.ApprovalTimeoutSec) * time.Second,
+
+		GuestPermissions: cfg.guestPermissions(),
+	}
+}
+
+// guestPermissions: Save ya validó; si alguien rompió el valor a mano, las
+// sesiones sin login se quedan sin permisos (no con todos).
+func (cfg AppConfig) guestPermissions() ws.Permissions {
+	perms, err := ws.ParsePermissions(cfg.GuestPermissions)
+	if err != nil {
+		log.Printf("[config] guest_permissions inválido (%v): sin permisos", err)
+		return ws.Permissions{}
+	}
+	return perms
+}
+
+// DiscoveryAnnounce es el nombre que anuncia discovery ("" = hostname).
+func (cfg AppConfig) DiscoveryAnnounce() string {
+	if n := strings.TrimSpace(cfg.DiscoveryName); n != "" {
+		return n
+	}
+	name, _ := os.Hostname()
+	if name == "" {
+		name = "DeskControl-PC"
+	}
+	return name
+}
+
+func (cfg AppConfig) HoldTimeout() time.Duration {
+	return time.Duration(cfg.HoldTimeoutSec) * time.Second
+}
+
+func (cfg AppConfig) Heartbeat() (ping, idle time.Duration) {
+	return time.Duration(cfg.PingIntervalSec) * time.Second, time.Duration(cfg.IdleTimeoutSec) * time.Second
+}
+
+func (cfg AppConfig) AuthLimits() ws.AuthLimits {
+	l := ws.DefaultAuthLimits
+	l.MaxFailures = cfg.AuthMaxFailures
+	l.LockoutDuration = time.Duration(cfg.AuthLockoutMin) * time.Minute
+	l.SessionMaxFailures = cfg.AuthSessionMaxFailures
+	return l
+}
+
+// ListenerChanged: cambios que obligan a abrir otro listener WS.
+func ListenerChanged(a, b AppConfig) bool {
+	return a.WSAddr() != b.WSAddr() ||
+		a.EncryptTrafficTLS != b.EncryptTrafficTLS ||
+		(b.EncryptTrafficTLS && (a.TLSCertPath != b.TLSCertPath || a.TLSKeyPath != b.TLSKeyPath))
+}
+
+// NewServer arma (sin arrancar) el ws.Server con todo lo que sale de cfg.
+func (cfg AppConfig) NewServer(driver input.InputDriver) *ws.Server {
+	srv := ws.NewServer(cfg.WSAddr(), driver, cfg.Security())
+	srv.SetHoldTimeout(cfg.HoldTimeout())
+	srv.SetHeartbeat(cfg.Heartbeat())
+	srv.SetAuthLimits(cfg.AuthLimits())
+	return srv
+}
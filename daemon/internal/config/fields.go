@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Field es un valor de AppConfig con su nombre en la tabla settings
+// ("ws_port"). El daemon headless lo usa para el archivo de config, las
+// variables de entorno y los flags.
+type Field struct {
+	Key  string
+	Help string
+	ptr  func(cfg *AppConfig) any // *string, *int o *bool
+}
+
+// IsBool indica si el campo es un on/off.
+func (f Field) IsBool() bool {
+	_, ok := f.ptr(&AppConfig{}).(*bool)
+	return ok
+}
+
+var fields = []Field{
+	{"listen_ip", "IP donde escuchan WS y discovery (0.0.0.0 = todas)", func(c *AppConfig) any { return &c.ListenIP }},
+	{"ws_port", "puerto WebSocket", func(c *AppConfig) any { return &c.WSPort }},
+	{"udp_port", "puerto de discovery UDP", func(c *AppConfig) any { return &c.UDPPort }},
+	{"encrypt_tls", "TLS (wss://); sin TLS no hay token ni cuentas", func(c *AppConfig) any { return &c.EncryptTrafficTLS }},
+	{"tls_cert_path", "cert.pem (vacío = autofirmado en ./tls)", func(c *AppConfig) any { return &c.TLSCertPath }},
+	{"tls_key_path", "key.pem (vacío = autofirmado en ./tls)", func(c *AppConfig) any { return &c.TLSKeyPath }},
+
+	{"token", "token compartido (vacío = se genera)", func(c *AppConfig) any { return &c.Token }},
+	{"require_token", "exigir token (con TLS siempre)", func(c *AppConfig) any { return &c.RequireToken }},
+	{"require_device_credential", "sólo dispositivos emparejados", func(c *AppConfig) any { return &c.RequireDeviceCredential }},
+	{"require_approval", "aprobar en el PC las conexiones nuevas", func(c *AppConfig) any { return &c.RequireApproval }},
+	{"approval_timeout_sec", "segundos para aprobar una conexión", func(c *AppConfig) any { return &c.ApprovalTimeoutSec }},
+
+	{"require_account", "exigir login con usuario (sólo TLS)", func(c *AppConfig) any { return &c.RequireAccount }},
+	{"username", "usuario de la cuenta principal", func(c *AppConfig) any { return &c.Username }},
+	{"password_hash", "hash bcrypt de la clave", func(c *AppConfig) any { return &c.PasswordHash }},
+
+	{"log_retention_days", "días de logs (0 = para siempre)", func(c *AppConfig) any { return &c.LogRetentionDays }},
+	{"audit_retention_days", "días de auditoría (0 = para siempre)", func(c *AppConfig) any { return &c.AuditRetentionDays }},
+	{"discovery_name", "nombre anunciado por discovery (vacío = hostname)", func(c *AppConfig) any { return &c.DiscoveryName }},
+	{"hold_timeout_sec", "segundos sin mensajes antes de soltar teclas (0 = nunca)", func(c *AppConfig) any { return &c.HoldTimeoutSec }},
+	{"ping_interval_sec", "ping del server cada N segundos (0 = off)", func(c *AppConfig) any { return &c.PingIntervalSec }},
+	{"idle_timeout_sec", "cortar tras N segundos sin tráfico (0 = off)", func(c *AppConfig) any { return &c.IdleTimeoutSec }},
+	{"guest_permissions", "permisos sin login (media,pointer,...,admin)", func(c *AppConfig) any { return &c.GuestPermissions }},
+
+	{"auth_max_failures", "logins fallidos hasta bloquear (0 = nunca)", func(c *AppConfig) any { return &c.AuthMaxFailures }},
+	{"auth_lockout_min", "minutos de bloqueo", func(c *AppConfig) any { return &c.AuthLockoutMin }},
+	{"auth_session_max_failures", "logins fallidos por conexión antes de cerrarla (0 = nunca)", func(c *AppConfig) any { return &c.AuthSessionMaxFailures }},
+}
+
+// Fields devuelve los campos en el orden de la UI.
+func Fields() []Field { return fields }
+
+// Set pone el campo key (nombre de settings) a partir de su texto.
+func (cfg *AppConfig) Set(key, value string) error {
+	for _, f := range fields {
+		if f.Key != key {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch p := f.ptr(cfg).(type) {
+		case *string:
+			*p = value
+		case *int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: número inválido %q", key, value)
+			}
+			*p = n
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: se esperaba true/false, no %q", key, value)
+			}
+			*p = b
+		}
+		return nil
+	}
+	return fmt.Errorf("opción desconocida: %q", key)
+}
+
+// SetAll aplica un mapa key -> valor (p.ej. el de un archivo de config). Los
+// valores pueden venir como string, número o bool.
+func (cfg *AppConfig) SetAll(values map[string]any) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := cfg.Set(k, fmt.Sprint(values[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package config
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"deskcontrol/daemon/internal/ws"
+
+	_ "modernc.org/sqlite"
+)
+
+// dataDir es donde vive deskcontrol.db con la tabla settings.
+func dataDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "DeskControl")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// DBPath es el sqlite de settings (el mismo para la UI y el daemon).
+func DBPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "deskcontrol.db"), nil
+}
+
+func openDB() (*sql.DB, error) {
+	p, err := DBPath()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);
+	`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func setSetting(db *sql.DB, key, value string) error {
+	_, err := db.Exec(`INSERT INTO settings(key,value) VALUES(?,?)
+		ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, value)
+	return err
+}
+
+func getSetting(db *sql.DB, key string) (string, bool, error) {
+	var v string
+	err := db.QueryRow(`SELECT value FROM settings WHERE key=?`, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+// Load lee la config guardada; lo que falta queda con Default.
+func Load() (AppConfig, error) {
+	cfg := Default()
+	db, err := openDB()
+	if err != nil {
+		return cfg, err
+	}
+	defer db.Close()
+
+	readStr := func(k string, dst *string) error {
+		if v, ok, err := getSetting(db, k); err != nil {
+			return err
+		} else if ok {
+			*dst = v
+		}
+		return nil
+	}
+	readInt := func(k string, dst *int) error {
+		if v, ok, err := getSetting(db, k); err != nil {
+			return err
+		} else if ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				*dst = n
+			}
+		}
+		return nil
+	}
+	readBool := func(k string, dst *bool) error {
+		if v, ok, err := getSetting(db, k); err != nil {
+			return err
+		} else if ok {
+			*dst = (v == "1" || v == "true")
+		}
+		return nil
+	}
+
+	_ = readStr("listen_ip", &cfg.ListenIP)
+	_ = readInt("ws_port", &cfg.WSPort)
+	_ = readInt("udp_port", &cfg.UDPPort)
+	_ = readBool("encrypt_tls", &cfg.EncryptTrafficTLS)
+	_ = readStr("tls_cert_path", &cfg.TLSCertPath)
+	_ = readStr("tls_key_path", &cfg.TLSKeyPath)
+
+	_ = readStr("token", &cfg.Token)
+	_ = readBool("require_token", &cfg.RequireToken)
+	_ = readBool("require_device_credential", &cfg.RequireDeviceCredential)
+	_ = readBool("require_approval", &cfg.RequireApproval)
+	_ = readInt("approval_timeout_sec", &cfg.ApprovalTimeoutSec)
+
+	_ = readBool("require_account", &cfg.RequireAccount)
+	_ = readStr("username", &cfg.Username)
+	_ = readStr("password_hash", &cfg.PasswordHash)
+
+	_ = readInt("log_retention_days", &cfg.LogRetentionDays)
+	_ = readInt("audit_retention_days", &cfg.AuditRetentionDays)
+	_ = readStr("discovery_name", &cfg.DiscoveryName)
+	_ = readInt("hold_timeout_sec", &cfg.HoldTimeoutSec)
+	_ = readInt("ping_interval_sec", &cfg.PingIntervalSec)
+	_ = readInt("idle_timeout_sec", &cfg.IdleTimeoutSec)
+	_ = readStr("guest_permissions", &cfg.GuestPermissions)
+	_ = readInt("auth_max_failures", &cfg.AuthMaxFailures)
+	_ = readInt("auth_lockout_min", &cfg.AuthLockoutMin)
+	_ = readInt("auth_session_max_failures", &cfg.AuthSessionMaxFailures)
+
+	// ✅ Enforce current policy on load too (so UI reflects it)
+	if !cfg.EncryptTrafficTLS {
+		cfg.RequireToken = false
+		cfg.Token = ""
+		cfg.RequireAccount = false
+		cfg.Username = ""
+		cfg.PasswordHash = ""
+	} else {
+		cfg.RequireToken = true
+		if cfg.Token == "" {
+			if tok, err := GenerateToken(32); err == nil {
+				cfg.Token = tok
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Validate revisa los valores (rangos, permisos, ...) sin tocar la DB.
+func (cfg AppConfig) Validate() error {
+	if cfg.WSPort <= 0 || cfg.WSPort > 65535 {
+		return fmt.Errorf("ws_port inválido: %d", cfg.WSPort)
+	}
+	if cfg.UDPPort <= 0 || cfg.UDPPort > 65535 {
+		return fmt.Errorf("udp_port inválido: %d", cfg.UDPPort)
+	}
+	if cfg.LogRetentionDays < 0 {
+		return fmt.Errorf("log_retention_days inválido: %d", cfg.LogRetentionDays)
+	}
+	if cfg.AuditRetentionDays < 0 {
+		return fmt.Errorf("audit_retention_days inválido: %d", cfg.AuditRetentionDays)
+	}
+	if cfg.HoldTimeoutSec < 0 {
+		return fmt.Errorf("hold_timeout_sec inválido: %d", cfg.HoldTimeoutSec)
+	}
+	if cfg.PingIntervalSec < 0 {
+		return fmt.Errorf("ping_interval_sec inválido: %d", cfg.PingIntervalSec)
+	}
+	if cfg.IdleTimeoutSec < 0 {
+		return fmt.Errorf("idle_timeout_sec inválido: %d", cfg.IdleTimeoutSec)
+	}
+	// con pings activos, el idle tiene que dejar pasar al menos un pong
+	if cfg.IdleTimeoutSec > 0 && cfg.PingIntervalSec > 0 && cfg.IdleTimeoutSec <= cfg.PingIntervalSec {
+		return fmt.Errorf("idle_timeout_sec (%d) debe ser mayor que ping_interval_sec (%d)", cfg.IdleTimeoutSec, cfg.PingIntervalSec)
+	}
+	if cfg.AuthMaxFailures < 0 {
+		return fmt.Errorf("auth_max_failures inválido: %d", cfg.AuthMaxFailures)
+	}
+	if cfg.AuthMaxFailures > 0 && cfg.AuthLockoutMin <= 0 {
+		return fmt.Errorf("auth_lockout_min inválido: %d (con bloqueo activado tiene que ser > 0)", cfg.AuthLockoutMin)
+	}
+	if cfg.ApprovalTimeoutSec <= 0 {
+		return fmt.Errorf("approval_timeout_sec inválido: %d", cfg.ApprovalTimeoutSec)
+	}
+	if cfg.AuthSessionMaxFailures < 0 {
+		return fmt.Errorf("auth_session_max_failures inválido: %d", cfg.AuthSessionMaxFailures)
+	}
+	if _, err := ws.ParsePermissions(cfg.GuestPermissions); err != nil {
+		return fmt.Errorf("guest_permissions inválido: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyPolicy: o bien (sin TLS, sin token, sin cuenta) o bien (TLS + token).
+func (cfg *AppConfig) ApplyPolicy() error {
+	if !cfg.EncryptTrafficTLS {
+		cfg.RequireToken = false
+		cfg.Token = ""
+		cfg.RequireAccount = false
+		cfg.Username = ""
+		cfg.PasswordHash = ""
+	} else {
+		cfg.RequireToken = true
+		if cfg.Token == "" {
+			tok, err := GenerateToken(32)
+			if err != nil {
+				return fmt.Errorf("no pude generar token: %w", err)
+			}
+			cfg.Token = tok
+		}
+		// account is optional, but only allowed with TLS
+		if cfg.RequireAccount {
+			if cfg.Username == "" || cfg.PasswordHash == "" {
+				return fmt.Errorf("require_account activado pero falta usuario/clave")
+			}
+		} else {
+			// si no requiere cuenta, no obligamos limpiar user/hash; lo dejamos para que no se pierda
+		}
+	}
+
+	return nil
+}
+
+// Save valida y guarda cfg en settings; el core la aplica en caliente.
+func Save(cfg AppConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.ApplyPolicy(); err != nil {
+		return err
+	}
+
+	// para la auditoría: qué había antes
+	prev, prevErr := Load()
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	write := func(k, v string) error { return setSetting(db, k, v) }
+	writeInt := func(k string, v int) error { return write(k, strconv.Itoa(v)) }
+	writeBool := func(k string, v bool) error {
+		if v {
+			return write(k, "1")
+		}
+		return write(k, "0")
+	}
+
+	if err := write("listen_ip", cfg.ListenIP); err != nil {
+		return err
+	}
+	if err := writeInt("ws_port", cfg.WSPort); err != nil {
+		return err
+	}
+	if err := writeInt("udp_port", cfg.UDPPort); err != nil {
+		return err
+	}
+
+	if err := writeBool("encrypt_tls", cfg.EncryptTrafficTLS); err != nil {
+		return err
+	}
+	if err := write("tls_cert_path", cfg.TLSCertPath); err != nil {
+		return err
+	}
+	if err := write("tls_key_path", cfg.TLSKeyPath); err != nil {
+		return err
+	}
+
+	if err := write("token", cfg.Token); err != nil {
+		return err
+	}
+	if err := writeBool("require_token", cfg.RequireToken); err != nil {
+		return err
+	}
+	if err := writeBool("require_device_credential", cfg.RequireDeviceCredential); err != nil {
+		return err
+	}
+	if err := writeBool("require_approval", cfg.RequireApproval); err != nil {
+		return err
+	}
+	if err := writeInt("approval_timeout_sec", cfg.ApprovalTimeoutSec); err != nil {
+		return err
+	}
+
+	if err := writeBool("require_account", cfg.RequireAccount); err != nil {
+		return err
+	}
+	if err := write("username", cfg.Username); err != nil {
+		return err
+	}
+	if err := write("password_hash", cfg.PasswordHash); err != nil {
+		return err
+	}
+
+	if err := writeInt("log_retention_days", cfg.LogRetentionDays); err != nil {
+		return err
+	}
+	if err := writeInt("audit_retention_days", cfg.AuditRetentionDays); err != nil {
+		return err
+	}
+	if err := write("discovery_name", cfg.DiscoveryName); err != nil {
+		return err
+	}
+	if err := writeInt("hold_timeout_sec", cfg.HoldTimeoutSec); err != nil {
+		return err
+	}
+	if err := writeInt("ping_interval_sec", cfg.PingIntervalSec); err != nil {
+		return err
+	}
+	if err := writeInt("idle_timeout_sec", cfg.IdleTimeoutSec); err != nil {
+		return err
+	}
+	if err := write("guest_permissions", cfg.GuestPermissions); err != nil {
+		return err
+	}
+	if err := writeInt("auth_max_failures", cfg.AuthMaxFailures); err != nil {
+		return err
+	}
+	if err := writeInt("auth_lockout_min", cfg.AuthLockoutMin); err != nil {
+		return err
+	}
+	if err := writeInt("auth_session_max_failures", cfg.AuthSessionMaxFailures); err != nil {
+		return err
+	}
+
+	notifySaved()
+	if prevErr == nil {
+		auditChange(prev, cfg)
+	}
+	return nil
+}
+
+// saved avisa al core (sin bloquear) que Save escribió algo.
+var saved = make(chan struct{}, 1)
+
+func notifySaved() {
+	select {
+	case saved <- struct{}{}:
+	default:
+	}
+}
+
+// Saved recibe un aviso cada vez que Save guarda (los avisos se juntan).
+func Saved() <-chan struct{} { return saved }
+
+// Fingerprint resume la tabla settings completa; si cambia, alguien
+// (esta UI u otro proceso/herramienta) editó la config.
+func Fingerprint() (string, error) {
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT key, value FROM settings ORDER BY key`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s=%s\n", k, v)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
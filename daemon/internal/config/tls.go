@@ -1,4 +1,4 @@
-package main
+package config
 
 import (
 	"crypto/rand"
@@ -13,7 +13,7 @@ import (
 	"time"
 )
 
-func exeDir2() string {
+func exeDir() string {
 	p, err := os.Executable()
 	if err != nil || p == "" {
 		if wd, err2 := os.Getwd(); err2 == nil && wd != "" {
@@ -40,7 +40,7 @@ func EnsureTLSCertKey(cfg *AppConfig) error {
 		}
 	}
 
-	tlsDir := filepath.Join(exeDir2(), "tls")
+	tlsDir := filepath.Join(exeDir(), "tls")
 	if err := os.MkdirAll(tlsDir, 0o755); err != nil {
 		return err
 	}
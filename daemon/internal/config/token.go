@@ -0,0 +1,18 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// GenerateToken devuelve nbytes aleatorios en base64 URL-safe (token compartido).
+func GenerateToken(nbytes int) (string, error) {
+	if nbytes <= 0 {
+		nbytes = 32
+	}
+	b := make([]byte, nbytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -1,10 +1,14 @@
 package discovery
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,9 +27,22 @@ type announceMsg struct {
 	TLS    bool   `json:"tls,omitempty"`
 }
 
+// Responder contesta los "discover" por UDP. Se crea con StartUDP y se
+// detiene con Shutdown.
+type Responder struct {
+	mu         sync.Mutex
+	name       string
+	wsPort     int
+	tlsEnabled bool
+
+	conn *net.UDPConn
+	done chan struct{}
+}
+
 // StartUDP listens on udpPort and answers discovery requests.
 // listenIP binds the UDP socket. tlsEnabled is announced to clients.
-func StartUDP(name string, wsPort int, udpPort int, listenIP string, tlsEnabled bool) {
+// Listen errors (e.g. port in use) are returned instead of only logged.
+func StartUDP(name string, wsPort int, udpPort int, listenIP string, tlsEnabled bool) (*Responder, error) {
 	bindIP := net.IPv4zero
 	if listenIP != "" {
 		if ip := net.ParseIP(listenIP); ip != nil {
@@ -38,18 +55,61 @@ func StartUDP(name string, wsPort int, udpPort int, listenIP string, tlsEnabled
 	addr := net.UDPAddr{IP: bindIP, Port: udpPort}
 	conn, err := net.ListenUDP("udp4", &addr)
 	if err != nil {
-		log.Println("discovery udp listen error:", err)
-		return
+		return nil, fmt.Errorf("discovery udp listen: %w", err)
 	}
-	defer conn.Close()
 
 	log.Println("Discovery UDP listening on", conn.LocalAddr().String())
 
+	r := &Responder{
+		name:       name,
+		wsPort:     wsPort,
+		tlsEnabled: tlsEnabled,
+		conn:       conn,
+		done:       make(chan struct{}),
+	}
+	go r.loop()
+	return r, nil
+}
+
+// SetAnnounce cambia en caliente lo que se anuncia (nombre, puerto WS, TLS)
+// sin cerrar el socket UDP.
+func (r *Responder) SetAnnounce(name string, wsPort int, tlsEnabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.name = name
+	r.wsPort = wsPort
+	r.tlsEnabled = tlsEnabled
+}
+
+// LocalAddr devuelve la dirección UDP donde escucha.
+func (r *Responder) LocalAddr() net.Addr { return r.conn.LocalAddr() }
+
+// Shutdown cierra el socket y espera a que el loop termine (o venza ctx).
+func (r *Responder) Shutdown(ctx context.Context) error {
+	err := r.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	select {
+	case <-r.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Responder) loop() {
+	defer close(r.done)
+	conn := r.conn
 	buf := make([]byte, 2048)
 
 	for {
 		n, remote, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("Discovery UDP stopped")
+				return
+			}
 			log.Println("discovery read error:", err)
 			continue
 		}
@@ -63,14 +123,16 @@ func StartUDP(name string, wsPort int, udpPort int, listenIP string, tlsEnabled
 			continue
 		}
 
+		r.mu.Lock()
 		resp := announceMsg{
 			Type:   "announce",
 			App:    "deskcontrol",
 			V:      1,
-			Name:   name,
-			WsPort: wsPort,
-			TLS:    tlsEnabled,
+			Name:   r.name,
+			WsPort: r.wsPort,
+			TLS:    r.tlsEnabled,
 		}
+		r.mu.Unlock()
 
 		b, _ := json.Marshal(resp)
 		_ = conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
@@ -3,13 +3,13 @@
 package input
 
 import (
+	"context"
 	"errors"
 	"log"
 	"runtime"
 	"runtime/debug"
 	"sync"
 	"syscall"
-	"time"
 	"unsafe"
 )
 
@@ -56,9 +56,13 @@ const (
 	LLKHF_EXTENDED = 0x01
 )
 
+// Estado de la captura en curso. capGen cambia con cada captura: el hilo del
+// hook de una captura que ya terminó (timeout, cancelación) ve otro gen y se
+// va sin quedarse instalado.
 var (
 	capMu       sync.Mutex
 	capActive   bool
+	capGen      uint64
 	capResultCh chan CaptureResult
 	capThreadID uint32
 )
@@ -148,28 +152,37 @@ func uninstallKeyboardHook(h uintptr) {
 	log.Printf("[capture] UnhookWindowsHookEx(h=%d) -> r=%d err=%v", h, r, err)
 }
 
-func (w *WindowsInput) CaptureNextKey(timeoutMs int) (CaptureResult, error) {
-	if timeoutMs <= 0 {
-		timeoutMs = 10000
-	}
-
+func (w *WindowsInput) CaptureNextKey(ctx context.Context) (CaptureResult, error) {
 	capMu.Lock()
 	if capActive {
 		capMu.Unlock()
-		return CaptureResult{}, errors.New("capture already active")
+		return CaptureResult{}, ErrCaptureBusy
 	}
 	capActive = true
-	capResultCh = make(chan CaptureResult, 1)
+	capGen++
+	gen := capGen
+	resultCh := make(chan CaptureResult, 1)
+	capResultCh = resultCh
+	capThreadID = 0
 	capMu.Unlock()
 
-	log.Printf("[capture] CaptureNextKey begin timeoutMs=%d", timeoutMs)
+	log.Printf("[capture] CaptureNextKey begin gen=%d", gen)
 
+	// al salir se desactiva la captura y, si el hilo ya registró su tid, se le
+	// manda WM_QUIT; si todavía no, el hilo verá !capActive y saldrá solo.
 	defer func() {
 		capMu.Lock()
 		capActive = false
 		capResultCh = nil
+		tid := capThreadID
+		capThreadID = 0
 		capMu.Unlock()
-		log.Printf("[capture] CaptureNextKey end")
+
+		if tid != 0 {
+			r, _, err := postThreadMessageW.Call(uintptr(tid), 0x0012 /*WM_QUIT*/, 0, 0)
+			log.Printf("[capture] PostThreadMessageW(tid=%d, WM_QUIT) -> r=%d err=%v", tid, r, err)
+		}
+		log.Printf("[capture] CaptureNextKey end gen=%d", gen)
 	}()
 
 	errCh := make(chan error, 1)
@@ -188,13 +201,8 @@ func (w *WindowsInput) CaptureNextKey(timeoutMs int) (CaptureResult, error) {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
-		tid, _, _ := getCurrentThreadId.Call() // ✅ FIXED
-		capMu.Lock()
-		capThreadID = uint32(tid)
-		capMu.Unlock()
-
-		log.Printf("[capture] thread started tid=%d", uint32(tid))
-
+		// el hook crea la cola de mensajes del hilo: recién ahí PostThreadMessageW
+		// le puede llegar, así que el tid se publica después de instalarlo
 		h, err := installKeyboardHook()
 		if err != nil {
 			log.Printf("[capture] install hook error: %v", err)
@@ -203,6 +211,18 @@ func (w *WindowsInput) CaptureNextKey(timeoutMs int) (CaptureResult, error) {
 		}
 		defer uninstallKeyboardHook(h)
 
+		tid, _, _ := getCurrentThreadId.Call() // ✅ FIXED
+		capMu.Lock()
+		if !capActive || capGen != gen {
+			capMu.Unlock()
+			log.Printf("[capture] thread tid=%d: capture already over, exiting", uint32(tid))
+			return
+		}
+		capThreadID = uint32(tid)
+		capMu.Unlock()
+
+		log.Printf("[capture] thread started tid=%d", uint32(tid))
+
 		var m MSG
 		for {
 			r, _, _ := getMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
@@ -222,40 +242,18 @@ func (w *WindowsInput) CaptureNextKey(timeoutMs int) (CaptureResult, error) {
 		log.Printf("[capture] thread exiting tid=%d", uint32(tid))
 	}()
 
-	timeout := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
-	defer timeout.Stop()
-
 	select {
 	case err := <-errCh:
 		log.Printf("[capture] returning error: %v", err)
 		return CaptureResult{}, err
 
-	case res := <-capResultCh:
+	case res := <-resultCh:
 		log.Printf("[capture] got key vk=%d scan=%d ext=%v mods=%v", res.Key.VK, res.Key.Scan, res.Key.Ext, res.Mods)
-
-		capMu.Lock()
-		tid := capThreadID
-		capMu.Unlock()
-
-		if tid != 0 {
-			r, _, err := postThreadMessageW.Call(uintptr(tid), 0x0012 /*WM_QUIT*/, 0, 0)
-			log.Printf("[capture] PostThreadMessageW(tid=%d, WM_QUIT) -> r=%d err=%v", tid, r, err)
-		}
-
 		return res, nil
 
-	case <-timeout.C:
-		log.Printf("[capture] timeout after %dms", timeoutMs)
-
-		capMu.Lock()
-		tid := capThreadID
-		capMu.Unlock()
-
-		if tid != 0 {
-			r, _, err := postThreadMessageW.Call(uintptr(tid), 0x0012 /*WM_QUIT*/, 0, 0)
-			log.Printf("[capture] PostThreadMessageW(tid=%d, WM_QUIT) -> r=%d err=%v", tid, r, err)
-		}
-
-		return CaptureResult{}, errors.New("capture timeout")
+	case <-ctx.Done():
+		err := captureErr(ctx)
+		log.Printf("[capture] stopped: %v", err)
+		return CaptureResult{}, err
 	}
 }
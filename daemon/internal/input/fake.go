@@ -0,0 +1,209 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Call es una llamada registrada por Fake.
+type Call struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Args   []any     `json:"args,omitempty"`
+	Err    string    `json:"error,omitempty"`
+}
+
+func (c Call) String() string {
+	s := c.Method + fmt.Sprint(c.Args)
+	if c.Err != "" {
+		s += " -> " + c.Err
+	}
+	return s
+}
+
+// Fake es un InputDriver en memoria: no mueve nada, registra cada llamada con
+// su hora y devuelve lo que se le programe (errores por método, la lista de
+// apps, las capturas). Sirve para tests y para correr el daemon sin tocar el
+// puntero real (daemon -driver fake). El valor cero no sirve: usar NewFake.
+type Fake struct {
+	mu       sync.Mutex
+	calls    []Call
+	errs     map[string]error
+	apps     []AppInfo
+	captures []fakeCapture
+	features map[Feature]bool
+
+	// OnCall, si no es nil, se llama (fuera del lock) con cada llamada
+	// registrada; el daemon lo usa para loguearlas.
+	OnCall func(Call)
+}
+
+type fakeCapture struct {
+	res CaptureResult
+	err error
+}
+
+// NewFake devuelve un Fake que soporta todas las features y no falla.
+func NewFake() *Fake {
+	return &Fake{
+		errs: map[string]error{},
+		features: map[Feature]bool{
+			FeatureMouse: true, FeatureKeyboard: true, FeatureCapture: true, FeatureApps: true,
+		},
+	}
+}
+
+// FailOn hace que method ("MoveMouse", "KeyVK", ...) devuelva err de ahí en
+// adelante; con err nil vuelve a andar.
+func (f *Fake) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// SetApps fija lo que devuelve ListApps.
+func (f *Fake) SetApps(apps []AppInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apps = append([]AppInfo(nil), apps...)
+}
+
+// QueueCapture encola el resultado de un CaptureNextKey (se consumen en orden).
+// Sin nada encolado, CaptureNextKey espera a que venza o se cancele su ctx,
+// como si nadie tocara una tecla.
+func (f *Fake) QueueCapture(res CaptureResult, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.captures = append(f.captures, fakeCapture{res, err})
+}
+
+// SetSupported activa o desactiva una feature (para probar drivers parciales).
+func (f *Fake) SetSupported(feat Feature, on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.features[feat] = on
+}
+
+func (f *Fake) Supports(feat Feature) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.features[feat]
+}
+
+// Calls devuelve una copia de lo registrado hasta ahora.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Reset borra el registro (no lo programado).
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// WriteJSON vuelca el registro como un array JSON.
+func (f *Fake) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f.Calls())
+}
+
+// record registra la llamada y devuelve el error programado para method.
+func (f *Fake) record(method string, args ...any) error {
+	f.mu.Lock()
+	err := f.errs[method]
+	c := Call{Time: time.Now(), Method: method, Args: args}
+	if err != nil {
+		c.Err = err.Error()
+	}
+	f.calls = append(f.calls, c)
+	onCall := f.OnCall
+	f.mu.Unlock()
+
+	if onCall != nil {
+		onCall(c)
+	}
+	return err
+}
+
+func (f *Fake) MoveMouse(dx, dy int32) error { return f.record("MoveMouse", dx, dy) }
+
+// mouseButton rechaza, como los drivers, un botón que no sea left/right/middle.
+func (f *Fake) mouseButton(method, button string) error {
+	switch strings.ToLower(button) {
+	case "", "left", "right", "middle":
+		return f.record(method, button)
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidButton, button)
+	}
+}
+
+func (f *Fake) MouseClick(button string) error { return f.mouseButton("MouseClick", button) }
+func (f *Fake) MouseDown(button string) error  { return f.mouseButton("MouseDown", button) }
+func (f *Fake) MouseUp(button string) error    { return f.mouseButton("MouseUp", button) }
+func (f *Fake) MouseScroll(dy int32) error     { return f.record("MouseScroll", dy) }
+
+func (f *Fake) KeyText(text string) error { return f.record("KeyText", text) }
+func (f *Fake) Key(key string) error      { return f.record("Key", key) }
+func (f *Fake) KeyDown(key string) error  { return f.record("KeyDown", key) }
+func (f *Fake) KeyUp(key string) error    { return f.record("KeyUp", key) }
+
+func (f *Fake) Hotkey(mods []string, key string) error {
+	return f.record("Hotkey", append([]string(nil), mods...), key)
+}
+
+func (f *Fake) KeyVK(k KeySpec) error     { return f.record("KeyVK", k) }
+func (f *Fake) KeyDownVK(k KeySpec) error { return f.record("KeyDownVK", k) }
+func (f *Fake) KeyUpVK(k KeySpec) error   { return f.record("KeyUpVK", k) }
+
+func (f *Fake) HotkeyVK(mods []string, k KeySpec) error {
+	return f.record("HotkeyVK", append([]string(nil), mods...), k)
+}
+
+func (f *Fake) CaptureNextKey(ctx context.Context) (CaptureResult, error) {
+	var args []any
+	if dl, ok := ctx.Deadline(); ok {
+		args = append(args, time.Until(dl).Round(time.Millisecond).String())
+	}
+	if err := f.record("CaptureNextKey", args...); err != nil {
+		return CaptureResult{}, err
+	}
+	f.mu.Lock()
+	var next *fakeCapture
+	if len(f.captures) > 0 {
+		next = &f.captures[0]
+		f.captures = f.captures[1:]
+	}
+	f.mu.Unlock()
+
+	if next == nil {
+		<-ctx.Done()
+		return CaptureResult{}, captureErr(ctx)
+	}
+	return next.res, next.err
+}
+
+func (f *Fake) ListApps() ([]AppInfo, error) {
+	if err := f.record("ListApps"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]AppInfo(nil), f.apps...), nil
+}
+
+func (f *Fake) AppAction(hwnd uintptr, action string) error {
+	return f.record("AppAction", hwnd, action)
+}
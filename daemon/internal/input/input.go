@@ -1,5 +1,31 @@
 package input
 
+import (
+	"context"
+	"errors"
+)
+
+// Errores que el driver devuelve cuando el pedido no tiene sentido
+// (se envuelven con fmt.Errorf("%w: ...") para incluir el valor recibido).
+var (
+	ErrUnknownKey    = errors.New("tecla desconocida")
+	ErrInvalidButton = errors.New("botón inválido")
+	ErrNotSupported  = errors.New("no soportado en esta plataforma")
+
+	ErrCaptureBusy     = errors.New("capture already active")
+	ErrCaptureTimeout  = errors.New("capture timeout")
+	ErrCaptureCanceled = errors.New("capture canceled")
+)
+
+// captureErr traduce el fin de ctx al error de CaptureNextKey: vencido =
+// ErrCaptureTimeout, cancelado = ErrCaptureCanceled.
+func captureErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrCaptureTimeout
+	}
+	return ErrCaptureCanceled
+}
+
 // KeySpec is a stable representation of a key that can be stored on the phone
 // and later sent back to the daemon to be executed.
 //
@@ -29,6 +55,31 @@ type AppInfo struct {
 	Minimized bool    `json:"minimized"`
 }
 
+// Feature agrupa mensajes del protocolo según lo que necesitan del driver.
+type Feature string
+
+const (
+	FeatureMouse    Feature = "mouse"
+	FeatureKeyboard Feature = "keyboard"
+	FeatureCapture  Feature = "capture"
+	FeatureApps     Feature = "apps"
+)
+
+// Capabler es opcional: un driver que no soporte todo (p.ej. sin captura o sin
+// lista de apps) lo implementa para que el daemon no anuncie esos mensajes.
+// Si el driver no lo implementa se asume que soporta todas las features.
+type Capabler interface {
+	Supports(f Feature) bool
+}
+
+// Supports indica si d soporta la feature f.
+func Supports(d InputDriver, f Feature) bool {
+	if c, ok := d.(Capabler); ok {
+		return c.Supports(f)
+	}
+	return true
+}
+
 type InputDriver interface {
 	MoveMouse(dx, dy int32) error
 
@@ -49,8 +100,9 @@ type InputDriver interface {
 	KeyUpVK(k KeySpec) error
 	HotkeyVK(mods []string, k KeySpec) error
 
-	// One-shot key capture (no keylogger). Blocks until a key is pressed or timeoutMs elapses.
-	CaptureNextKey(timeoutMs int) (CaptureResult, error)
+	// One-shot key capture (no keylogger). Blocks until a key is pressed or ctx
+	// ends: ErrCaptureTimeout if its deadline passed, ErrCaptureCanceled otherwise.
+	CaptureNextKey(ctx context.Context) (CaptureResult, error)
 
 	// Taskbar apps
 	ListApps() ([]AppInfo, error)
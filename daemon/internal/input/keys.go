@@ -0,0 +1,275 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Catálogo de teclas con nombre (las de key, key_down, hotkey, ...). Es el
+// mismo en todas las plataformas: cada tecla trae su VK/scan de Windows y su
+// keycode evdev de Linux, y cada driver usa el que le toca. El teléfono lo
+// pide con keys_list para armar sus teclados.
+
+// KeyGroup agrupa las teclas para mostrarlas.
+type KeyGroup string
+
+const (
+	GroupLetter   KeyGroup = "letter"
+	GroupDigit    KeyGroup = "digit"
+	GroupFunction KeyGroup = "function"
+	GroupNumpad   KeyGroup = "numpad"
+	GroupPunct    KeyGroup = "punctuation"
+	GroupEdit     KeyGroup = "edit"
+	GroupNav      KeyGroup = "navigation"
+	GroupSystem   KeyGroup = "system"
+	GroupModifier KeyGroup = "modifier"
+	GroupMedia    KeyGroup = "media"
+	GroupBrowser  KeyGroup = "browser"
+	GroupLaunch   KeyGroup = "launch"
+)
+
+// KeyInfo es una tecla del catálogo. Key sirve tal cual para key_vk /
+// hotkey_vk (VK de Windows, scan code set 1, extendida); Linux es el KEY_*
+// de linux/input-event-codes.h.
+type KeyInfo struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Group   KeyGroup `json:"group"`
+	Key     KeySpec  `json:"key"`
+	Linux   uint16   `json:"linux"`
+}
+
+func key(g KeyGroup, name string, vk, scan, linux uint16, aliases ...string) KeyInfo {
+	return KeyInfo{Name: name, Aliases: aliases, Group: g, Key: KeySpec{VK: vk, Scan: scan}, Linux: linux}
+}
+
+// extKey: tecla con prefijo E0 (flechas, bloque de edición, multimedia, ...).
+func extKey(g KeyGroup, name string, vk, scan, linux uint16, aliases ...string) KeyInfo {
+	k := key(g, name, vk, scan, linux, aliases...)
+	k.Key.Ext = true
+	return k
+}
+
+var keys = func() []KeyInfo {
+	var out []KeyInfo
+
+	// letras: el scan code set 1 coincide con el keycode de Linux
+	letterScan := [26]uint16{
+		0x1E, 0x30, 0x2E, 0x20, 0x12, 0x21, 0x22, 0x23, 0x17, 0x24, 0x25, 0x26, 0x32,
+		0x31, 0x18, 0x19, 0x10, 0x13, 0x1F, 0x14, 0x16, 0x2F, 0x11, 0x2D, 0x15, 0x2C,
+	}
+	for i, sc := range letterScan {
+		out = append(out, key(GroupLetter, string(rune('a'+i)), uint16('A'+i), sc, sc))
+	}
+	for i := 0; i < 10; i++ {
+		sc := uint16(0x02 + (i+9)%10) // 1..9 = 0x02..0x0A, 0 = 0x0B
+		out = append(out, key(GroupDigit, string(rune('0'+i)), uint16('0'+i), sc, sc))
+	}
+
+	// F1..F24
+	fnScan := [24]uint16{
+		0x3B, 0x3C, 0x3D, 0x3E, 0x3F, 0x40, 0x41, 0x42, 0x43, 0x44, 0x57, 0x58,
+		0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6A, 0x6B, 0x6C, 0x6D, 0x6E, 0x76,
+	}
+	fnLinux := [24]uint16{
+		59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 87, 88,
+		183, 184, 185, 186, 187, 188, 189, 190, 191, 192, 193, 194,
+	}
+	for i := range fnScan {
+		out = append(out, key(GroupFunction, fmt.Sprintf("f%d", i+1), uint16(0x70+i), fnScan[i], fnLinux[i]))
+	}
+
+	// keypad 0..9
+	kpScan := [10]uint16{0x52, 0x4F, 0x50, 0x51, 0x4B, 0x4C, 0x4D, 0x47, 0x48, 0x49}
+	for i, sc := range kpScan {
+		out = append(out, key(GroupNumpad, fmt.Sprintf("kp_%d", i), uint16(0x60+i), sc, sc, fmt.Sprintf("num%d", i)))
+	}
+
+	return append(out,
+		key(GroupNumpad, "kp_multiply", 0x6A, 0x37, 55, "kp_asterisk", "kp_mul"),
+		key(GroupNumpad, "kp_minus", 0x6D, 0x4A, 74, "kp_subtract", "kp_sub"),
+		key(GroupNumpad, "kp_plus", 0x6B, 0x4E, 78, "kp_add"),
+		key(GroupNumpad, "kp_decimal", 0x6E, 0x53, 83, "kp_dot"),
+		extKey(GroupNumpad, "kp_divide", 0x6F, 0x35, 98, "kp_slash", "kp_div"),
+		extKey(GroupNumpad, "kp_enter", 0x0D, 0x1C, 96),
+		extKey(GroupNumpad, "numlock", 0x90, 0x45, 69, "num_lock"),
+
+		// distribución US (el carácter real depende del layout del PC)
+		key(GroupPunct, "minus", 0xBD, 0x0C, 12, "-"),
+		key(GroupPunct, "equal", 0xBB, 0x0D, 13, "=", "equals"),
+		key(GroupPunct, "bracket_left", 0xDB, 0x1A, 26, "["),
+		key(GroupPunct, "bracket_right", 0xDD, 0x1B, 27, "]"),
+		key(GroupPunct, "semicolon", 0xBA, 0x27, 39, ";"),
+		key(GroupPunct, "quote", 0xDE, 0x28, 40, "'", "apostrophe"),
+		key(GroupPunct, "backquote", 0xC0, 0x29, 41, "`", "grave"),
+		key(GroupPunct, "backslash", 0xDC, 0x2B, 43, "\\"),
+		key(GroupPunct, "comma", 0xBC, 0x33, 51, ","),
+		key(GroupPunct, "period", 0xBE, 0x34, 52, ".", "dot"),
+		key(GroupPunct, "slash", 0xBF, 0x35, 53, "/"),
+		key(GroupPunct, "intl_backslash", 0xE2, 0x56, 86, "102nd"), // la < > de los teclados ISO
+
+		key(GroupEdit, "enter", 0x0D, 0x1C, 28, "return"),
+		key(GroupEdit, "tab", 0x09, 0x0F, 15),
+		key(GroupEdit, "space", 0x20, 0x39, 57),
+		key(GroupEdit, "backspace", 0x08, 0x0E, 14),
+		key(GroupEdit, "esc", 0x1B, 0x01, 1, "escape"),
+		key(GroupEdit, "capslock", 0x14, 0x3A, 58, "caps_lock"),
+		extKey(GroupEdit, "insert", 0x2D, 0x52, 110, "ins"),
+		extKey(GroupEdit, "delete", 0x2E, 0x53, 111, "del"),
+
+		extKey(GroupNav, "up", 0x26, 0x48, 103, "arrow_up"),
+		extKey(GroupNav, "down", 0x28, 0x50, 108, "arrow_down"),
+		extKey(GroupNav, "left", 0x25, 0x4B, 105, "arrow_left"),
+		extKey(GroupNav, "right", 0x27, 0x4D, 106, "arrow_right"),
+		extKey(GroupNav, "home", 0x24, 0x47, 102),
+		extKey(GroupNav, "end", 0x23, 0x4F, 107),
+		extKey(GroupNav, "pageup", 0x21, 0x49, 104, "page_up", "pgup"),
+		extKey(GroupNav, "pagedown", 0x22, 0x51, 109, "page_down", "pgdn"),
+
+		extKey(GroupSystem, "printscreen", 0x2C, 0x37, 99, "print_screen", "prtsc", "sysrq"),
+		key(GroupSystem, "scrolllock", 0x91, 0x46, 70, "scroll_lock"),
+		key(GroupSystem, "pause", 0x13, 0, 119, "break"), // su scan es E1 1D 45: sólo VK
+		extKey(GroupSystem, "menu", 0x5D, 0x5D, 127, "apps", "context_menu"),
+		extKey(GroupSystem, "sleep", 0x5F, 0x5F, 142),
+
+		// los izquierdos llevan el nombre corto (el que se usa en hotkey)
+		key(GroupModifier, "shift", 0xA0, 0x2A, 42, "lshift"),
+		key(GroupModifier, "rshift", 0xA1, 0x36, 54),
+		key(GroupModifier, "ctrl", 0xA2, 0x1D, 29, "control", "lctrl"),
+		extKey(GroupModifier, "rctrl", 0xA3, 0x1D, 97),
+		key(GroupModifier, "alt", 0xA4, 0x38, 56, "lalt", "option"),
+		extKey(GroupModifier, "ralt", 0xA5, 0x38, 100, "altgr"),
+		extKey(GroupModifier, "win", 0x5B, 0x5B, 125, "windows", "meta", "super", "cmd", "lwin"),
+		extKey(GroupModifier, "rwin", 0x5C, 0x5C, 126),
+
+		extKey(GroupMedia, "vol_mute", 0xAD, 0x20, 113, "mute", "volume_mute"),
+		extKey(GroupMedia, "vol_down", 0xAE, 0x2E, 114, "volume_down"),
+		extKey(GroupMedia, "vol_up", 0xAF, 0x30, 115, "volume_up"),
+		extKey(GroupMedia, "media_next", 0xB0, 0x19, 163, "next_track"),
+		extKey(GroupMedia, "media_prev", 0xB1, 0x10, 165, "prev_track"),
+		extKey(GroupMedia, "media_stop", 0xB2, 0x24, 166),
+		extKey(GroupMedia, "media_play_pause", 0xB3, 0x22, 164, "play_pause"),
+
+		extKey(GroupBrowser, "browser_back", 0xA6, 0x6A, 158),
+		extKey(GroupBrowser, "browser_forward", 0xA7, 0x69, 159),
+		extKey(GroupBrowser, "browser_refresh", 0xA8, 0x67, 173),
+		extKey(GroupBrowser, "browser_stop", 0xA9, 0x68, 128),
+		extKey(GroupBrowser, "browser_search", 0xAA, 0x65, 217),
+		extKey(GroupBrowser, "browser_favorites", 0xAB, 0x66, 364),
+		extKey(GroupBrowser, "browser_home", 0xAC, 0x32, 172),
+
+		extKey(GroupLaunch, "launch_mail", 0xB4, 0x6C, 155, "mail"),
+		extKey(GroupLaunch, "launch_media", 0xB5, 0x6D, 226),
+		extKey(GroupLaunch, "launch_app1", 0xB6, 0x6B, 157, "my_computer"),
+		extKey(GroupLaunch, "launch_app2", 0xB7, 0x21, 140, "calculator", "calc"),
+	)
+}()
+
+// VK genéricos (VK_SHIFT, VK_CONTROL, VK_MENU) -> la tecla izquierda o, con
+// Ext, la derecha. Los mandan teléfonos viejos y los atajos guardados a mano.
+var genericVK = map[specKey]string{
+	{0x10, false}: "shift",
+	{0x11, false}: "ctrl",
+	{0x11, true}:  "rctrl",
+	{0x12, false}: "alt",
+	{0x12, true}:  "ralt",
+}
+
+type specKey struct {
+	vk  uint16
+	ext bool
+}
+
+// índices (se arman una vez; un nombre repetido es un error de programación)
+var (
+	keysByName = map[string]int{}
+	keysBySpec = map[specKey]int{}
+	keysByVK   = map[uint16]int{}
+	keysByScan = map[specKey]int{} // vk = scan
+)
+
+func init() {
+	for i, k := range keys {
+		for _, n := range append([]string{k.Name}, k.Aliases...) {
+			if _, dup := keysByName[n]; dup {
+				panic("input: tecla repetida en el catálogo: " + n)
+			}
+			keysByName[n] = i
+		}
+		addFirst(keysBySpec, specKey{k.Key.VK, k.Key.Ext}, i)
+		addFirst(keysByVK, k.Key.VK, i)
+		if k.Key.Scan != 0 {
+			addFirst(keysByScan, specKey{k.Key.Scan, k.Key.Ext}, i)
+		}
+	}
+	for sk, name := range genericVK {
+		keysBySpec[sk] = keysByName[name]
+		addFirst(keysByVK, sk.vk, keysByName[name])
+	}
+}
+
+func addFirst[K comparable](m map[K]int, k K, i int) {
+	if _, ok := m[k]; !ok {
+		m[k] = i
+	}
+}
+
+// Keys devuelve el catálogo completo, agrupado.
+func Keys() []KeyInfo {
+	out := make([]KeyInfo, len(keys))
+	copy(out, keys)
+	return out
+}
+
+// LookupKey busca una tecla por nombre o alias (sin distinguir mayúsculas).
+func LookupKey(name string) (KeyInfo, bool) {
+	i, ok := keysByName[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return KeyInfo{}, false
+	}
+	return keys[i], true
+}
+
+// keyByName es LookupKey con el error que devuelven los drivers.
+func keyByName(name string) (KeyInfo, error) {
+	k, ok := LookupKey(name)
+	if !ok {
+		return KeyInfo{}, fmt.Errorf("%w: %q", ErrUnknownKey, name)
+	}
+	return k, nil
+}
+
+// modKeys resuelve los modificadores de un hotkey; falla si alguno no es
+// conocido o no es un modificador (antes se ignoraba en silencio).
+func modKeys(mods []string) ([]KeyInfo, error) {
+	out := make([]KeyInfo, 0, len(mods))
+	for _, m := range mods {
+		k, ok := LookupKey(m)
+		if !ok || k.Group != GroupModifier {
+			return nil, fmt.Errorf("%w: modificador %q", ErrUnknownKey, m)
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// LookupSpec busca la tecla de un KeySpec que mandó el teléfono: por VK (con
+// Ext para distinguir p.ej. ENTER del de keypad, si no el VK solo) y, sólo si
+// el VK no es del catálogo, por scan code. Un VK conocido manda: si no, un
+// {vk: VOLUME_MUTE, scan: A} pasaría por multimedia y escribiría una A.
+func LookupSpec(k KeySpec) (KeyInfo, bool) {
+	if k.VK != 0 {
+		if i, ok := keysBySpec[specKey{k.VK, k.Ext}]; ok {
+			return keys[i], true
+		}
+		if i, ok := keysByVK[k.VK]; ok {
+			return keys[i], true
+		}
+	}
+	if k.Scan != 0 {
+		if i, ok := keysByScan[specKey{k.Scan, k.Ext}]; ok {
+			return keys[i], true
+		}
+	}
+	return KeyInfo{}, false
+}
@@ -0,0 +1,83 @@
+package input
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeysCatalogue(t *testing.T) {
+	groups := map[KeyGroup]int{}
+	for _, k := range Keys() {
+		groups[k.Group]++
+		if k.Key.VK == 0 || k.Linux == 0 {
+			t.Errorf("%s: vk=%#x linux=%d", k.Name, k.Key.VK, k.Linux)
+		}
+		// lo que el teléfono guarde de keys_list tiene que volver a la misma tecla
+		if got, ok := LookupSpec(k.Key); !ok || got.Linux != k.Linux {
+			t.Errorf("LookupSpec(%+v) = %s (%v), want %s", k.Key, got.Name, ok, k.Name)
+		}
+	}
+	if n := groups[GroupFunction]; n != 24 {
+		t.Errorf("teclas de función = %d, want 24", n)
+	}
+	for _, g := range []KeyGroup{GroupNumpad, GroupPunct, GroupNav, GroupBrowser, GroupLaunch, GroupMedia, GroupModifier} {
+		if groups[g] == 0 {
+			t.Errorf("grupo %s vacío", g)
+		}
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	cases := []struct {
+		name, want string
+	}{
+		{"Enter", "enter"}, {" RETURN ", "enter"}, {"escape", "esc"}, {"del", "delete"},
+		{"PgUp", "pageup"}, {"num7", "kp_7"}, {"-", "minus"}, {"super", "win"},
+		{"mute", "vol_mute"}, {"F24", "f24"}, {"calc", "launch_app2"},
+	}
+	for _, c := range cases {
+		k, ok := LookupKey(c.name)
+		if !ok || k.Name != c.want {
+			t.Errorf("LookupKey(%q) = %q (%v), want %q", c.name, k.Name, ok, c.want)
+		}
+	}
+	if _, ok := LookupKey("nope"); ok {
+		t.Error("LookupKey(nope) encontró algo")
+	}
+}
+
+func TestModKeys(t *testing.T) {
+	mk, err := modKeys([]string{"Control", "meta", "altgr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mk[0].Name != "ctrl" || mk[1].Name != "win" || mk[2].Name != "ralt" {
+		t.Errorf("modKeys = %v", mk)
+	}
+	// una tecla que existe pero no es modificador tampoco vale
+	if _, err := modKeys([]string{"ctrl", "a"}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("modKeys(a) = %v", err)
+	}
+}
+
+func TestLookupSpecGeneric(t *testing.T) {
+	cases := []struct {
+		k    KeySpec
+		want string
+	}{
+		{KeySpec{VK: 0x11}, "ctrl"},             // VK_CONTROL
+		{KeySpec{VK: 0x11, Ext: true}, "rctrl"}, // VK_CONTROL extendida
+		{KeySpec{VK: 0x0D, Ext: true}, "kp_enter"},
+		{KeySpec{Scan: 0x1D, Ext: true}, "rctrl"},
+		{KeySpec{VK: 0xB3}, "media_play_pause"}, // sin Ext igual se encuentra
+		{KeySpec{VK: 0x10, Ext: true}, "shift"}, // VK genérico: no cae al scan
+		// un VK conocido manda sobre el scan (si no, multimedia tecleaba una A)
+		{KeySpec{VK: 0xAD, Scan: 0x1E}, "vol_mute"},
+		{KeySpec{VK: 0xFF, Scan: 0x1E}, "a"},
+	}
+	for _, c := range cases {
+		if got, ok := LookupSpec(c.k); !ok || got.Name != c.want {
+			t.Errorf("LookupSpec(%+v) = %q (%v), want %q", c.k, got.Name, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package input
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Driver de Linux: teclado y mouse virtuales por /dev/uinput (ver
+// uinput_linux.go para crear los dispositivos). Acá sólo se arman los
+// input_event y se escriben en un io.Writer por dispositivo, así los tests
+// pueden revisar los registros exactos sin uinput.
+
+// Tipos y códigos de linux/input-event-codes.h.
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+
+	synReport = 0
+
+	relX          = 0x00
+	relY          = 0x01
+	relWheel      = 0x08
+	relWheelHiRes = 0x0b
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+
+	keyLeftShift = 42
+)
+
+// wheelDelta: una muesca de la rueda en las unidades de MouseScroll (las de
+// Windows); REL_WHEEL_HI_RES usa la misma escala.
+const wheelDelta = 120
+
+// eventSize: struct input_event = timeval (2 longs) + type, code (u16) + value (s32).
+var eventSize = 2*strconv.IntSize/8 + 8
+
+type inputEvent struct {
+	typ, code uint16
+	value     int32
+}
+
+// encodeEvents serializa evs como input_event (con el tiempo en cero: el
+// kernel pone la hora al recibirlos).
+func encodeEvents(evs []inputEvent) []byte {
+	buf := make([]byte, 0, len(evs)*eventSize)
+	var rec [8]byte
+	for _, ev := range evs {
+		buf = append(buf, make([]byte, eventSize-8)...)
+		binary.NativeEndian.PutUint16(rec[0:], ev.typ)
+		binary.NativeEndian.PutUint16(rec[2:], ev.code)
+		binary.NativeEndian.PutUint32(rec[4:], uint32(ev.value))
+		buf = append(buf, rec[:]...)
+	}
+	return buf
+}
+
+func syn() inputEvent { return inputEvent{evSyn, synReport, 0} }
+
+func keyEv(code uint16, down bool) inputEvent {
+	v := int32(0)
+	if down {
+		v = 1
+	}
+	return inputEvent{evKey, code, v}
+}
+
+// UinputInput implementa InputDriver sobre dos dispositivos uinput.
+type UinputInput struct {
+	mu    sync.Mutex
+	kbd   io.Writer
+	mouse io.Writer
+
+	wheelRest int32 // lo que falta para la próxima muesca de REL_WHEEL
+
+	closer func() error // destruye los dispositivos (nil en tests)
+}
+
+// newUinputWriters arma el driver sobre writers cualquiera (tests).
+func newUinputWriters(kbd, mouse io.Writer) *UinputInput {
+	return &UinputInput{kbd: kbd, mouse: mouse}
+}
+
+// Close destruye los dispositivos virtuales.
+func (u *UinputInput) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closer == nil {
+		return nil
+	}
+	err := u.closer()
+	u.closer = nil
+	return err
+}
+
+// Supports: sin captura de teclas ni lista de apps (son de Windows).
+func (u *UinputInput) Supports(f Feature) bool {
+	return f == FeatureMouse || f == FeatureKeyboard
+}
+
+// writeFrames manda cada frame seguido de SYN_REPORT, todo en una sola
+// escritura (para que no se mezcle con otro mensaje).
+func (u *UinputInput) writeFrames(w io.Writer, frames ...[]inputEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.writeFramesLocked(w, frames...)
+}
+
+func (u *UinputInput) writeFramesLocked(w io.Writer, frames ...[]inputEvent) error {
+	var evs []inputEvent
+	for _, f := range frames {
+		if len(f) > 0 {
+			evs = append(append(evs, f...), syn())
+		}
+	}
+	if len(evs) == 0 {
+		return nil
+	}
+	_, err := w.Write(encodeEvents(evs))
+	return err
+}
+
+// writeKeys manda cada evento de tecla en su propio frame: un down y un up en
+// el mismo frame algunas apps no los ven.
+func (u *UinputInput) writeKeys(evs ...inputEvent) error {
+	frames := make([][]inputEvent, len(evs))
+	for i, ev := range evs {
+		frames[i] = []inputEvent{ev}
+	}
+	return u.writeFrames(u.kbd, frames...)
+}
+
+// ---- mouse ----
+
+func (u *UinputInput) MoveMouse(dx, dy int32) error {
+	var evs []inputEvent
+	if dx != 0 {
+		evs = append(evs, inputEvent{evRel, relX, dx})
+	}
+	if dy != 0 {
+		evs = append(evs, inputEvent{evRel, relY, dy})
+	}
+	return u.writeFrames(u.mouse, evs)
+}
+
+// buttonCode: mismos botones que el driver de Windows ("" = left).
+func buttonCode(button string) (uint16, error) {
+	switch strings.ToLower(button) {
+	case "", "left":
+		return btnLeft, nil
+	case "right":
+		return btnRight, nil
+	case "middle":
+		return btnMiddle, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrInvalidButton, button)
+	}
+}
+
+func (u *UinputInput) MouseClick(button string) error {
+	code, err := buttonCode(button)
+	if err != nil {
+		return err
+	}
+	return u.writeFrames(u.mouse, []inputEvent{keyEv(code, true)}, []inputEvent{keyEv(code, false)})
+}
+
+func (u *UinputInput) MouseDown(button string) error {
+	code, err := buttonCode(button)
+	if err != nil {
+		return err
+	}
+	return u.writeFrames(u.mouse, []inputEvent{keyEv(code, true)})
+}
+
+func (u *UinputInput) MouseUp(button string) error {
+	code, err := buttonCode(button)
+	if err != nil {
+		return err
+	}
+	return u.writeFrames(u.mouse, []inputEvent{keyEv(code, false)})
+}
+
+// MouseScroll: dy en unidades de Windows (120 = una muesca, positivo = hacia
+// arriba). Va tal cual en REL_WHEEL_HI_RES y las muescas completas en
+// REL_WHEEL; el resto se acumula para el próximo.
+func (u *UinputInput) MouseScroll(dy int32) error {
+	if dy == 0 {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.wheelRest += dy
+	notches := u.wheelRest / wheelDelta
+	u.wheelRest -= notches * wheelDelta
+
+	evs := []inputEvent{{evRel, relWheelHiRes, dy}}
+	if notches != 0 {
+		evs = append(evs, inputEvent{evRel, relWheel, notches})
+	}
+	return u.writeFramesLocked(u.mouse, evs)
+}
+
+// ---- teclado ----
+
+// keyCode traduce un nombre del catálogo (keys.go) a keycode de Linux.
+func keyCode(name string) (uint16, error) {
+	k, err := keyByName(name)
+	return k.Linux, err
+}
+
+func modCodes(mods []string) ([]uint16, error) {
+	mk, err := modKeys(mods)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint16, len(mk))
+	for i, k := range mk {
+		out[i] = k.Linux
+	}
+	return out, nil
+}
+
+// chord: mods apretados, key down/up y mods soltados en orden inverso.
+func chord(mods []uint16, code uint16) []inputEvent {
+	evs := make([]inputEvent, 0, 2*len(mods)+2)
+	for _, m := range mods {
+		evs = append(evs, keyEv(m, true))
+	}
+	if code != 0 {
+		evs = append(evs, keyEv(code, true), keyEv(code, false))
+	}
+	for i := len(mods) - 1; i >= 0; i-- {
+		evs = append(evs, keyEv(mods[i], false))
+	}
+	return evs
+}
+
+// KeyText escribe text con la distribución US (uinput manda teclas, no
+// caracteres): falla sin escribir nada si hay un carácter sin tecla.
+func (u *UinputInput) KeyText(text string) error {
+	var evs []inputEvent
+	for _, r := range text {
+		k, ok := textKeys[r]
+		if !ok {
+			return fmt.Errorf("%w: carácter %q sin tecla en uinput", ErrUnknownKey, r)
+		}
+		var mods []uint16
+		if k.shift {
+			mods = []uint16{keyLeftShift}
+		}
+		evs = append(evs, chord(mods, k.code)...)
+	}
+	return u.writeKeys(evs...)
+}
+
+func (u *UinputInput) Key(key string) error {
+	code, err := keyCode(key)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, true), keyEv(code, false))
+}
+
+func (u *UinputInput) KeyDown(key string) error {
+	code, err := keyCode(key)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, true))
+}
+
+func (u *UinputInput) KeyUp(key string) error {
+	code, err := keyCode(key)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, false))
+}
+
+func (u *UinputInput) Hotkey(mods []string, key string) error {
+	code, err := keyCode(key)
+	if err != nil {
+		return err
+	}
+	mc, err := modCodes(mods)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(chord(mc, code)...)
+}
+
+// ---- Stable VK/Scan execution (phone-defined bindings) ----
+
+func (u *UinputInput) KeyVK(k KeySpec) error {
+	if k.VK == 0 && k.Scan == 0 {
+		return nil
+	}
+	code, err := specCode(k)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, true), keyEv(code, false))
+}
+
+func (u *UinputInput) KeyDownVK(k KeySpec) error {
+	if k.VK == 0 && k.Scan == 0 {
+		return nil
+	}
+	code, err := specCode(k)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, true))
+}
+
+func (u *UinputInput) KeyUpVK(k KeySpec) error {
+	if k.VK == 0 && k.Scan == 0 {
+		return nil
+	}
+	code, err := specCode(k)
+	if err != nil {
+		return err
+	}
+	return u.writeKeys(keyEv(code, false))
+}
+
+func (u *UinputInput) HotkeyVK(mods []string, k KeySpec) error {
+	mc, err := modCodes(mods)
+	if err != nil {
+		return err
+	}
+	var code uint16
+	if k.VK != 0 || k.Scan != 0 {
+		if code, err = specCode(k); err != nil {
+			return err
+		}
+	}
+	return u.writeKeys(chord(mc, code)...)
+}
+
+// ---- lo que sólo existe en Windows ----
+
+func (u *UinputInput) CaptureNextKey(ctx context.Context) (CaptureResult, error) {
+	return CaptureResult{}, fmt.Errorf("%w: captura de teclas", ErrNotSupported)
+}
+
+func (u *UinputInput) ListApps() ([]AppInfo, error) {
+	return nil, fmt.Errorf("%w: lista de apps", ErrNotSupported)
+}
+
+func (u *UinputInput) AppAction(hwnd uintptr, action string) error {
+	return fmt.Errorf("%w: acciones de apps", ErrNotSupported)
+}
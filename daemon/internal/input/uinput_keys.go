@@ -0,0 +1,49 @@
+package input
+
+import "fmt"
+
+// specCode traduce un KeySpec guardado en el teléfono a keycode de Linux:
+// primero por el catálogo (VK, si no scan code); si no está, un scan code sin
+// E0 entre 0x01 y 0x58 coincide con el KEY_* de Linux.
+func specCode(k KeySpec) (uint16, error) {
+	if info, ok := LookupSpec(k); ok {
+		return info.Linux, nil
+	}
+	if !k.Ext && k.Scan >= 0x01 && k.Scan <= 0x58 {
+		return k.Scan, nil
+	}
+	return 0, fmt.Errorf("%w: vk=%d scan=%d ext=%v", ErrUnknownKey, k.VK, k.Scan, k.Ext)
+}
+
+type textKey struct {
+	code  uint16
+	shift bool
+}
+
+// textKeys: caracteres que KeyText sabe escribir (distribución US). Los que
+// no llevan shift son los nombres de una letra del catálogo ("a", "1", "-", ...).
+var textKeys = func() map[rune]textKey {
+	m := map[rune]textKey{
+		' ':  {57, false},
+		'\n': {28, false},
+		'\t': {15, false},
+	}
+	for _, k := range keys {
+		for _, n := range append([]string{k.Name}, k.Aliases...) {
+			if r := []rune(n); len(r) == 1 {
+				m[r[0]] = textKey{k.Linux, false}
+			}
+		}
+	}
+	for plain, shifted := range map[rune]rune{
+		'1': '!', '2': '@', '3': '#', '4': '$', '5': '%', '6': '^', '7': '&', '8': '*', '9': '(', '0': ')',
+		'-': '_', '=': '+', '[': '{', ']': '}', ';': ':', '\'': '"', '`': '~', '\\': '|',
+		',': '<', '.': '>', '/': '?',
+	} {
+		m[shifted] = textKey{m[plain].code, true}
+	}
+	for r := 'a'; r <= 'z'; r++ {
+		m[r-'a'+'A'] = textKey{m[r].code, true}
+	}
+	return m
+}()
@@ -0,0 +1,141 @@
+//go:build linux
+
+package input
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctls de linux/uinput.h (codificación de x86/arm).
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503 // _IOW('U', 3, struct uinput_setup)
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+
+	busVirtual = 0x06
+)
+
+// keyboardCodes: lo que habilitamos en el teclado virtual, las teclas del
+// catálogo más los scan codes crudos que acepta specCode (0x01..0x58).
+func keyboardCodes() []uint16 {
+	seen := map[uint16]bool{}
+	var out []uint16
+	for code := uint16(0x01); code <= 0x58; code++ {
+		seen[code] = true
+		out = append(out, code)
+	}
+	for _, k := range keys {
+		if !seen[k.Linux] {
+			seen[k.Linux] = true
+			out = append(out, k.Linux)
+		}
+	}
+	return out
+}
+
+// struct uinput_setup
+type uinputSetup struct {
+	bustype, vendor, product, version uint16
+	name                              [80]byte
+	ffEffectsMax                      uint32
+}
+
+const uinputPath = "/dev/uinput"
+
+// NewUinput crea un teclado y un mouse virtuales. Hace falta permiso de
+// escritura en /dev/uinput (root o una regla de udev para el grupo input).
+func NewUinput() (*UinputInput, error) {
+	kbd, err := createUinputDevice("DeskControl keyboard", func(fd int) error {
+		if err := unix.IoctlSetInt(fd, uiSetEvBit, evKey); err != nil {
+			return err
+		}
+		for _, code := range keyboardCodes() {
+			if err := unix.IoctlSetInt(fd, uiSetKeyBit, int(code)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mouse, err := createUinputDevice("DeskControl mouse", func(fd int) error {
+		for _, ev := range []int{evKey, evRel} {
+			if err := unix.IoctlSetInt(fd, uiSetEvBit, ev); err != nil {
+				return err
+			}
+		}
+		for _, btn := range []int{btnLeft, btnRight, btnMiddle} {
+			if err := unix.IoctlSetInt(fd, uiSetKeyBit, btn); err != nil {
+				return err
+			}
+		}
+		for _, rel := range []int{relX, relY, relWheel, relWheelHiRes} {
+			if err := unix.IoctlSetInt(fd, uiSetRelBit, rel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		destroyUinputDevice(kbd)
+		return nil, err
+	}
+
+	// el escritorio tarda un poco en tomar los dispositivos nuevos; lo que se
+	// mande antes se pierde
+	time.Sleep(200 * time.Millisecond)
+
+	u := newUinputWriters(kbd, mouse)
+	u.closer = func() error {
+		err1 := destroyUinputDevice(kbd)
+		err2 := destroyUinputDevice(mouse)
+		if err1 != nil {
+			return err1
+		}
+		return err2
+	}
+	return u, nil
+}
+
+func createUinputDevice(name string, enable func(fd int) error) (*os.File, error) {
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("uinput: %w", err)
+	}
+	fd := int(f.Fd())
+
+	if err := enable(fd); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("uinput %s: %w", name, err)
+	}
+
+	setup := uinputSetup{bustype: busVirtual, vendor: 0x1209, product: 0xdc01, version: 1}
+	copy(setup.name[:len(setup.name)-1], name)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uiDevSetup, uintptr(unsafe.Pointer(&setup))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("uinput %s: UI_DEV_SETUP: %w", name, errno)
+	}
+	if err := unix.IoctlSetInt(fd, uiDevCreate, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("uinput %s: UI_DEV_CREATE: %w", name, err)
+	}
+	return f, nil
+}
+
+func destroyUinputDevice(f *os.File) error {
+	err := unix.IoctlSetInt(int(f.Fd()), uiDevDestroy, 0)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
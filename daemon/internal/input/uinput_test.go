@@ -0,0 +1,240 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recorder guarda cada Write por separado.
+type recorder struct{ writes [][]byte }
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// events decodifica todo lo escrito como input_event, revisando que el
+// timeval vaya en cero.
+func (r *recorder) events(t *testing.T) []inputEvent {
+	t.Helper()
+	var out []inputEvent
+	for _, w := range r.writes {
+		if len(w)%eventSize != 0 {
+			t.Fatalf("write de %d bytes no es múltiplo de %d", len(w), eventSize)
+		}
+		for off := 0; off < len(w); off += eventSize {
+			rec := w[off : off+eventSize]
+			tv := eventSize - 8
+			if !bytes.Equal(rec[:tv], make([]byte, tv)) {
+				t.Fatalf("timeval distinto de cero: % x", rec[:tv])
+			}
+			out = append(out, inputEvent{
+				typ:   binary.NativeEndian.Uint16(rec[tv:]),
+				code:  binary.NativeEndian.Uint16(rec[tv+2:]),
+				value: int32(binary.NativeEndian.Uint32(rec[tv+4:])),
+			})
+		}
+	}
+	return out
+}
+
+func newTestUinput() (*UinputInput, *recorder, *recorder) {
+	kbd, mouse := &recorder{}, &recorder{}
+	return newUinputWriters(kbd, mouse), kbd, mouse
+}
+
+func down(code uint16) inputEvent { return inputEvent{evKey, code, 1} }
+func up(code uint16) inputEvent   { return inputEvent{evKey, code, 0} }
+
+// frames intercala un SYN_REPORT después de cada evento (como manda el teclado).
+func frames(evs ...inputEvent) []inputEvent {
+	out := make([]inputEvent, 0, 2*len(evs))
+	for _, ev := range evs {
+		out = append(out, ev, syn())
+	}
+	return out
+}
+
+func checkEvents(t *testing.T, what string, got, want []inputEvent) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s:\n got  %v\n want %v", what, got, want)
+	}
+}
+
+func TestUinputMouse(t *testing.T) {
+	u, kbd, mouse := newTestUinput()
+
+	if err := u.MoveMouse(-5, 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MoveMouse(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MoveMouse(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MouseClick("right"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MouseDown(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MouseUp("LEFT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.MouseClick("middle"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkEvents(t, "mouse", mouse.events(t), []inputEvent{
+		{evRel, relX, -5}, {evRel, relY, 12}, syn(),
+		{evRel, relX, 3}, syn(),
+		down(btnRight), syn(), up(btnRight), syn(),
+		down(btnLeft), syn(),
+		up(btnLeft), syn(),
+		down(btnMiddle), syn(), up(btnMiddle), syn(),
+	})
+	if len(mouse.writes) != 6 {
+		t.Errorf("writes = %d, want 6 (uno por llamada, nada para 0,0)", len(mouse.writes))
+	}
+	if len(kbd.writes) != 0 {
+		t.Errorf("el mouse escribió en el teclado: %v", kbd.events(t))
+	}
+}
+
+func TestUinputScroll(t *testing.T) {
+	u, _, mouse := newTestUinput()
+	for _, dy := range []int32{120, 60, 60, -240, -30} {
+		if err := u.MouseScroll(dy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkEvents(t, "scroll", mouse.events(t), []inputEvent{
+		{evRel, relWheelHiRes, 120}, {evRel, relWheel, 1}, syn(),
+		{evRel, relWheelHiRes, 60}, syn(),
+		{evRel, relWheelHiRes, 60}, {evRel, relWheel, 1}, syn(),
+		{evRel, relWheelHiRes, -240}, {evRel, relWheel, -2}, syn(),
+		{evRel, relWheelHiRes, -30}, syn(),
+	})
+}
+
+func TestUinputNamedKeys(t *testing.T) {
+	cases := []struct {
+		key  string
+		code uint16
+	}{
+		{"enter", 28}, {"Backspace", 14}, {"esc", 1}, {"escape", 1},
+		{"up", 103}, {"down", 108}, {"left", 105}, {"right", 106},
+		{"del", 111}, {"win", 125},
+		{"vol_mute", 113}, {"vol_down", 114}, {"vol_up", 115},
+		{"media_next", 163}, {"media_prev", 165}, {"media_play_pause", 164},
+		{"a", 30}, {"z", 44}, {"0", 11}, {"1", 2}, {"f1", 59}, {"f10", 68}, {"f12", 88},
+	}
+	for _, c := range cases {
+		u, kbd, _ := newTestUinput()
+		if err := u.Key(c.key); err != nil {
+			t.Errorf("Key(%q): %v", c.key, err)
+			continue
+		}
+		checkEvents(t, "Key("+c.key+")", kbd.events(t), frames(down(c.code), up(c.code)))
+	}
+}
+
+func TestUinputKeySpec(t *testing.T) {
+	cases := []struct {
+		k    KeySpec
+		code uint16
+	}{
+		{KeySpec{VK: 0x25, Scan: 0x4B, Ext: true}, 105}, // LEFT por VK
+		{KeySpec{VK: 0x0D, Ext: true}, 96},              // ENTER del keypad
+		{KeySpec{VK: 0x11, Ext: true}, 97},              // CTRL derecho
+		{KeySpec{VK: 0xB3}, 164},                        // play/pause
+		{KeySpec{Scan: 0x1E}, 30},                       // A por scan
+		{KeySpec{VK: 0xFF, Scan: 0x48, Ext: true}, 103}, // VK raro, UP por scan E0
+	}
+	for _, c := range cases {
+		u, kbd, _ := newTestUinput()
+		if err := u.KeyVK(c.k); err != nil {
+			t.Errorf("KeyVK(%+v): %v", c.k, err)
+			continue
+		}
+		if err := u.KeyDownVK(c.k); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.KeyUpVK(c.k); err != nil {
+			t.Fatal(err)
+		}
+		checkEvents(t, "KeyVK", kbd.events(t), frames(down(c.code), up(c.code), down(c.code), up(c.code)))
+	}
+
+	u, kbd, _ := newTestUinput()
+	if err := u.KeyVK(KeySpec{}); err != nil || len(kbd.writes) != 0 {
+		t.Errorf("KeyVK vacío: err=%v writes=%d", err, len(kbd.writes))
+	}
+}
+
+func TestUinputHotkey(t *testing.T) {
+	u, kbd, _ := newTestUinput()
+	if err := u.Hotkey([]string{"ctrl", "shift"}, "esc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.HotkeyVK([]string{"win"}, KeySpec{VK: 'D'}); err != nil {
+		t.Fatal(err)
+	}
+	// sólo mods (el teléfono manda así un "win" suelto)
+	if err := u.HotkeyVK([]string{"alt"}, KeySpec{}); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "hotkey", kbd.events(t), frames(
+		down(29), down(42), down(1), up(1), up(42), up(29),
+		down(125), down(32), up(32), up(125),
+		down(56), up(56),
+	))
+}
+
+func TestUinputKeyText(t *testing.T) {
+	u, kbd, _ := newTestUinput()
+	if err := u.KeyText("aB!"); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, "KeyText", kbd.events(t), frames(
+		down(30), up(30),
+		down(keyLeftShift), down(48), up(48), up(keyLeftShift),
+		down(keyLeftShift), down(2), up(2), up(keyLeftShift),
+	))
+	if len(kbd.writes) != 1 {
+		t.Errorf("writes = %d, want 1", len(kbd.writes))
+	}
+}
+
+func TestUinputRejects(t *testing.T) {
+	u, kbd, mouse := newTestUinput()
+
+	if err := u.Key("nope"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Key(nope) = %v", err)
+	}
+	if err := u.Hotkey([]string{"hyper"}, "a"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Hotkey(hyper) = %v", err)
+	}
+	if err := u.KeyVK(KeySpec{VK: 0xFF, Scan: 0x7F}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("KeyVK sin equivalente = %v", err)
+	}
+	// todo o nada: "ñ" no tiene tecla en la distribución US
+	if err := u.KeyText("hola ñ"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("KeyText(ñ) = %v", err)
+	}
+	if err := u.MouseClick("back"); !errors.Is(err, ErrInvalidButton) {
+		t.Errorf("MouseClick(back) = %v", err)
+	}
+	if _, err := u.CaptureNextKey(context.Background()); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("CaptureNextKey = %v", err)
+	}
+	if len(kbd.writes)+len(mouse.writes) != 0 {
+		t.Errorf("se escribió algo con errores: kbd=%v mouse=%v", kbd.events(t), mouse.events(t))
+	}
+}
@@ -3,6 +3,7 @@
 package input
 
 import (
+	"fmt"
 	"strings"
 	"syscall"
 	"unicode/utf16"
@@ -14,12 +15,14 @@ const (
 	INPUT_KEYBOARD = 1
 
 	// Mouse flags
-	MOUSEEVENTF_MOVE      = 0x0001
-	MOUSEEVENTF_LEFTDOWN  = 0x0002
-	MOUSEEVENTF_LEFTUP    = 0x0004
-	MOUSEEVENTF_RIGHTDOWN = 0x0008
-	MOUSEEVENTF_RIGHTUP   = 0x0010
-	MOUSEEVENTF_WHEEL     = 0x0800
+	MOUSEEVENTF_MOVE       = 0x0001
+	MOUSEEVENTF_LEFTDOWN   = 0x0002
+	MOUSEEVENTF_LEFTUP     = 0x0004
+	MOUSEEVENTF_RIGHTDOWN  = 0x0008
+	MOUSEEVENTF_RIGHTUP    = 0x0010
+	MOUSEEVENTF_MIDDLEDOWN = 0x0020
+	MOUSEEVENTF_MIDDLEUP   = 0x0040
+	MOUSEEVENTF_WHEEL      = 0x0800
 
 	// Keyboard flags
 	KEYEVENTF_EXTENDEDKEY = 0x0001
@@ -124,14 +127,24 @@ func (w *WindowsInput) MoveMouse(dx, dy int32) error {
 	return w.send([]INPUT{mouseInput(MOUSEEVENTF_MOVE, dx, dy, 0)})
 }
 
-func (w *WindowsInput) MouseClick(button string) error {
-	button = strings.ToLower(button)
-	var down, up uint32
-	switch button {
+// buttonFlags devuelve los flags down/up del botón ("" = left, por compatibilidad).
+func buttonFlags(button string) (down, up uint32, err error) {
+	switch strings.ToLower(button) {
+	case "", "left":
+		return MOUSEEVENTF_LEFTDOWN, MOUSEEVENTF_LEFTUP, nil
 	case "right":
-		down, up = MOUSEEVENTF_RIGHTDOWN, MOUSEEVENTF_RIGHTUP
+		return MOUSEEVENTF_RIGHTDOWN, MOUSEEVENTF_RIGHTUP, nil
+	case "middle":
+		return MOUSEEVENTF_MIDDLEDOWN, MOUSEEVENTF_MIDDLEUP, nil
 	default:
-		down, up = MOUSEEVENTF_LEFTDOWN, MOUSEEVENTF_LEFTUP
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidButton, button)
+	}
+}
+
+func (w *WindowsInput) MouseClick(button string) error {
+	down, up, err := buttonFlags(button)
+	if err != nil {
+		return err
 	}
 	return w.send([]INPUT{
 		mouseInput(down, 0, 0, 0),
@@ -140,27 +153,19 @@ func (w *WindowsInput) MouseClick(button string) error {
 }
 
 func (w *WindowsInput) MouseDown(button string) error {
-	button = strings.ToLower(button)
-	var flag uint32
-	switch button {
-	case "right":
-		flag = MOUSEEVENTF_RIGHTDOWN
-	default:
-		flag = MOUSEEVENTF_LEFTDOWN
+	down, _, err := buttonFlags(button)
+	if err != nil {
+		return err
 	}
-	return w.send([]INPUT{mouseInput(flag, 0, 0, 0)})
+	return w.send([]INPUT{mouseInput(down, 0, 0, 0)})
 }
 
 func (w *WindowsInput) MouseUp(button string) error {
-	button = strings.ToLower(button)
-	var flag uint32
-	switch button {
-	case "right":
-		flag = MOUSEEVENTF_RIGHTUP
-	default:
-		flag = MOUSEEVENTF_LEFTUP
+	_, up, err := buttonFlags(button)
+	if err != nil {
+		return err
 	}
-	return w.send([]INPUT{mouseInput(flag, 0, 0, 0)})
+	return w.send([]INPUT{mouseInput(up, 0, 0, 0)})
 }
 
 func (w *WindowsInput) MouseScroll(dy int32) error {
@@ -184,58 +189,38 @@ func (w *WindowsInput) KeyText(text string) error {
 }
 
 func (w *WindowsInput) Key(key string) error {
-	vk := vkFromKey(strings.ToLower(key))
-	if vk == 0 {
-		return nil
+	k, err := keyByName(key)
+	if err != nil {
+		return err
 	}
 	return w.send([]INPUT{
-		keyInput(vk, 0, 0),
-		keyInput(vk, 0, KEYEVENTF_KEYUP),
+		keyInputSpec(k.Key, 0),
+		keyInputSpec(k.Key, KEYEVENTF_KEYUP),
 	})
 }
 
 func (w *WindowsInput) KeyDown(key string) error {
-	vk := vkFromKey(strings.ToLower(key))
-	if vk == 0 {
-		return nil
+	k, err := keyByName(key)
+	if err != nil {
+		return err
 	}
-	return w.send([]INPUT{keyInput(vk, 0, 0)})
+	return w.send([]INPUT{keyInputSpec(k.Key, 0)})
 }
 
 func (w *WindowsInput) KeyUp(key string) error {
-	vk := vkFromKey(strings.ToLower(key))
-	if vk == 0 {
-		return nil
+	k, err := keyByName(key)
+	if err != nil {
+		return err
 	}
-	return w.send([]INPUT{keyInput(vk, 0, KEYEVENTF_KEYUP)})
+	return w.send([]INPUT{keyInputSpec(k.Key, KEYEVENTF_KEYUP)})
 }
 
 func (w *WindowsInput) Hotkey(mods []string, key string) error {
-	var inputs []INPUT
-
-	for _, m := range mods {
-		vk := vkFromMod(strings.ToLower(m))
-		if vk != 0 {
-			inputs = append(inputs, keyInput(vk, 0, 0))
-		}
-	}
-
-	main := vkFromKey(strings.ToLower(key))
-	if main != 0 {
-		inputs = append(inputs,
-			keyInput(main, 0, 0),
-			keyInput(main, 0, KEYEVENTF_KEYUP),
-		)
-	}
-
-	for i := len(mods) - 1; i >= 0; i-- {
-		vk := vkFromMod(strings.ToLower(mods[i]))
-		if vk != 0 {
-			inputs = append(inputs, keyInput(vk, 0, KEYEVENTF_KEYUP))
-		}
+	k, err := keyByName(key)
+	if err != nil {
+		return err
 	}
-
-	return w.send(inputs)
+	return w.HotkeyVK(mods, k.Key)
 }
 
 // ---- Stable VK/Scan execution (phone-defined bindings) ----
@@ -262,12 +247,14 @@ func (w *WindowsInput) KeyUpVK(k KeySpec) error {
 }
 
 func (w *WindowsInput) HotkeyVK(mods []string, k KeySpec) error {
+	mk, err := modKeys(mods)
+	if err != nil {
+		return err
+	}
+
 	var inputs []INPUT
-	for _, m := range mods {
-		vk := vkFromMod(strings.ToLower(m))
-		if vk != 0 {
-			inputs = append(inputs, keyInput(vk, 0, 0))
-		}
+	for _, m := range mk {
+		inputs = append(inputs, keyInputSpec(m.Key, 0))
 	}
 	if k.VK != 0 || k.Scan != 0 {
 		inputs = append(inputs,
@@ -275,97 +262,8 @@ func (w *WindowsInput) HotkeyVK(mods []string, k KeySpec) error {
 			keyInputSpec(k, KEYEVENTF_KEYUP),
 		)
 	}
-	for i := len(mods) - 1; i >= 0; i-- {
-		vk := vkFromMod(strings.ToLower(mods[i]))
-		if vk != 0 {
-			inputs = append(inputs, keyInput(vk, 0, KEYEVENTF_KEYUP))
-		}
+	for i := len(mk) - 1; i >= 0; i-- {
+		inputs = append(inputs, keyInputSpec(mk[i].Key, KEYEVENTF_KEYUP))
 	}
 	return w.send(inputs)
 }
-
-func vkFromMod(m string) uint16 {
-	switch m {
-	case "ctrl", "control":
-		return 0x11 // VK_CONTROL
-	case "alt":
-		return 0x12 // VK_MENU
-	case "shift":
-		return 0x10 // VK_SHIFT
-	case "win", "windows", "meta":
-		return 0x5B // VK_LWIN
-	default:
-		return 0
-	}
-}
-
-func vkFromKey(k string) uint16 {
-	switch k {
-	case "enter":
-		return 0x0D
-	case "backspace":
-		return 0x08
-	case "tab":
-		return 0x09
-	case "esc", "escape":
-		return 0x1B
-	case "space":
-		return 0x20
-
-	case "up":
-		return 0x26
-	case "down":
-		return 0x28
-	case "left":
-		return 0x25
-	case "right":
-		return 0x27
-
-	case "delete", "del":
-		return 0x2E // VK_DELETE
-	case "win", "windows":
-		return 0x5B // VK_LWIN
-
-	// Audio / media
-	case "vol_mute":
-		return 0xAD // VK_VOLUME_MUTE
-	case "vol_down":
-		return 0xAE // VK_VOLUME_DOWN
-	case "vol_up":
-		return 0xAF // VK_VOLUME_UP
-	case "media_next":
-		return 0xB0 // VK_MEDIA_NEXT_TRACK
-	case "media_prev":
-		return 0xB1 // VK_MEDIA_PREV_TRACK
-	case "media_play_pause":
-		return 0xB3 // VK_MEDIA_PLAY_PAUSE
-	}
-
-	// letters
-	if len(k) == 1 {
-		c := k[0]
-		if c >= 'a' && c <= 'z' {
-			return uint16(c - 32) // 'A'
-		}
-		if c >= '0' && c <= '9' {
-			return uint16(c)
-		}
-	}
-
-	// F1..F12
-	if strings.HasPrefix(k, "f") && len(k) <= 3 {
-		n := 0
-		for _, ch := range k[1:] {
-			if ch < '0' || ch > '9' {
-				n = 0
-				break
-			}
-			n = n*10 + int(ch-'0')
-		}
-		if n >= 1 && n <= 12 {
-			return uint16(0x70 + (n - 1))
-		}
-	}
-
-	return 0
-}
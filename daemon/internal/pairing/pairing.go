@@ -1,10 +1,10 @@
-package main
+// Package pairing arma el payload deskcontrol://pair que va en el QR de
+// emparejamiento (UI y daemon headless).
+package pairing
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
@@ -14,21 +14,12 @@ import (
 	"strings"
 
 	qrcode "github.com/skip2/go-qrcode"
-)
 
-func generateTokenURLSafe(nbytes int) (string, error) {
-	if nbytes <= 0 {
-		nbytes = 32
-	}
-	b := make([]byte, nbytes)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
-}
+	"deskcontrol/daemon/internal/config"
+)
 
-// bestLocalIP tries to pick a sane LAN IP (for QR host field).
-func bestLocalIP() string {
+// BestLocalIP tries to pick a sane LAN IP (for QR host field).
+func BestLocalIP() string {
 	ifaces, _ := net.Interfaces()
 	for _, iface := range ifaces {
 		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
@@ -60,8 +51,8 @@ func bestLocalIP() string {
 	return "127.0.0.1"
 }
 
-// certFingerprintSHA256Hex reads a PEM cert and returns SHA-256 of its DER bytes as HEX.
-func certFingerprintSHA256Hex(certPath string) (string, error) {
+// CertFingerprintSHA256Hex reads a PEM cert and returns SHA-256 of its DER bytes as HEX.
+func CertFingerprintSHA256Hex(certPath string) (string, error) {
 	certPath = strings.TrimSpace(certPath)
 	if certPath == "" {
 		return "", fmt.Errorf("TLSCertPath vacío")
@@ -82,9 +73,11 @@ func certFingerprintSHA256Hex(certPath string) (string, error) {
 	return hex.EncodeToString(sum[:]), nil
 }
 
-// tokenQRPNGForConfig returns PNG bytes + payload string.
-func tokenQRPNGForConfig(cfg AppConfig) ([]byte, string, error) {
-	host := bestLocalIP()
+// Payload arma el deskcontrol://pair?... para cfg.
+// Con TLS lleva pairSecret (código de emparejamiento de un solo uso,
+// ws.NewPairingCode), nunca el token: el teléfono lo cambia por su credencial.
+func Payload(cfg config.AppConfig, pairSecret string) (string, error) {
+	host := BestLocalIP()
 	port := cfg.WSPort
 
 	q := url.Values{}
@@ -94,16 +87,15 @@ func tokenQRPNGForConfig(cfg AppConfig) ([]byte, string, error) {
 	if cfg.EncryptTrafficTLS {
 		q.Set("tls", "1")
 
-		if strings.TrimSpace(cfg.Token) != "" {
-			q.Set("token", strings.TrimSpace(cfg.Token))
-		} else {
-			return nil, "", fmt.Errorf("TLS ON pero token está vacío")
+		if pairSecret == "" {
+			return "", fmt.Errorf("TLS ON pero no hay código de emparejamiento")
 		}
+		q.Set("pair", pairSecret)
 
 		// ✅ fingerprint en vez de cert completo (QR chico y legible)
-		fp, err := certFingerprintSHA256Hex(cfg.TLSCertPath)
+		fp, err := CertFingerprintSHA256Hex(cfg.TLSCertPath)
 		if err != nil {
-			return nil, "", fmt.Errorf("no pude obtener fingerprint: %w", err)
+			return "", fmt.Errorf("no pude obtener fingerprint: %w", err)
 		}
 		q.Set("fp", fp)
 	} else {
@@ -111,9 +103,25 @@ func tokenQRPNGForConfig(cfg AppConfig) ([]byte, string, error) {
 		// modo simple: sin token/fp
 	}
 
-	payload := "deskcontrol://pair?" + q.Encode()
+	return "deskcontrol://pair?" + q.Encode(), nil
+}
 
+// QRPNG returns PNG bytes + payload string.
+func QRPNG(cfg config.AppConfig, pairSecret string) ([]byte, string, error) {
+	payload, err := Payload(cfg, pairSecret)
+	if err != nil {
+		return nil, "", err
+	}
 	// QR pequeño y fácil de escanear
 	png, err := qrcode.Encode(payload, qrcode.Medium, 320)
 	return png, payload, err
 }
+
+// QRText dibuja el QR de payload con caracteres de bloque (para la terminal).
+func QRText(payload string) (string, error) {
+	q, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+// Encoding binaria opcional para los mensajes de alta frecuencia.
+//
+// Se negocia al conectar (query "?enc=bin" o "binary": true en hello) y a partir
+// de ahí el cliente puede mandar frames binarios de WebSocket además de JSON.
+// Cada frame es un solo mensaje: 1 byte de opcode + payload fijo, little-endian.
+// No llevan id ni ack (son fire-and-forget como mouse_move en JSON).
+//
+//	OpMouseMove   0x01  dx int16, dy int16            (5 bytes)
+//	OpMouseScroll 0x02  dy int16                      (3 bytes)
+//	OpMouseDown   0x03  button uint8                  (2 bytes)
+//	OpMouseUp     0x04  button uint8                  (2 bytes)
+//	OpKeyDownVK   0x05  vk uint16, scan uint16, flags (6 bytes, flags bit0 = ext)
+//	OpKeyUpVK     0x06  vk uint16, scan uint16, flags (6 bytes)
+const (
+	OpMouseMove   byte = 0x01
+	OpMouseScroll byte = 0x02
+	OpMouseDown   byte = 0x03
+	OpMouseUp     byte = 0x04
+	OpKeyDownVK   byte = 0x05
+	OpKeyUpVK     byte = 0x06
+)
+
+// EncodingBinary es el valor de "?enc=" que activa los frames binarios.
+const EncodingBinary = "bin"
+
+// Botones en el byte de OpMouseDown/OpMouseUp.
+const (
+	BinButtonLeft   byte = 0
+	BinButtonRight  byte = 1
+	BinButtonMiddle byte = 2
+)
+
+var binButtons = [...]string{BinButtonLeft: "left", BinButtonRight: "right", BinButtonMiddle: "middle"}
+
+const keyFlagExt byte = 1 << 0
+
+// ErrBinaryFrame: el frame binario no se pudo decodificar.
+var ErrBinaryFrame = errors.New("frame binario inválido")
+
+var binSizes = [...]int{
+	OpMouseMove:   5,
+	OpMouseScroll: 3,
+	OpMouseDown:   2,
+	OpMouseUp:     2,
+	OpKeyDownVK:   6,
+	OpKeyUpVK:     6,
+}
+
+// DecodeBinary convierte un frame binario en el mismo struct que produciría el
+// JSON equivalente (*MouseMove, *MouseScroll, *MouseButton o *KeyVK) y su type.
+func DecodeBinary(b []byte) (string, any, error) {
+	if len(b) == 0 {
+		return "", nil, fmt.Errorf("%w: vacío", ErrBinaryFrame)
+	}
+	op := b[0]
+	if int(op) >= len(binSizes) || binSizes[op] == 0 {
+		return "", nil, fmt.Errorf("%w: opcode 0x%02x", ErrBinaryFrame, op)
+	}
+	if len(b) != binSizes[op] {
+		return "", nil, fmt.Errorf("%w: opcode 0x%02x con %d bytes (esperados %d)", ErrBinaryFrame, op, len(b), binSizes[op])
+	}
+
+	switch op {
+	case OpMouseMove:
+		return TypeMouseMove, &MouseMove{
+			Type: TypeMouseMove,
+			Dx:   int32(int16(binary.LittleEndian.Uint16(b[1:]))),
+			Dy:   int32(int16(binary.LittleEndian.Uint16(b[3:]))),
+		}, nil
+	case OpMouseScroll:
+		return TypeMouseScroll, &MouseScroll{Type: TypeMouseScroll, Dy: int32(int16(binary.LittleEndian.Uint16(b[1:])))}, nil
+	case OpMouseDown, OpMouseUp:
+		if int(b[1]) >= len(binButtons) {
+			return "", nil, fmt.Errorf("%w: botón %d", ErrBinaryFrame, b[1])
+		}
+		t := TypeMouseDown
+		if op == OpMouseUp {
+			t = TypeMouseUp
+		}
+		return t, &MouseButton{Type: t, Button: binButtons[b[1]]}, nil
+	default: // OpKeyDownVK, OpKeyUpVK
+		t := TypeKeyDownVK
+		if op == OpKeyUpVK {
+			t = TypeKeyUpVK
+		}
+		return t, &KeyVK{Type: t, Key: input.KeySpec{
+			VK:   binary.LittleEndian.Uint16(b[1:]),
+			Scan: binary.LittleEndian.Uint16(b[3:]),
+			Ext:  b[5]&keyFlagExt != 0,
+		}}, nil
+	}
+}
+
+// AppendBinary agrega a dst el frame binario de msg (lo inverso de DecodeBinary).
+// Lo usan clientes en Go, scripts y los benchmarks.
+func AppendBinary(dst []byte, msg any) ([]byte, error) {
+	switch m := msg.(type) {
+	case *MouseMove:
+		if m.Dx != int32(int16(m.Dx)) || m.Dy != int32(int16(m.Dy)) {
+			return dst, fmt.Errorf("%w: delta fuera de int16 (%d,%d)", ErrBinaryFrame, m.Dx, m.Dy)
+		}
+		dst = append(dst, OpMouseMove)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(int16(m.Dx)))
+		return binary.LittleEndian.AppendUint16(dst, uint16(int16(m.Dy))), nil
+	case *MouseScroll:
+		if m.Dy != int32(int16(m.Dy)) {
+			return dst, fmt.Errorf("%w: scroll fuera de int16 (%d)", ErrBinaryFrame, m.Dy)
+		}
+		dst = append(dst, OpMouseScroll)
+		return binary.LittleEndian.AppendUint16(dst, uint16(int16(m.Dy))), nil
+	case *MouseButton:
+		op := OpMouseDown
+		switch m.Type {
+		case TypeMouseDown:
+		case TypeMouseUp:
+			op = OpMouseUp
+		default:
+			return dst, fmt.Errorf("%w: %s no tiene forma binaria", ErrBinaryFrame, m.Type)
+		}
+		for i, name := range binButtons {
+			if name == m.Button || (m.Button == "" && i == int(BinButtonLeft)) {
+				return append(dst, op, byte(i)), nil
+			}
+		}
+		return dst, fmt.Errorf("%w: botón %q", ErrBinaryFrame, m.Button)
+	case *KeyVK:
+		op := OpKeyDownVK
+		switch m.Type {
+		case TypeKeyDownVK:
+		case TypeKeyUpVK:
+			op = OpKeyUpVK
+		default:
+			return dst, fmt.Errorf("%w: %s no tiene forma binaria", ErrBinaryFrame, m.Type)
+		}
+		var flags byte
+		if m.Key.Ext {
+			flags |= keyFlagExt
+		}
+		dst = append(dst, op)
+		dst = binary.LittleEndian.AppendUint16(dst, m.Key.VK)
+		dst = binary.LittleEndian.AppendUint16(dst, m.Key.Scan)
+		return append(dst, flags), nil
+	}
+	return dst, fmt.Errorf("%w: %T no tiene forma binaria", ErrBinaryFrame, msg)
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	msgs := []any{
+		&MouseMove{Type: TypeMouseMove, Dx: -12, Dy: 300},
+		&MouseScroll{Type: TypeMouseScroll, Dy: -3},
+		&MouseButton{Type: TypeMouseDown, Button: "right"},
+		&MouseButton{Type: TypeMouseUp, Button: "left"},
+		&MouseButton{Type: TypeMouseDown, Button: "middle"},
+		&KeyVK{Type: TypeKeyDownVK, Key: input.KeySpec{VK: 0x25, Scan: 0x4B, Ext: true}},
+		&KeyVK{Type: TypeKeyUpVK, Key: input.KeySpec{VK: 0x10}},
+	}
+	for _, m := range msgs {
+		b, err := AppendBinary(nil, m)
+		if err != nil {
+			t.Fatalf("AppendBinary(%+v): %v", m, err)
+		}
+		typ, got, err := DecodeBinary(b)
+		if err != nil {
+			t.Fatalf("DecodeBinary(% x): %v", b, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("round trip: got %+v, want %+v", got, m)
+		}
+		if typ != reflect.ValueOf(m).Elem().FieldByName("Type").String() {
+			t.Errorf("type %q no coincide con %+v", typ, m)
+		}
+	}
+}
+
+func TestDecodeBinaryRejects(t *testing.T) {
+	bad := [][]byte{
+		nil,
+		{0x00},
+		{0x7f, 1, 2},
+		{OpMouseMove, 1, 0, 1},      // corto
+		{OpMouseDown, 9},            // botón desconocido
+		{OpKeyDownVK, 1, 0, 0, 0},   // corto
+		{OpMouseScroll, 1, 0, 0, 0}, // largo
+	}
+	for _, b := range bad {
+		if _, _, err := DecodeBinary(b); !errors.Is(err, ErrBinaryFrame) {
+			t.Errorf("DecodeBinary(% x) = %v, want ErrBinaryFrame", b, err)
+		}
+	}
+}
+
+func TestAppendBinaryOutOfRange(t *testing.T) {
+	if _, err := AppendBinary(nil, &MouseMove{Type: TypeMouseMove, Dx: 40000}); !errors.Is(err, ErrBinaryFrame) {
+		t.Errorf("dx fuera de int16: err = %v", err)
+	}
+}
+
+// Los benchmarks comparan lo que hace el daemon por cada mouse_move:
+// JSON = Base (para despachar) + MouseMove (en el handler); binario = un DecodeBinary.
+
+var moveJSON = []byte(`{"type":"mouse_move","dx":-12,"dy":7}`)
+
+func BenchmarkMouseMoveJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var base Base
+		if err := json.Unmarshal(moveJSON, &base); err != nil {
+			b.Fatal(err)
+		}
+		var m MouseMove
+		if err := json.Unmarshal(moveJSON, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMouseMoveBinary(b *testing.B) {
+	frame, err := AppendBinary(nil, &MouseMove{Type: TypeMouseMove, Dx: -12, Dy: 7})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeBinary(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var keyJSON = []byte(`{"type":"key_down_vk","key":{"vk":37,"scan":75,"ext":true}}`)
+
+func BenchmarkKeyDownVKJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var base Base
+		if err := json.Unmarshal(keyJSON, &base); err != nil {
+			b.Fatal(err)
+		}
+		var m KeyVK
+		if err := json.Unmarshal(keyJSON, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKeyDownVKBinary(b *testing.B) {
+	frame, err := AppendBinary(nil, &KeyVK{Type: TypeKeyDownVK, Key: input.KeySpec{VK: 37, Scan: 75, Ext: true}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeBinary(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,7 +1,529 @@
+// Package protocol define todos los mensajes que viajan por el WebSocket
+// entre el teléfono (o scripts) y el daemon. Es la única fuente de verdad:
+// el paquete ws sólo (de)serializa estos tipos.
 package protocol
 
-type MouseMoveMessage struct {
+import (
+	"encoding/json"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+// Version es la versión del protocolo que habla este daemon.
+// Se incrementa cuando se agregan/cambian mensajes de forma incompatible.
+const Version = 1
+
+// MinVersion es la versión más antigua de cliente que el daemon acepta en hello.
+const MinVersion = 1
+
+// DaemonVersion identifica el build del daemon (se puede pisar con -ldflags "-X").
+var DaemonVersion = "dev"
+
+// ---- Message types ----
+
+const (
+	TypeHello     = "hello"
+	TypeHelloOk   = "hello_ok"
+	TypePing      = "ping"
+	TypePong      = "pong"
+	TypeError     = "error"
+	TypeAck       = "ack"
+	TypeAuthOk    = "auth_ok"
+	TypeAuthLogin = "auth_login"
+	TypeStatus    = "status"
+
+	// reconexión: auth_resume con el resume_token del auth_ok recupera la
+	// sesión anterior; auth_logout cierra la sesión (y anula el token)
+	TypeAuthResume = "auth_resume"
+	TypeAuthLogout = "auth_logout"
+
+	// emparejamiento: el teléfono cambia el token de pairing por su credencial propia
+	TypePairRequest = "pair_request"
+	TypePairOk      = "pair_ok"
+
+	TypeMouseMove   = "mouse_move"
+	TypeMouseClick  = "mouse_click"
+	TypeMouseDown   = "mouse_down"
+	TypeMouseUp     = "mouse_up"
+	TypeMouseScroll = "mouse_scroll"
+
+	TypeKeyText   = "key_text"
+	TypeKey       = "key"
+	TypeKeyDown   = "key_down"
+	TypeKeyUp     = "key_up"
+	TypeHotkey    = "hotkey"
+	TypeKeyVK     = "key_vk"
+	TypeKeyDownVK = "key_down_vk"
+	TypeKeyUpVK   = "key_up_vk"
+	TypeHotkeyVK  = "hotkey_vk"
+
+	TypeTextInput    = "text_input"
+	TypeInputKeyTap  = "input_key_tap"
+	TypeInputKeyDown = "input_key_down"
+	TypeInputKeyUp   = "input_key_up"
+
+	// release_all suelta botones/teclas que hayan quedado apretados
+	TypeReleaseAll = "release_all"
+
+	TypeBatch     = "batch"
+	TypeBatchDone = "batch_done"
+
+	TypeMacroList       = "macro_list"
+	TypeMacroListResult = "macro_list_result"
+	TypeMacroSave       = "macro_save"
+	TypeMacroDelete     = "macro_delete"
+	TypeMacroRun        = "macro_run"
+
+	TypeMacroRecordStart = "macro_record_start"
+	TypeMacroRecordStop  = "macro_record_stop"
+	TypeMacroDraft       = "macro_draft"
+
+	TypeCaptureStart  = "capture_start"
+	TypeCaptureKey    = "capture_key"
+	TypeCaptureCancel = "capture_cancel"
+
+	// catálogo de teclas con nombre (para armar teclados en el cliente)
+	TypeKeysList       = "keys_list"
+	TypeKeysListResult = "keys_list_result"
+
+	TypeAppsList       = "apps_list"
+	TypeAppsListResult = "apps_list_result"
+	TypeAppAction      = "app_action"
+)
+
+// ---- Error codes ----
+
+// Códigos de error estables. El cliente debe decidir por Code, nunca por el texto.
+const (
+	CodeAuthRequired    = "AUTH_REQUIRED"
+	CodeAuthInvalid     = "AUTH_INVALID"
+	CodeUserDisabled    = "USER_DISABLED"
+	CodeNoUsers         = "NO_USERS"
+	CodeCaptureBusy     = "CAPTURE_BUSY"
+	CodeCaptureTimeout  = "CAPTURE_TIMEOUT"
+	CodeCaptureCanceled = "CAPTURE_CANCELED"
+	CodeUnsupported     = "UNSUPPORTED"
+	CodeDriverError     = "DRIVER_ERROR"
+	CodeBadRequest      = "BAD_REQUEST"
+	CodeProtocolVersion = "PROTOCOL_VERSION"
+	CodeInternal        = "INTERNAL"
+	CodeQueueFull       = "QUEUE_FULL"
+	CodeBatchCanceled   = "BATCH_CANCELED"
+	CodeNotFound        = "NOT_FOUND"
+	CodePermission      = "PERMISSION_DENIED"
+	CodeAuthLocked      = "AUTH_LOCKED"
+	CodePairingRequired = "PAIRING_REQUIRED"
+	CodeApprovalPending = "APPROVAL_PENDING"
+	CodeResumeInvalid   = "RESUME_INVALID"
+)
+
+// ---- Incoming messages ----
+
+// Base es lo mínimo que trae cualquier mensaje; se usa para despachar por Type.
+//
+// Ack pide respuesta (ack o error) para este mensaje puntual aunque la sesión
+// no haya activado acks en hello. Sin ID nunca hay ack.
+type Base struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Ack  bool   `json:"ack,omitempty"`
+}
+
+// Hello es el primer mensaje que debería mandar un cliente.
+type Hello struct {
+	ID         string `json:"id,omitempty"`
+	Type       string `json:"type"`
+	Protocol   int    `json:"protocol"`
+	AppVersion string `json:"app_version,omitempty"`
+	Client     string `json:"client,omitempty"` // android|ios|script|...
+
+	// DeviceName es el nombre del teléfono ("Pixel de Ana"); se muestra en
+	// el PC cuando hay que aprobar la conexión.
+	DeviceName string `json:"device_name,omitempty"`
+
+	// Lang elige el idioma del texto de los errores: "es" (default) o "en".
+	Lang string `json:"lang,omitempty"`
+
+	// Acks: todo mensaje con id recibe ack o error (salvo mouse_move,
+	// que sigue siendo fire-and-forget salvo que traiga "ack": true).
+	Acks bool `json:"acks,omitempty"`
+
+	// Binary activa los frames binarios para mouse/teclas (ver binary.go).
+	Binary bool `json:"binary,omitempty"`
+}
+
+type AuthLogin struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// PairRequest: Name es como se va a ver el dispositivo en la UI ("Pixel de Ana").
+type PairRequest struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// PairOk trae la credencial del dispositivo. Se muestra una sola vez: el
+// cliente la guarda y la manda como token (X-DeskControl-Token o ?token=)
+// en las conexiones siguientes.
+type PairOk struct {
+	ID         string `json:"id,omitempty"`
+	Type       string `json:"type"`
+	DeviceID   string `json:"device_id"`
+	Name       string `json:"name"`
+	Credential string `json:"credential"`
+}
+
+type MouseMove struct {
+	ID   string `json:"id,omitempty"`
 	Type string `json:"type"`
 	Dx   int32  `json:"dx"`
 	Dy   int32  `json:"dy"`
 }
+
+// MouseButton sirve para mouse_click, mouse_down y mouse_up.
+type MouseButton struct {
+	ID     string `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Button string `json:"button"` // left (o ""), right o middle
+}
+
+type MouseScroll struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Dy   int32  `json:"dy"`
+}
+
+type KeyText struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Key sirve para key, key_down y key_up (nombres definidos por el daemon).
+type Key struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+type Hotkey struct {
+	ID   string   `json:"id,omitempty"`
+	Type string   `json:"type"`
+	Mods []string `json:"mods"`
+	Key  string   `json:"key"`
+}
+
+// KeyVK sirve para key_vk, key_down_vk y key_up_vk.
+type KeyVK struct {
+	ID   string        `json:"id,omitempty"`
+	Type string        `json:"type"`
+	Key  input.KeySpec `json:"key"`
+}
+
+type HotkeyVK struct {
+	ID   string        `json:"id,omitempty"`
+	Type string        `json:"type"`
+	Mods []string      `json:"mods"`
+	Key  input.KeySpec `json:"key"`
+}
+
+// Batch ejecuta Steps en orden del lado del daemon (sin jitter de red).
+// Cada paso es un mensaje de entrada normal (mouse_move, key_down_vk,
+// hotkey_vk, text_input, ...) y puede traer "delay_ms": espera antes de él.
+// Un batch nuevo cancela el que esté corriendo en la sesión; un batch sin
+// pasos sólo cancela. Hasta 1000 pasos, delay_ms de 60 s como mucho y 5 min
+// de esperas en total.
+type Batch struct {
+	ID    string            `json:"id,omitempty"`
+	Type  string            `json:"type"`
+	Steps []json.RawMessage `json:"steps"`
+}
+
+// BatchStep es lo que el daemon lee de cada paso además de su mensaje.
+type BatchStep struct {
+	Type    string `json:"type"`
+	DelayMs int    `json:"delay_ms,omitempty"`
+}
+
+// MacroSave guarda (o reemplaza por nombre) una macro. Steps tiene el mismo
+// formato que en Batch.
+type MacroSave struct {
+	ID    string            `json:"id,omitempty"`
+	Type  string            `json:"type"`
+	Name  string            `json:"name"`
+	Steps []json.RawMessage `json:"steps"`
+}
+
+// MacroRef sirve para macro_delete y macro_run.
+type MacroRef struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// MacroRecordStart empieza a grabar los mensajes de entrada de la sesión.
+// MergeMoves suma los mouse_move muy seguidos en un solo paso.
+type MacroRecordStart struct {
+	ID         string `json:"id,omitempty"`
+	Type       string `json:"type"`
+	MergeMoves bool   `json:"merge_moves,omitempty"`
+}
+
+// MacroRecordStop termina la grabación. Con Save la guarda como macro Name.
+type MacroRecordStop struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	Save bool   `json:"save,omitempty"`
+}
+
+// CaptureStart: TimeoutMs 0 = 10 s; el daemon lo recorta a 60 s.
+type CaptureStart struct {
+	ID        string `json:"id,omitempty"`
+	Type      string `json:"type"`
+	TimeoutMs int    `json:"timeout_ms,omitempty"`
+}
+
+// KeysList pide el catálogo de teclas; Groups filtra (vacío = todas).
+type KeysList struct {
+	ID     string   `json:"id,omitempty"`
+	Type   string   `json:"type"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// CaptureCancel corta el capture_start CaptureID de la sesión (vacío = el
+// que tenga el mismo ID que este mensaje). Ese capture_start termina con
+// error CAPTURE_CANCELED.
+type CaptureCancel struct {
+	ID        string `json:"id,omitempty"`
+	Type      string `json:"type"`
+	CaptureID string `json:"capture_id,omitempty"`
+}
+
+type AppsList struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+}
+
+type AppAction struct {
+	ID     string  `json:"id,omitempty"`
+	Type   string  `json:"type"`
+	Hwnd   uintptr `json:"hwnd"`
+	Action string  `json:"action"` // minimize|restore|activate|maximize|close
+}
+
+// InputKeyFlat sirve para input_key_tap/down/up. Acepta el KeySpec en la raíz
+// o dentro de "payload" (clientes viejos).
+type InputKeyFlat struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	VK   uint16 `json:"vk"`
+	Scan uint16 `json:"scan,omitempty"`
+	Ext  bool   `json:"ext,omitempty"`
+
+	Payload *struct {
+		VK   uint16 `json:"vk"`
+		Scan uint16 `json:"scan,omitempty"`
+		Ext  bool   `json:"ext,omitempty"`
+	} `json:"payload,omitempty"`
+}
+
+// KeySpec devuelve el KeySpec, sea de la raíz o del payload.
+func (m InputKeyFlat) KeySpec() input.KeySpec {
+	if m.VK != 0 || m.Scan != 0 {
+		return input.KeySpec{VK: m.VK, Scan: m.Scan, Ext: m.Ext}
+	}
+	if m.Payload != nil {
+		return input.KeySpec{VK: m.Payload.VK, Scan: m.Payload.Scan, Ext: m.Payload.Ext}
+	}
+	return input.KeySpec{}
+}
+
+// TextInput acepta "text" en la raíz o dentro de "payload".
+type TextInput struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Text string `json:"text"`
+
+	Payload *struct {
+		Text string `json:"text"`
+	} `json:"payload,omitempty"`
+}
+
+// Value devuelve el texto, sea de la raíz o del payload.
+func (m TextInput) Value() string {
+	if m.Text != "" {
+		return m.Text
+	}
+	if m.Payload != nil {
+		return m.Payload.Text
+	}
+	return ""
+}
+
+// ---- Outgoing ----
+
+// HelloOk responde a hello con lo que el daemon realmente soporta.
+type HelloOk struct {
+	ID            string   `json:"id,omitempty"`
+	Type          string   `json:"type"`
+	Protocol      int      `json:"protocol"`     // versión negociada
+	MinProtocol   int      `json:"min_protocol"` // mínima aceptada
+	DaemonVersion string   `json:"daemon_version"`
+	Session       string   `json:"session"`
+	Types         []string `json:"types"`  // mensajes que este daemon/driver atiende
+	Acks          bool     `json:"acks"`   // acks activados para la sesión
+	Binary        bool     `json:"binary"` // frames binarios aceptados
+}
+
+// Ack confirma que el mensaje ID (de tipo Of) se ejecutó sin error.
+type Ack struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Of   string `json:"of"`
+}
+
+// ErrorResponse: Code es estable (para que el cliente decida qué hacer);
+// Error es el texto para mostrar, en el idioma pedido en hello.
+type ErrorResponse struct {
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	Step  *int   `json:"step,omitempty"` // paso del batch que falló (desde 0)
+
+	// RetryAfterMs: cuánto esperar antes de reintentar (AUTH_LOCKED).
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+type AuthOk struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Username string `json:"username"`
+	Session  string `json:"session"`
+
+	// Permisos efectivos de la sesión ("media", "pointer", ..., "admin") para
+	// que el cliente oculte lo que no puede usar.
+	Permissions []string `json:"permissions,omitempty"`
+
+	// ResumeToken sirve una sola vez para auth_resume tras una desconexión;
+	// vence ResumeTTLMs milisegundos después de cortarse la conexión.
+	ResumeToken string `json:"resume_token,omitempty"`
+	ResumeTTLMs int    `json:"resume_ttl_ms,omitempty"`
+
+	// Sólo en respuesta a auth_resume: lo que se recuperó de la sesión anterior.
+	// Con CapturePending sigue la respuesta del capture_start que quedó
+	// colgado: capture_key, o el error si venció su timeout mientras tanto.
+	Resumed        bool `json:"resumed,omitempty"`
+	Held           int  `json:"held,omitempty"`
+	CapturePending bool `json:"capture_pending,omitempty"`
+}
+
+// AuthResume: Token es el resume_token del último auth_ok.
+type AuthResume struct {
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+type CaptureKey struct {
+	ID     string              `json:"id,omitempty"`
+	Type   string              `json:"type"`
+	Result input.CaptureResult `json:"result"`
+}
+
+type KeysListResult struct {
+	ID   string          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Keys []input.KeyInfo `json:"keys"`
+}
+
+type AppsListResult struct {
+	ID   string          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Apps []input.AppInfo `json:"apps"`
+}
+
+// BatchDone: el batch ID terminó; Steps es cuántos pasos se ejecutaron.
+type BatchDone struct {
+	ID        string `json:"id,omitempty"`
+	Type      string `json:"type"`
+	Steps     int    `json:"steps"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// MacroInfo es una macro tal como se lista al cliente.
+type MacroInfo struct {
+	Name      string            `json:"name"`
+	Steps     []json.RawMessage `json:"steps"`
+	UpdatedAt int64             `json:"updated_at"`
+}
+
+type MacroListResult struct {
+	ID     string      `json:"id,omitempty"`
+	Type   string      `json:"type"`
+	Macros []MacroInfo `json:"macros"`
+}
+
+// MacroDraft responde a macro_record_stop con lo grabado (listo para macro_save).
+// Si se pidió guardarla y no se pudo, llega igual con Saved false y el motivo
+// en SaveError: la grabación no se pierde.
+type MacroDraft struct {
+	ID         string            `json:"id,omitempty"`
+	Type       string            `json:"type"`
+	Name       string            `json:"name,omitempty"`
+	Steps      []json.RawMessage `json:"steps"`
+	DurationMs int64             `json:"duration_ms"`
+	Truncated  bool              `json:"truncated,omitempty"` // se llegó al máximo de pasos o de duración
+	Saved      bool              `json:"saved"`
+	SaveError  *ErrorResponse    `json:"save_error,omitempty"`
+}
+
+type Pong struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+}
+
+// Estados que el daemon anuncia con "status" (sin id: no es respuesta a nada).
+const (
+	// StatusReconnect: el daemon va a cambiar de listener (puerto/TLS);
+	// el cliente debe reconectar a Port/TLS en unos InMs milisegundos.
+	StatusReconnect = "reconnect"
+
+	// StatusPending: el PC tiene que aprobar la conexión; mientras tanto todo
+	// lo que no sea público vuelve con APPROVAL_PENDING.
+	StatusPending = "pending"
+	// StatusApproved: ya se puede usar la conexión.
+	StatusApproved = "approved"
+	// StatusDenied: el PC la rechazó (o venció el plazo); se cierra enseguida.
+	StatusDenied = "denied"
+)
+
+type Status struct {
+	Type   string `json:"type"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	TLS    bool   `json:"tls"`
+	InMs   int    `json:"in_ms,omitempty"`
+}
+
+// Negotiate elige la versión a usar dado lo que pide el cliente.
+// Devuelve false si el cliente es demasiado viejo.
+func Negotiate(client int) (int, bool) {
+	if client <= 0 {
+		// cliente que no manda versión: asumimos la mínima
+		return MinVersion, true
+	}
+	if client < MinVersion {
+		return 0, false
+	}
+	if client > Version {
+		return Version, true
+	}
+	return client, true
+}
@@ -0,0 +1,360 @@
+package ws
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Aprobación desde el PC (SecurityConfig.RequireApproval): una conexión de un
+// dispositivo o IP que no está en approved_clients queda pendiente. Con el
+// hello (o con el primer mensaje que no sea ping/hello/auth_resume) se le
+// pregunta a la UI, que contesta con ResolveApproval; si nadie contesta en el
+// plazo, se deniega.
+
+// DefaultApprovalTimeout: cuánto se espera la respuesta del PC.
+const DefaultApprovalTimeout = 30 * time.Second
+
+// ApprovalDecision es la respuesta a una ApprovalRequest.
+type ApprovalDecision int
+
+const (
+	ApproveOnce   ApprovalDecision = iota + 1 // sólo esta conexión
+	ApproveAlways                             // y se recuerda el dispositivo/IP
+	Deny
+)
+
+func (d ApprovalDecision) String() string {
+	switch d {
+	case ApproveOnce:
+		return "una vez"
+	case ApproveAlways:
+		return "siempre"
+	case Deny:
+		return "denegada"
+	}
+	return fmt.Sprintf("ApprovalDecision(%d)", int(d))
+}
+
+// ApprovalRequest es lo que se le muestra al usuario del PC.
+type ApprovalRequest struct {
+	SessionID  string
+	RemoteAddr string
+	DeviceID   string // "" si no usó credencial de dispositivo
+	DeviceName string // del hello (device_name) o del emparejamiento
+	Client     string
+	Deadline   time.Time
+}
+
+// SetApprovalPrompt define quién pregunta al usuario. fn corre en su propia
+// goroutine y contesta más tarde con ResolveApproval. Sin prompt, las
+// conexiones que requieren aprobación se deniegan.
+func (s *Server) SetApprovalPrompt(fn func(ApprovalRequest)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approvalPrompt = fn
+}
+
+func (s *Server) approvalPromptFn() func(ApprovalRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.approvalPrompt
+}
+
+// approvalKey: cómo se recuerda "permitir siempre" para se.
+func approvalKey(deviceID, remote string) string {
+	if deviceID != "" {
+		return "device:" + deviceID
+	}
+	return "ip:" + remoteHost(remote)
+}
+
+// needsApproval decide en el upgrade si la conexión queda pendiente. Las que
+// entran con un código de emparejamiento no: el código se mostró en el PC.
+func needsApproval(sec SecurityConfig, kind string, device *Device, remote string) bool {
+	if !sec.RequireApproval || kind == tokenPairing {
+		return false
+	}
+	deviceID := ""
+	if device != nil {
+		deviceID = device.ID
+	}
+	ok, err := isApprovedClient(approvalKey(deviceID, remote))
+	if err != nil {
+		log.Printf("[ws] approval lookup error remote=%s: %v", remote, err)
+		return true
+	}
+	return !ok
+}
+
+func (se *Session) setApprovalPending() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.approvalPending = true
+}
+
+// ApprovalPending indica si la sesión todavía espera la aprobación del PC.
+func (se *Session) ApprovalPending() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.approvalPending
+}
+
+// requestApproval le pregunta a la UI (una sola vez por sesión) y arranca el
+// plazo. No hace nada si la sesión no está pendiente.
+func requestApproval(se *Session) {
+	timeout := DefaultApprovalTimeout
+	if se.srv != nil {
+		if t := se.srv.security().ApprovalTimeout; t > 0 {
+			timeout = t
+		}
+	}
+
+	sessionsMu.Lock()
+	if !se.approvalPending || se.approvalTimer != nil {
+		sessionsMu.Unlock()
+		return
+	}
+	req := ApprovalRequest{
+		SessionID:  se.id,
+		RemoteAddr: se.remoteAddr,
+		DeviceID:   se.deviceID,
+		DeviceName: se.helloName,
+		Client:     se.client,
+		Deadline:   time.Now().Add(timeout),
+	}
+	if req.DeviceName == "" {
+		req.DeviceName = se.deviceName
+	}
+	se.approvalDeadline = req.Deadline
+	se.approvalTimer = time.AfterFunc(timeout, func() {
+		_ = resolveApproval(req.SessionID, Deny, "sin respuesta en "+timeout.String())
+	})
+	sessionsMu.Unlock()
+
+	var prompt func(ApprovalRequest)
+	if se.srv != nil {
+		prompt = se.srv.approvalPromptFn()
+	}
+	if prompt == nil {
+		log.Printf("[ws] approval needed session=%s remote=%s but nobody can approve: denying", req.SessionID, req.RemoteAddr)
+		_ = resolveApproval(req.SessionID, Deny, "no hay UI para aprobar")
+		return
+	}
+	log.Printf("[ws] approval requested session=%s remote=%s device=%q client=%q timeout=%s",
+		req.SessionID, req.RemoteAddr, req.DeviceName, req.Client, timeout)
+	go prompt(req)
+}
+
+// ResolveApproval contesta la ApprovalRequest de la sesión sessionID.
+func ResolveApproval(sessionID string, d ApprovalDecision) error {
+	return resolveApproval(sessionID, d, "desde el PC")
+}
+
+func resolveApproval(sessionID string, d ApprovalDecision, why string) error {
+	sessionsMu.Lock()
+	se, ok := sessions[sessionID]
+	if !ok || !se.approvalPending {
+		sessionsMu.Unlock()
+		return fmt.Errorf("la conexión ya no está esperando aprobación")
+	}
+	se.approvalPending = false
+	if se.approvalTimer != nil {
+		se.approvalTimer.Stop()
+	}
+	info := se.info()
+	name := se.helloName
+	if name == "" {
+		name = se.deviceName
+	}
+	sessionsMu.Unlock()
+
+	log.Printf("[ws] approval %s session=%s remote=%s (%s)", d, info.ID, info.RemoteAddr, why)
+
+	if d == Deny {
+		audit.Recordf(audit.ApprovalDenied, info.ID, info.Username, info.RemoteAddr, "device=%q %s", name, why)
+		se.setDropReason("not approved")
+		invalidateResume(se.id)
+		if se.conn != nil && se.conn.c != nil {
+			_ = se.conn.WriteJSON(protocol.Status{Type: protocol.TypeStatus, State: protocol.StatusDenied, Reason: why})
+			se.conn.close(websocket.ClosePolicyViolation, "not approved")
+		}
+		return nil
+	}
+
+	if d == ApproveAlways {
+		if err := rememberApprovedClient(approvalKey(info.DeviceID, info.RemoteAddr), name, remoteHost(info.RemoteAddr)); err != nil {
+			log.Printf("[ws] approval save error session=%s: %v", info.ID, err)
+		}
+	}
+	audit.Recordf(audit.ApprovalGranted, info.ID, info.Username, info.RemoteAddr, "device=%q %s", name, d)
+	if se.conn != nil && se.conn.c != nil {
+		_ = se.conn.WriteJSON(protocol.Status{Type: protocol.TypeStatus, State: protocol.StatusApproved})
+	}
+	return nil
+}
+
+// PendingApprovals devuelve las preguntas hechas que siguen sin respuesta.
+func PendingApprovals() []ApprovalRequest {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var out []ApprovalRequest
+	for _, se := range sessions {
+		if !se.approvalPending || se.approvalTimer == nil {
+			continue
+		}
+		name := se.helloName
+		if name == "" {
+			name = se.deviceName
+		}
+		out = append(out, ApprovalRequest{
+			SessionID:  se.id,
+			RemoteAddr: se.remoteAddr,
+			DeviceID:   se.deviceID,
+			DeviceName: name,
+			Client:     se.client,
+			Deadline:   se.approvalDeadline,
+		})
+	}
+	return out
+}
+
+// approveServerSessions aprueba (una vez) lo pendiente de srv; se usa cuando
+// se apaga RequireApproval.
+func approveServerSessions(srv *Server) {
+	sessionsMu.Lock()
+	var ids []string
+	for id, se := range sessions {
+		if se.srv == srv && se.approvalPending {
+			ids = append(ids, id)
+		}
+	}
+	sessionsMu.Unlock()
+	for _, id := range ids {
+		_ = resolveApproval(id, ApproveOnce, "aprobación desactivada")
+	}
+}
+
+// ---- approved_clients ----
+
+// ApprovedClient es un dispositivo o IP con "permitir siempre".
+type ApprovedClient struct {
+	Key       string // "device:<id>" o "ip:192.168.1.20"
+	Kind      string // "device" / "ip"
+	Value     string
+	Name      string
+	LastIP    string
+	CreatedAt int64
+}
+
+func openApprovalsDB() (*sql.DB, error) {
+	db, err := openUsersDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureApprovalsSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureApprovalsSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS approved_clients (
+  key TEXT PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  last_ip TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL
+);
+`)
+	return err
+}
+
+func isApprovedClient(key string) (bool, error) {
+	db, err := openApprovalsDB()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM approved_clients WHERE key=?;`, key).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func rememberApprovedClient(key, name, ip string) error {
+	db, err := openApprovalsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+INSERT INTO approved_clients(key, name, last_ip, created_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET
+  name=excluded.name,
+  last_ip=excluded.last_ip;
+`, key, name, ip, time.Now().Unix())
+	return err
+}
+
+// ListApprovedClients devuelve los dispositivos/IPs que no piden aprobación.
+func ListApprovedClients() ([]ApprovedClient, error) {
+	db, err := openApprovalsDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+SELECT key, name, last_ip, created_at
+FROM approved_clients
+ORDER BY created_at DESC;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ApprovedClient{}
+	for rows.Next() {
+		var a ApprovedClient
+		if err := rows.Scan(&a.Key, &a.Name, &a.LastIP, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Kind, a.Value, _ = strings.Cut(a.Key, ":")
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ForgetApprovedClient hace que key vuelva a pedir aprobación.
+func ForgetApprovedClient(key string) error {
+	db, err := openApprovalsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`DELETE FROM approved_clients WHERE key=?;`, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("aprobación no encontrada: %s", key)
+	}
+	log.Printf("[ws] approval forgotten key=%s", key)
+	audit.Recordf(audit.DeviceChanged, "", "", audit.LocalAddr, "key=%s ya no está aprobado", key)
+	return nil
+}
@@ -0,0 +1,170 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// startApprovalServer levanta un server con RequireApproval cuyas preguntas
+// llegan por el canal devuelto.
+func startApprovalServer(t *testing.T, timeout time.Duration) (*Server, *input.Fake, <-chan ApprovalRequest) {
+	t.Helper()
+	srv, fake := startServer(t, SecurityConfig{RequireApproval: true, ApprovalTimeout: timeout})
+	asked := make(chan ApprovalRequest, 4)
+	srv.SetApprovalPrompt(func(req ApprovalRequest) { asked <- req })
+	t.Cleanup(func() { _ = ForgetApprovedClient("ip:127.0.0.1") })
+	return srv, fake, asked
+}
+
+// connectPending conecta y espera el status pending.
+func connectPending(t *testing.T, srv *Server) *websocket.Conn {
+	t.Helper()
+	conn := mustConnect(t, srv, "")
+	if st := waitStatus(t, conn); st["state"] != protocol.StatusPending {
+		t.Fatalf("status = %v, want pending", st)
+	}
+	return conn
+}
+
+func hello(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	resp := roundTrip(t, conn, map[string]any{"id": "h", "type": protocol.TypeHello, "protocol": protocol.Version, "device_name": "Pixel"})
+	if resp["type"] != protocol.TypeHelloOk {
+		t.Fatalf("hello -> %v", resp)
+	}
+}
+
+func waitAsked(t *testing.T, asked <-chan ApprovalRequest) ApprovalRequest {
+	t.Helper()
+	select {
+	case req := <-asked:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("no se preguntó en el PC")
+	}
+	return ApprovalRequest{}
+}
+
+func TestApprovalGatesInput(t *testing.T) {
+	srv, fake, asked := startApprovalServer(t, time.Minute)
+	conn := connectPending(t, srv)
+
+	// ping y hello pasan; lo demás espera
+	if resp := roundTrip(t, conn, map[string]any{"id": "p", "type": protocol.TypePing}); resp["type"] != protocol.TypePong {
+		t.Errorf("ping pendiente -> %v", resp)
+	}
+	resp := roundTrip(t, conn, map[string]any{"id": "m1", "type": protocol.TypeMouseMove, "dx": 1, "ack": true})
+	if resp["code"] != protocol.CodeApprovalPending {
+		t.Errorf("mouse_move pendiente -> %v", resp)
+	}
+	req := waitAsked(t, asked)
+	if pend := PendingApprovals(); len(pend) != 1 || pend[0].SessionID != req.SessionID {
+		t.Errorf("PendingApprovals = %+v", pend)
+	}
+	hello(t, conn) // ya se preguntó: no se vuelve a preguntar
+	select {
+	case again := <-asked:
+		t.Errorf("se preguntó dos veces: %+v", again)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := ResolveApproval(req.SessionID, ApproveOnce); err != nil {
+		t.Fatal(err)
+	}
+	if st := waitStatus(t, conn); st["state"] != protocol.StatusApproved {
+		t.Errorf("status = %v, want approved", st)
+	}
+	if err := ResolveApproval(req.SessionID, Deny); err == nil {
+		t.Error("se pudo resolver dos veces")
+	}
+	if resp := roundTrip(t, conn, map[string]any{"id": "m2", "type": protocol.TypeMouseMove, "dx": 1, "ack": true}); resp["type"] != protocol.TypeAck {
+		t.Errorf("mouse_move aprobado -> %v", resp)
+	}
+	if got := calls(fake); !reflect.DeepEqual(got, []string{"MoveMouse[1 0]"}) {
+		t.Errorf("calls = %q (lo pendiente llegó al driver)", got)
+	}
+
+	// "una vez" no se recuerda
+	connectPending(t, srv)
+}
+
+// Ser público no alcanza: login y emparejamiento también esperan al PC.
+func TestApprovalGatesPublic(t *testing.T) {
+	srv, _, asked := startApprovalServer(t, time.Minute)
+	conn := connectPending(t, srv)
+	for _, m := range []map[string]any{
+		{"id": "l", "type": protocol.TypeAuthLogin, "username": "ana", "password": "x"},
+		{"id": "p", "type": protocol.TypePairRequest, "name": "Pixel"},
+	} {
+		if resp := roundTrip(t, conn, m); resp["code"] != protocol.CodeApprovalPending {
+			t.Errorf("%s pendiente -> %v", m["type"], resp)
+		}
+	}
+	waitAsked(t, asked)
+}
+
+func TestApprovalAlways(t *testing.T) {
+	srv, _, asked := startApprovalServer(t, time.Minute)
+	conn := connectPending(t, srv)
+	hello(t, conn)
+	req := waitAsked(t, asked)
+	if req.DeviceName != "Pixel" {
+		t.Errorf("DeviceName = %q", req.DeviceName)
+	}
+	if err := ResolveApproval(req.SessionID, ApproveAlways); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, conn)
+
+	// la IP quedó aprobada: la conexión siguiente no espera
+	next := mustConnect(t, srv, "")
+	if resp := roundTrip(t, next, map[string]any{"id": "m", "type": protocol.TypeMouseMove, "dx": 1, "ack": true}); resp["type"] != protocol.TypeAck {
+		t.Errorf("mouse_move de una IP aprobada -> %v", resp)
+	}
+	if err := ForgetApprovedClient("ip:127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	connectPending(t, srv)
+}
+
+func TestApprovalDenied(t *testing.T) {
+	expectDenied := func(t *testing.T, conn *websocket.Conn) {
+		t.Helper()
+		if st := waitStatus(t, conn); st["state"] != protocol.StatusDenied {
+			t.Fatalf("status = %v, want denied", st)
+		}
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Error("la conexión denegada sigue abierta")
+		}
+	}
+
+	t.Run("deny", func(t *testing.T) {
+		srv, _, asked := startApprovalServer(t, time.Minute)
+		conn := connectPending(t, srv)
+		hello(t, conn)
+		if err := ResolveApproval(waitAsked(t, asked).SessionID, Deny); err != nil {
+			t.Fatal(err)
+		}
+		expectDenied(t, conn)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		srv, _, asked := startApprovalServer(t, 50*time.Millisecond)
+		conn := connectPending(t, srv)
+		hello(t, conn)
+		waitAsked(t, asked)
+		expectDenied(t, conn)
+	})
+	t.Run("sin UI", func(t *testing.T) {
+		srv, _, _ := startApprovalServer(t, time.Minute)
+		srv.SetApprovalPrompt(nil)
+		conn := connectPending(t, srv)
+		send(t, conn, map[string]any{"id": "h", "type": protocol.TypeHello, "protocol": protocol.Version})
+		expectDenied(t, conn)
+	})
+}
@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Límites de un batch (para que un cliente no deje al daemon tecleando media
+// hora): pasos, espera de cada paso y, sólo para los mensajes batch, la suma
+// de las esperas. Las macros guardadas no tienen tope total: las hay de antes
+// del límite. Las grabaciones respetan los tres (ver record.go).
+const (
+	maxBatchSteps    = 1000
+	maxStepDelay     = 60 * time.Second
+	maxBatchDuration = 5 * time.Minute
+)
+
+type batchStep struct {
+	typ   string
+	delay time.Duration
+	raw   []byte
+	opts  HandlerOptions
+}
+
+// batchRun es el batch que está corriendo en una sesión.
+type batchRun struct {
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// batchAllowed: un paso tiene que ser un mensaje de entrada (mouse/teclado).
+func batchAllowed(e handlerEntry) bool {
+	return e.opts.Feature == input.FeatureMouse || e.opts.Feature == input.FeatureKeyboard
+}
+
+// parseBatch valida todos los pasos antes de ejecutar ninguno.
+func parseBatch(r *Registry, driver input.InputDriver, raws []json.RawMessage) ([]batchStep, error) {
+	if len(raws) > maxBatchSteps {
+		return nil, Errorf(protocol.CodeBadRequest, "demasiados pasos (%d, máximo %d)", len(raws), maxBatchSteps)
+	}
+	steps := make([]batchStep, 0, len(raws))
+	for i, raw := range raws {
+		var st protocol.BatchStep
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return nil, &stepError{step: i, err: err}
+		}
+		e, _, ok := r.lookup(st.Type)
+		if !ok || !batchAllowed(e) {
+			return nil, &stepError{step: i, err: &Error{Code: protocol.CodeUnsupported, Detail: st.Type}}
+		}
+		if !input.Supports(driver, e.opts.Feature) {
+			return nil, &stepError{step: i, err: &Error{Code: protocol.CodeUnsupported, Detail: st.Type}}
+		}
+		delay := time.Duration(st.DelayMs) * time.Millisecond
+		if st.DelayMs < 0 || delay > maxStepDelay {
+			return nil, &stepError{step: i, err: Errorf(protocol.CodeBadRequest, "delay_ms %d fuera de rango", st.DelayMs)}
+		}
+		steps = append(steps, batchStep{typ: st.Type, delay: delay, raw: raw, opts: e.opts})
+	}
+	return steps, nil
+}
+
+// checkBatchDuration rechaza un batch cuyas esperas suman más de maxBatchDuration.
+func checkBatchDuration(steps []batchStep) error {
+	var total time.Duration
+	for i, st := range steps {
+		if total += st.delay; total > maxBatchDuration {
+			return &stepError{step: i, err: Errorf(protocol.CodeBadRequest, "el batch dura más de %s", maxBatchDuration)}
+		}
+	}
+	return nil
+}
+
+func handleBatch(c *Context) error {
+	var m protocol.Batch
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	if len(m.Steps) == 0 {
+		// batch vacío = sólo cancelar el que esté corriendo
+		c.Session.cancelBatch()
+		return nil
+	}
+	steps, err := parseBatch(c.Registry(), c.Driver, m.Steps)
+	if err != nil {
+		return err
+	}
+	if err := checkBatchDuration(steps); err != nil {
+		return err
+	}
+	return startSteps(c, m.ID, steps)
+}
+
+// startSteps ejecuta steps (ya validados con parseBatch) en segundo plano como
+// el batch de la sesión (cancelando el anterior). La respuesta la manda runBatch.
+func startSteps(c *Context, id string, steps []batchStep) error {
+	// permisos antes de ejecutar nada: no queremos medio batch hecho
+	for i, st := range steps {
+		sc := stepContext(c, id, i, st)
+		sc.opts = st.opts
+		if err := checkSessionPermission(sc); err != nil {
+			log.Printf("[ws] denied type=%s id=%s session=%s user=%q: %v",
+				sc.Type, sc.ID, c.Session.ID(), c.Session.Username(), err)
+			return &stepError{step: i, err: err}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &batchRun{id: id, cancel: cancel, done: make(chan struct{})}
+	prevDone := c.Session.startBatch(run)
+
+	log.Printf("[input] batch start id=%s type=%s steps=%d", id, c.Type, len(steps))
+	c.MarkReplied()
+	go runBatch(ctx, c, run, steps, prevDone)
+	return nil
+}
+
+// runBatch ejecuta los pasos en orden y manda un único batch_done o error.
+// Si había un batch anterior, espera a que termine su paso en curso.
+func runBatch(ctx context.Context, parent *Context, run *batchRun, steps []batchStep, prevDone <-chan struct{}) {
+	start := time.Now()
+	conn, se := parent.Conn, parent.Session
+	reply := func(v any) {
+		if err := conn.WriteJSON(v); err != nil {
+			log.Printf("[input] batch id=%s reply failed: %v", run.id, err)
+		}
+	}
+	fail := func(step int, err error) {
+		reply(errorResponse(se.Lang(), run.id, &stepError{step: step, err: err}))
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[input] batch PANIC id=%s: %v\n%s", run.id, rec, string(debug.Stack()))
+			reply(parent.ErrorFor(&Error{Code: protocol.CodeInternal, Detail: "panic in batch"}))
+		}
+		run.cancel()
+		se.finishBatch(run)
+		close(run.done)
+	}()
+
+	if prevDone != nil {
+		<-prevDone
+	}
+
+	for i, st := range steps {
+		if st.delay > 0 {
+			t := time.NewTimer(st.delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+			case <-t.C:
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("[input] batch canceled id=%s at step=%d", run.id, i)
+			fail(i, &Error{Code: protocol.CodeBatchCanceled})
+			return
+		}
+
+		sc := stepContext(parent, run.id, i, st)
+		if _, _, err := parent.registry.exec(sc); err != nil {
+			log.Printf("[input] batch id=%s step=%d type=%s error: %v", run.id, i, st.typ, err)
+			fail(i, err)
+			return
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("[input] batch done id=%s steps=%d elapsed=%s", run.id, len(steps), elapsed)
+	reply(protocol.BatchDone{ID: run.id, Type: protocol.TypeBatchDone, Steps: len(steps), ElapsedMs: elapsed.Milliseconds()})
+}
+
+// stepContext arma el Context del paso i (ID "id#i") a partir del mensaje padre.
+func stepContext(parent *Context, id string, i int, st batchStep) *Context {
+	return &Context{
+		ID:       fmt.Sprintf("%s#%d", id, i),
+		Type:     st.typ,
+		Raw:      st.raw,
+		Session:  parent.Session,
+		Conn:     parent.Conn,
+		Driver:   parent.Driver,
+		base:     protocol.Base{Type: st.typ},
+		sec:      parent.sec,
+		registry: parent.registry,
+	}
+}
+
+// ---- estado por sesión ----
+
+// startBatch registra run como el batch de la sesión, cancelando el anterior.
+// Devuelve el done del anterior (nil si no había).
+func (se *Session) startBatch(run *batchRun) <-chan struct{} {
+	sessionsMu.Lock()
+	prev := se.batch
+	se.batch = run
+	sessionsMu.Unlock()
+	if prev == nil {
+		return nil
+	}
+	log.Printf("[input] batch id=%s replaced by id=%s", prev.id, run.id)
+	prev.cancel()
+	return prev.done
+}
+
+// cancelBatch cancela el batch en curso (si hay) y devuelve su canal done.
+func (se *Session) cancelBatch() <-chan struct{} {
+	sessionsMu.Lock()
+	run := se.batch
+	se.batch = nil
+	sessionsMu.Unlock()
+	if run == nil {
+		return nil
+	}
+	run.cancel()
+	return run.done
+}
+
+func (se *Session) finishBatch(run *batchRun) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if se.batch == run {
+		se.batch = nil
+	}
+}
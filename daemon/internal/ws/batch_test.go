@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+func batchSteps(n int, delay time.Duration) []json.RawMessage {
+	out := make([]json.RawMessage, n)
+	for i := range out {
+		out[i] = json.RawMessage(fmt.Sprintf(`{"type":%q,"dx":1,"delay_ms":%d}`, protocol.TypeMouseMove, delay.Milliseconds()))
+	}
+	return out
+}
+
+func TestParseBatchLimits(t *testing.T) {
+	r, fake := NewRegistry(), input.NewFake()
+
+	cases := map[string][]json.RawMessage{
+		"pasos":     batchSteps(maxBatchSteps+1, 0),
+		"delay":     batchSteps(1, maxStepDelay+time.Millisecond),
+		"delay < 0": batchSteps(1, -time.Millisecond),
+	}
+	for name, steps := range cases {
+		_, err := parseBatch(r, fake, steps)
+		if err == nil || toError(err).Code != protocol.CodeBadRequest {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+// El tope total es sólo de los mensajes batch: una macro guardada antes del
+// límite sigue pasando parseBatch.
+func TestBatchDuration(t *testing.T) {
+	r, fake := NewRegistry(), input.NewFake()
+	perStep := maxBatchDuration / 10
+
+	steps, err := parseBatch(r, fake, batchSteps(10, perStep))
+	if err != nil || checkBatchDuration(steps) != nil {
+		t.Errorf("batch de %s: %v / %v", maxBatchDuration, err, checkBatchDuration(steps))
+	}
+	steps, err = parseBatch(r, fake, batchSteps(11, perStep))
+	if err != nil {
+		t.Fatalf("parseBatch de una macro larga: %v", err)
+	}
+	if err := checkBatchDuration(steps); err == nil || toError(err).Code != protocol.CodeBadRequest {
+		t.Errorf("batch de %s: err = %v", 11*perStep, err)
+	}
+}
@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// DefaultCaptureTimeout: capture_start sin timeout_ms.
+const DefaultCaptureTimeout = 10 * time.Second
+
+// maxCaptureTimeout: tope de timeout_ms. Mientras tanto el hook queda puesto
+// y las capturas de las demás sesiones esperan.
+const maxCaptureTimeout = 60 * time.Second
+
+// captureQueueWait: cuánto espera turno una captura antes de rendirse con
+// CAPTURE_BUSY (variable para los tests).
+var captureQueueWait = maxCaptureTimeout
+
+// Reglas de capture_start (el hook de teclado es uno solo para todo el PC):
+//   - cada sesión tiene a lo sumo una captura; un capture_start nuevo de la
+//     misma sesión reemplaza al anterior (que termina con CAPTURE_CANCELED);
+//   - las de otras sesiones esperan su turno en orden de llegada, hasta
+//     captureQueueWait (después CAPTURE_BUSY); el timeout corre recién cuando
+//     les toca y nunca pasa de maxCaptureTimeout;
+//   - capture_cancel la corta (esperando o no); la desconexión también, salvo
+//     que la sesión quede estacionada para auth_resume: ahí sigue con su
+//     timeout y la respuesta espera al resume (si el resume vence o se anula,
+//     se corta).
+
+// captureRun es un capture_start en curso. La respuesta va a la conexión de la
+// sesión dueña en el momento en que llega: si la conexión se cortó y el
+// cliente hace auth_resume, se la manda a la conexión nueva.
+type captureRun struct {
+	id      string
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	turn    chan struct{} // se cierra cuando le toca usar el driver
+
+	mu      sync.Mutex
+	se      *Session
+	pending func(*Session) // respuesta que llegó sin conexión; se manda al reanudar
+}
+
+// cola global de capturas: [0] es la que está usando el driver
+var (
+	captureMu    sync.Mutex
+	captureQueue []*captureRun
+)
+
+func newCaptureRun(id string, se *Session, timeout time.Duration) *captureRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &captureRun{id: id, se: se, timeout: timeout, ctx: ctx, cancel: cancel, turn: make(chan struct{})}
+}
+
+// enqueue la pone al final de la cola; devuelve cuántas hay delante.
+func (r *captureRun) enqueue() int {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	captureQueue = append(captureQueue, r)
+	if len(captureQueue) == 1 {
+		close(r.turn)
+	}
+	return len(captureQueue) - 1
+}
+
+// dequeue la saca de la cola y, si era la activa, le pasa el turno a la siguiente.
+func (r *captureRun) dequeue() {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	for i, q := range captureQueue {
+		if q != r {
+			continue
+		}
+		captureQueue = append(captureQueue[:i], captureQueue[i+1:]...)
+		if i == 0 && len(captureQueue) > 0 {
+			close(captureQueue[0].turn)
+		}
+		return
+	}
+}
+
+// run espera su turno, captura y manda la respuesta.
+func (r *captureRun) run(driver input.InputDriver) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[capture] PANIC id=%s: %v\n%s", r.id, rec, string(debug.Stack()))
+			r.dequeue()
+			r.finish(input.CaptureResult{}, &Error{Code: protocol.CodeInternal, Detail: "panic in capture"})
+		}
+	}()
+	defer r.cancel()
+
+	wait := time.NewTimer(captureQueueWait)
+	select {
+	case <-r.turn:
+		wait.Stop()
+	case <-r.ctx.Done():
+		wait.Stop()
+		r.dequeue()
+		log.Printf("[capture] canceled while queued id=%s", r.id)
+		r.finish(input.CaptureResult{}, input.ErrCaptureCanceled)
+		return
+	case <-wait.C:
+		r.dequeue()
+		log.Printf("[capture] gave up waiting for its turn id=%s after %s", r.id, captureQueueWait)
+		r.finish(input.CaptureResult{}, input.ErrCaptureBusy)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	res, err := driver.CaptureNextKey(ctx)
+	cancel()
+	r.dequeue()
+	if err != nil {
+		log.Printf("[capture] error id=%s: %v", r.id, err)
+	}
+	r.finish(res, err)
+}
+
+// stop cancela la captura (esperando turno o con el hook puesto).
+func (r *captureRun) stop(why string) {
+	log.Printf("[capture] cancel id=%s: %s", r.id, why)
+	r.cancel()
+}
+
+func (se *Session) setCapture(r *captureRun) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.capture = r
+}
+
+// clearCapture suelta r si sigue siendo la captura de la sesión.
+func (se *Session) clearCapture(r *captureRun) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if se.capture == r {
+		se.capture = nil
+	}
+}
+
+func (se *Session) currentCapture() *captureRun {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.capture
+}
+
+// stopCapture cancela la captura de la sesión, si tiene. No la suelta: la
+// respuesta (CAPTURE_CANCELED) todavía tiene que llegar, quizás a la conexión
+// que reanude.
+func (se *Session) stopCapture(why string) {
+	if r := se.currentCapture(); r != nil {
+		r.stop(why)
+	}
+}
+
+// finish manda el resultado (o el error) de la captura.
+func (r *captureRun) finish(res input.CaptureResult, err error) {
+	r.mu.Lock()
+	se := r.se
+	send := func(se *Session) {
+		var werr error
+		if err != nil {
+			werr = se.conn.WriteJSON(errorResponse(se.Lang(), r.id, err))
+		} else {
+			werr = se.conn.WriteJSON(protocol.CaptureKey{ID: r.id, Type: protocol.TypeCaptureKey, Result: res})
+		}
+		if werr != nil {
+			log.Printf("[capture] writeJSON failed id=%s: %v", r.id, werr)
+		} else {
+			log.Printf("[capture] response sent id=%s session=%s", r.id, se.id)
+		}
+	}
+	if se.isGone() {
+		// se cortó: se guarda por si vuelve con auth_resume
+		r.pending = send
+		r.mu.Unlock()
+		log.Printf("[capture] result kept for resume id=%s session=%s", r.id, se.id)
+		return
+	}
+	r.mu.Unlock()
+
+	se.clearCapture(r)
+	send(se)
+}
+
+// moveTo pasa la captura a la sesión que reanudó y, si el resultado ya había
+// llegado, se lo manda.
+func (r *captureRun) moveTo(se *Session) {
+	r.mu.Lock()
+	r.se = se
+	send := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	if send != nil {
+		send(se)
+		return
+	}
+	se.setCapture(r)
+}
+
+func handleCaptureStart(c *Context) error {
+	var m protocol.CaptureStart
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	timeout := DefaultCaptureTimeout
+	if m.TimeoutMs > 0 {
+		// se compara en ms: un timeout_ms enorme desborda el Duration
+		timeout = maxCaptureTimeout
+		if ms := time.Duration(m.TimeoutMs); ms < maxCaptureTimeout/time.Millisecond {
+			timeout = ms * time.Millisecond
+		}
+	}
+
+	// la respuesta (capture_key o error) llega después, desde la goroutine, a
+	// la conexión que tenga la sesión en ese momento (ver captureRun)
+	c.MarkReplied()
+	run := newCaptureRun(m.ID, c.Session, timeout)
+
+	sessionsMu.Lock()
+	prev := c.Session.capture
+	c.Session.capture = run
+	sessionsMu.Unlock()
+	if prev != nil {
+		prev.stop("replaced by " + m.ID)
+	}
+
+	ahead := run.enqueue()
+	log.Printf("[capture] start id=%s session=%s timeout=%s queued_behind=%d", m.ID, c.Session.ID(), timeout, ahead)
+	go run.run(c.Driver)
+	return nil
+}
+
+func handleCaptureCancel(c *Context) error {
+	var m protocol.CaptureCancel
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	id := m.CaptureID
+	if id == "" {
+		id = m.ID
+	}
+	run := c.Session.currentCapture()
+	if run == nil || run.id != id {
+		return Errorf(protocol.CodeNotFound, "no hay captura %q en esta sesión", id)
+	}
+	run.stop("capture_cancel")
+	return nil
+}
@@ -0,0 +1,364 @@
+package ws
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Cada teléfono emparejado tiene su propia credencial "<device_id>.<secreto>".
+// En la DB sólo queda el sha256 del secreto: revocar un dispositivo no obliga
+// a cambiar el token compartido ni a volver a emparejar los demás.
+
+// tokenKind: con qué se autenticó la conexión en el upgrade.
+const (
+	tokenNone    = ""
+	tokenShared  = "shared"  // token compartido
+	tokenDevice  = "device"  // credencial propia de un dispositivo
+	tokenPairing = "pairing" // código de emparejamiento del QR (ver pairing.go)
+)
+
+// maxDeviceName: lo que se guarda del nombre que manda el teléfono.
+const maxDeviceName = 64
+
+// Device es una fila de la tabla devices. Las fechas son unix en segundos
+// (0 = nunca).
+type Device struct {
+	ID         string
+	Name       string
+	CreatedAt  int64
+	LastUsedAt int64
+	RevokedAt  int64
+}
+
+// Revoked indica si la credencial del dispositivo ya no sirve.
+func (d Device) Revoked() bool { return d.RevokedAt > 0 }
+
+func openDevicesDB() (*sql.DB, error) {
+	db, err := openUsersDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureDevicesSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureDevicesSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS devices (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  device_id TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  secret_hash TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  last_used_at INTEGER NOT NULL DEFAULT 0,
+  revoked_at INTEGER NOT NULL DEFAULT 0
+);
+`)
+	return err
+}
+
+func randomURLSafe(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashDeviceSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitDeviceCredential separa "<device_id>.<secreto>". El token compartido
+// es base64url (sin puntos), así que nunca se confunde con una credencial.
+func splitDeviceCredential(cred string) (id, secret string, ok bool) {
+	id, secret, ok = strings.Cut(cred, ".")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// createDevice registra un dispositivo nuevo y devuelve su credencial (que no
+// se guarda en ningún lado: si se pierde, se empareja de nuevo).
+func createDevice(name string) (Device, string, error) {
+	db, err := openDevicesDB()
+	if err != nil {
+		return Device{}, "", err
+	}
+	defer db.Close()
+
+	d := Device{ID: randomURLSafe(9), Name: name, CreatedAt: time.Now().Unix()}
+	secret := randomURLSafe(32)
+	_, err = db.Exec(`
+INSERT INTO devices(device_id, name, secret_hash, created_at)
+VALUES (?, ?, ?, ?);
+`, d.ID, d.Name, hashDeviceSecret(secret), d.CreatedAt)
+	if err != nil {
+		return Device{}, "", err
+	}
+	return d, d.ID + "." + secret, nil
+}
+
+// deviceForCredential valida cred contra la tabla y marca el último uso.
+func deviceForCredential(cred string) (*Device, error) {
+	id, secret, ok := splitDeviceCredential(cred)
+	if !ok {
+		return nil, fmt.Errorf("credencial de dispositivo mal formada")
+	}
+
+	db, err := openDevicesDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var d Device
+	var hash string
+	err = db.QueryRow(`
+SELECT device_id, name, secret_hash, created_at, last_used_at, revoked_at
+FROM devices
+WHERE device_id = ?
+LIMIT 1;
+`, id).Scan(&d.ID, &d.Name, &hash, &d.CreatedAt, &d.LastUsedAt, &d.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("dispositivo desconocido: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashDeviceSecret(secret)), []byte(hash)) != 1 {
+		return nil, fmt.Errorf("credencial inválida para el dispositivo %s", id)
+	}
+	if d.Revoked() {
+		return nil, fmt.Errorf("dispositivo %s revocado", id)
+	}
+
+	d.LastUsedAt = time.Now().Unix()
+	if _, err := db.Exec(`UPDATE devices SET last_used_at=? WHERE device_id=?;`, d.LastUsedAt, d.ID); err != nil {
+		log.Printf("[ws] device last_used update error device=%s: %v", d.ID, err)
+	}
+	return &d, nil
+}
+
+// ListDevices devuelve los dispositivos emparejados (los más nuevos primero).
+func ListDevices() ([]Device, error) {
+	db, err := openDevicesDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+SELECT device_id, name, created_at, last_used_at, revoked_at
+FROM devices
+ORDER BY created_at DESC, id DESC;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.Name, &d.CreatedAt, &d.LastUsedAt, &d.RevokedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RenameDevice cambia el nombre con que se ve el dispositivo.
+func RenameDevice(id, name string) error {
+	name = cleanDeviceName(name)
+	if name == "" {
+		return fmt.Errorf("nombre requerido")
+	}
+	if err := execDevice(`UPDATE devices SET name=? WHERE device_id=?;`, name, id); err != nil {
+		return err
+	}
+	sessionsMu.Lock()
+	for _, se := range sessions {
+		if se.deviceID == id {
+			se.deviceName = name
+		}
+	}
+	sessionsMu.Unlock()
+	audit.Recordf(audit.DeviceChanged, "", "", audit.LocalAddr, "device=%s renombrado a %q", id, name)
+	return nil
+}
+
+// RevokeDevice invalida la credencial de id y corta sus sesiones abiertas.
+// Devuelve cuántas sesiones se cortaron.
+func RevokeDevice(id string) (int, error) {
+	if err := execDevice(`UPDATE devices SET revoked_at=? WHERE device_id=? AND revoked_at=0;`, time.Now().Unix(), id); err != nil {
+		return 0, err
+	}
+	n := dropDeviceSessions(id)
+	log.Printf("[ws] device revoked device=%s sessions_dropped=%d", id, n)
+	audit.Recordf(audit.DeviceRevoked, "", "", audit.LocalAddr, "device=%s sesiones cortadas=%d", id, n)
+	return n, nil
+}
+
+// DeleteDevice borra id de la tabla (y corta sus sesiones, como RevokeDevice).
+func DeleteDevice(id string) (int, error) {
+	if err := execDevice(`DELETE FROM devices WHERE device_id=?;`, id); err != nil {
+		return 0, err
+	}
+	n := dropDeviceSessions(id)
+	if err := ForgetApprovedClient(approvalKey(id, "")); err != nil {
+		log.Printf("[ws] device deleted device=%s: %v", id, err)
+	}
+	log.Printf("[ws] device deleted device=%s sessions_dropped=%d", id, n)
+	audit.Recordf(audit.DeviceChanged, "", "", audit.LocalAddr, "device=%s borrado, sesiones cortadas=%d", id, n)
+	return n, nil
+}
+
+// execDevice corre q y falla si no tocó ninguna fila.
+func execDevice(q string, args ...any) error {
+	db, err := openDevicesDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("dispositivo no encontrado o ya revocado")
+	}
+	return nil
+}
+
+// dropDeviceSessions corta (DropSession) las sesiones abiertas con la
+// credencial de id.
+func dropDeviceSessions(id string) int {
+	sessionsMu.Lock()
+	var ids []string
+	for sid, se := range sessions {
+		if se.deviceID == id {
+			ids = append(ids, sid)
+		}
+	}
+	sessionsMu.Unlock()
+
+	n := 0
+	for _, sid := range ids {
+		if DropSession(sid) {
+			n++
+		}
+	}
+	return n
+}
+
+func cleanDeviceName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if r := []rune(name); len(r) > maxDeviceName {
+		name = string(r[:maxDeviceName])
+	}
+	return name
+}
+
+// ---- sesión ----
+
+func (se *Session) setDevice(kind string, d *Device) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.tokenKind = kind
+	if d != nil {
+		se.deviceID = d.ID
+		se.deviceName = d.Name
+	}
+}
+
+// DeviceID es el dispositivo con cuya credencial se conectó la sesión ("" si
+// no usó una).
+func (se *Session) DeviceID() string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.deviceID
+}
+
+func (se *Session) tokenAuth() string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.tokenKind
+}
+
+// pairingOnly: la conexión entró con un código de emparejamiento, o con el
+// token compartido cuando el server ya sólo lo acepta para emparejar.
+func (c *Context) pairingOnly() bool {
+	switch c.Session.tokenAuth() {
+	case tokenPairing:
+		return true
+	case tokenShared:
+		return c.sec.RequireTLS && c.sec.RequireToken && c.sec.RequireDeviceCredential
+	}
+	return false
+}
+
+// ---- pair_request ----
+
+// handlePairRequest cambia el código de emparejamiento o el token compartido
+// (ya validados en el upgrade) por una credencial propia del dispositivo.
+func handlePairRequest(c *Context) error {
+	var m protocol.PairRequest
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	if !c.sec.RequireTLS || !c.sec.RequireToken {
+		return Errorf(protocol.CodeBadRequest, "el emparejamiento requiere TLS y token activados")
+	}
+	switch c.Session.tokenAuth() {
+	case tokenShared, tokenPairing:
+	case tokenDevice:
+		return Errorf(protocol.CodeBadRequest, "la conexión ya usa la credencial del dispositivo %s", c.Session.DeviceID())
+	default:
+		return Errorf(protocol.CodeBadRequest, "conéctate con el código del QR (?pair=) para emparejar")
+	}
+
+	name := cleanDeviceName(m.Name)
+	if name == "" {
+		name = "Dispositivo " + remoteHost(c.Session.RemoteAddr())
+	}
+	d, cred, err := createDevice(name)
+	if err != nil {
+		log.Printf("[ws] pair_request error session=%s: %v", c.Session.ID(), err)
+		return &Error{Code: protocol.CodeInternal}
+	}
+	// el código se mostró en el PC: no hace falta volver a aprobar este dispositivo
+	if c.Session.tokenAuth() == tokenPairing {
+		if err := rememberApprovedClient(approvalKey(d.ID, ""), d.Name, remoteHost(c.Session.RemoteAddr())); err != nil {
+			log.Printf("[ws] approval save error device=%s: %v", d.ID, err)
+		}
+	}
+	c.Session.setDevice(tokenDevice, &d)
+
+	log.Printf("[ws] device paired device=%s name=%q remote=%s", d.ID, d.Name, c.Session.RemoteAddr())
+	audit.Recordf(audit.DevicePaired, c.Session.ID(), c.Session.Username(), c.Session.RemoteAddr(), "device=%s name=%q", d.ID, d.Name)
+
+	return c.Reply(protocol.PairOk{
+		ID:         c.ID,
+		Type:       protocol.TypePairOk,
+		DeviceID:   d.ID,
+		Name:       d.Name,
+		Credential: cred,
+	})
+}
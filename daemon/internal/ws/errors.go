@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Error es un error con código estable (protocol.Code*) que el paquete ws
+// sabe convertir en un mensaje "error" para el cliente.
+// Detail es opcional y se agrega al texto traducido.
+type Error struct {
+	Code   string
+	Detail string
+
+	// RetryAfter (opcional) se informa como retry_after_ms.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Detail
+}
+
+// Errorf crea un *Error con detalle formateado.
+func Errorf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Detail: fmt.Sprintf(format, args...)}
+}
+
+// defaultLang: los mensajes siempre fueron en español
+const defaultLang = "es"
+
+// errMessages: texto humano por idioma y código. Si falta una traducción se usa español.
+var errMessages = map[string]map[string]string{
+	"es": {
+		protocol.CodeAuthRequired:    "login requerido",
+		protocol.CodeAuthInvalid:     "usuario o contraseña inválidos",
+		protocol.CodeUserDisabled:    "usuario deshabilitado",
+		protocol.CodeNoUsers:         "no hay usuarios configurados (crea uno en la UI)",
+		protocol.CodeCaptureBusy:     "ya hay una captura activa",
+		protocol.CodeCaptureTimeout:  "se agotó el tiempo de captura",
+		protocol.CodeCaptureCanceled: "captura cancelada",
+		protocol.CodeUnsupported:     "tipo de mensaje no soportado",
+		protocol.CodeDriverError:     "error del driver de entrada",
+		protocol.CodeBadRequest:      "mensaje inválido",
+		protocol.CodeProtocolVersion: "versión de protocolo no soportada",
+		protocol.CodeInternal:        "error interno (revisa los logs del daemon)",
+		protocol.CodeQueueFull:       "el daemon está saturado, mensaje descartado",
+		protocol.CodeBatchCanceled:   "batch cancelado",
+		protocol.CodeNotFound:        "no encontrado",
+		protocol.CodePermission:      "el usuario no tiene permiso para esto",
+		protocol.CodeAuthLocked:      "demasiados intentos de login fallidos, espera antes de reintentar",
+		protocol.CodePairingRequired: "este dispositivo no está emparejado (manda pair_request)",
+		protocol.CodeApprovalPending: "esperando que se apruebe la conexión en el PC",
+		protocol.CodeResumeInvalid:   "no se puede recuperar la sesión (token vencido o ya usado), haz login de nuevo",
+	},
+	"en": {
+		protocol.CodeAuthRequired:    "login required",
+		protocol.CodeAuthInvalid:     "invalid username or password",
+		protocol.CodeUserDisabled:    "user disabled",
+		protocol.CodeNoUsers:         "no users configured (create one in the UI)",
+		protocol.CodeCaptureBusy:     "a capture is already active",
+		protocol.CodeCaptureTimeout:  "capture timed out",
+		protocol.CodeCaptureCanceled: "capture canceled",
+		protocol.CodeUnsupported:     "unsupported message type",
+		protocol.CodeDriverError:     "input driver error",
+		protocol.CodeBadRequest:      "invalid message",
+		protocol.CodeProtocolVersion: "unsupported protocol version",
+		protocol.CodeInternal:        "internal error (check daemon logs)",
+		protocol.CodeQueueFull:       "daemon is overloaded, message dropped",
+		protocol.CodeBatchCanceled:   "batch canceled",
+		protocol.CodeNotFound:        "not found",
+		protocol.CodePermission:      "user is not allowed to do this",
+		protocol.CodeAuthLocked:      "too many failed logins, wait before retrying",
+		protocol.CodePairingRequired: "this device is not paired (send pair_request)",
+		protocol.CodeApprovalPending: "waiting for the connection to be approved on the PC",
+		protocol.CodeResumeInvalid:   "cannot resume the session (token expired or already used), log in again",
+	},
+}
+
+// normLang reduce "en-US" -> "en" y cae a español si no lo conocemos.
+func normLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	if _, ok := errMessages[lang]; ok {
+		return lang
+	}
+	return defaultLang
+}
+
+func errText(lang, code, detail string) string {
+	msg, ok := errMessages[normLang(lang)][code]
+	if !ok {
+		msg, ok = errMessages[defaultLang][code]
+	}
+	if !ok {
+		msg = code
+	}
+	if detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+// stepError marca en qué paso de un batch ocurrió err.
+type stepError struct {
+	step int
+	err  error
+}
+
+func (e *stepError) Error() string { return fmt.Sprintf("paso %d: %v", e.step, e.err) }
+func (e *stepError) Unwrap() error { return e.err }
+
+// toError clasifica cualquier error (del driver, de json, etc.) en un *Error.
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var syn *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syn), errors.As(err, &typ):
+		return &Error{Code: protocol.CodeBadRequest, Detail: err.Error()}
+	case errors.Is(err, input.ErrUnknownKey), errors.Is(err, input.ErrInvalidButton),
+		errors.Is(err, protocol.ErrBinaryFrame):
+		return &Error{Code: protocol.CodeBadRequest, Detail: err.Error()}
+	case errors.Is(err, input.ErrCaptureBusy):
+		return &Error{Code: protocol.CodeCaptureBusy}
+	case errors.Is(err, input.ErrCaptureTimeout):
+		return &Error{Code: protocol.CodeCaptureTimeout}
+	case errors.Is(err, input.ErrCaptureCanceled):
+		return &Error{Code: protocol.CodeCaptureCanceled}
+	case errors.Is(err, input.ErrNotSupported):
+		return &Error{Code: protocol.CodeUnsupported, Detail: err.Error()}
+	}
+	return &Error{Code: protocol.CodeDriverError, Detail: err.Error()}
+}
+
+// errorResponse arma la respuesta "error" para err en el idioma lang.
+// Si err viene de un paso de batch, se informa el índice en Step.
+func errorResponse(lang, id string, err error) protocol.ErrorResponse {
+	var se *stepError
+	step := -1
+	if errors.As(err, &se) {
+		step, err = se.step, se.err
+	}
+
+	e := toError(err)
+	resp := protocol.ErrorResponse{
+		ID:    id,
+		Type:  protocol.TypeError,
+		Code:  e.Code,
+		Error: errText(lang, e.Code, e.Detail),
+	}
+	if e.RetryAfter > 0 {
+		resp.RetryAfterMs = e.RetryAfter.Milliseconds()
+	}
+	if step >= 0 {
+		resp.Step = &step
+		prefix := "paso %d: "
+		if normLang(lang) == "en" {
+			prefix = "step %d: "
+		}
+		resp.Error = fmt.Sprintf(prefix, step) + resp.Error
+	}
+	return resp
+}
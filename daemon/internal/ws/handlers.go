@@ -0,0 +1,407 @@
+package ws
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// registerBuiltins registra todos los mensajes que el daemon atiende de fábrica.
+func registerBuiltins(r *Registry) {
+	public := HandlerOptions{Public: true}
+	pending := HandlerOptions{Public: true, AllowPending: true}
+	mouse := HandlerOptions{Feature: input.FeatureMouse, Quiet: true}
+	keyboard := HandlerOptions{Feature: input.FeatureKeyboard, Quiet: true}
+
+	r.Register(protocol.TypePing, pending, handlePing)
+	r.Register(protocol.TypeHello, pending, handleHello)
+	r.Register(protocol.TypeAuthLogin, public, handleAuthLogin)
+	r.Register(protocol.TypeAuthResume, pending, handleAuthResume)
+	r.Register(protocol.TypeAuthLogout, public, handleAuthLogout)
+	r.Register(protocol.TypePairRequest, public, handlePairRequest)
+
+	r.Register(protocol.TypeMouseMove, mouse, handleMouseMove)
+	r.Register(protocol.TypeMouseClick, mouse, handleMouseButton)
+	r.Register(protocol.TypeMouseDown, mouse, handleMouseButton)
+	r.Register(protocol.TypeMouseUp, mouse, handleMouseButton)
+	r.Register(protocol.TypeMouseScroll, mouse, handleMouseScroll)
+
+	r.Register(protocol.TypeKeyText, keyboard, handleKeyText)
+	r.Register(protocol.TypeKey, keyboard, handleKey)
+	r.Register(protocol.TypeKeyDown, keyboard, handleKey)
+	r.Register(protocol.TypeKeyUp, keyboard, handleKey)
+	r.Register(protocol.TypeHotkey, keyboard, handleHotkey)
+	r.Register(protocol.TypeKeyVK, keyboard, handleKeyVK)
+	r.Register(protocol.TypeKeyDownVK, keyboard, handleKeyVK)
+	r.Register(protocol.TypeKeyUpVK, keyboard, handleKeyVK)
+	r.Register(protocol.TypeHotkeyVK, keyboard, handleHotkeyVK)
+	r.Register(protocol.TypeTextInput, keyboard, handleTextInput)
+	r.Register(protocol.TypeInputKeyTap, keyboard, handleInputKey)
+	r.Register(protocol.TypeInputKeyDown, keyboard, handleInputKey)
+	r.Register(protocol.TypeInputKeyUp, keyboard, handleInputKey)
+
+	// los pasos de batch/macro_run se revisan uno por uno con los permisos de la sesión
+	anyone := HandlerOptions{Permission: PermAny}
+	macros := HandlerOptions{Permission: PermMacros}
+
+	r.Register(protocol.TypeReleaseAll, anyone, handleReleaseAll)
+	r.Register(protocol.TypeBatch, anyone, handleBatch)
+
+	r.Register(protocol.TypeMacroList, anyone, handleMacroList)
+	r.Register(protocol.TypeMacroSave, macros, handleMacroSave)
+	r.Register(protocol.TypeMacroDelete, macros, handleMacroDelete)
+	r.Register(protocol.TypeMacroRun, anyone, handleMacroRun)
+	r.Register(protocol.TypeMacroRecordStart, macros, handleMacroRecordStart)
+	r.Register(protocol.TypeMacroRecordStop, macros, handleMacroRecordStop)
+
+	r.Register(protocol.TypeCaptureStart, HandlerOptions{Feature: input.FeatureCapture}, handleCaptureStart)
+	r.Register(protocol.TypeCaptureCancel, HandlerOptions{Feature: input.FeatureCapture}, handleCaptureCancel)
+	r.Register(protocol.TypeKeysList, HandlerOptions{Feature: input.FeatureKeyboard, Permission: PermAny}, handleKeysList)
+
+	r.Register(protocol.TypeAppsList, HandlerOptions{Feature: input.FeatureApps}, handleAppsList)
+	r.Register(protocol.TypeAppAction, HandlerOptions{Feature: input.FeatureApps}, handleAppAction)
+}
+
+// ---- public ----
+
+func handlePing(c *Context) error {
+	return c.Reply(protocol.Pong{ID: c.ID, Type: protocol.TypePong})
+}
+
+// hello se permite antes del login: el cliente necesita saber qué versión hablamos
+func handleHello(c *Context) error {
+	var m protocol.Hello
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	v, ok := protocol.Negotiate(m.Protocol)
+	if !ok {
+		log.Printf("[ws] hello rejected: client protocol=%d min=%d app=%q", m.Protocol, protocol.MinVersion, m.AppVersion)
+		return Errorf(protocol.CodeProtocolVersion, "%d (mínimo %d)", m.Protocol, protocol.MinVersion)
+	}
+
+	lang := c.Session.Lang()
+	if m.Lang != "" {
+		lang = normLang(m.Lang)
+	}
+	c.Session.setPrefs(lang, m.Acks)
+	if m.Binary {
+		c.Session.setBinary(true)
+	}
+	setSessionClient(c.Session.ID(), v, m.Client, m.AppVersion, m.DeviceName)
+	log.Printf("[ws] hello client=%q app=%q device=%q protocol=%d->%d acks=%v binary=%v", m.Client, m.AppVersion, m.DeviceName, m.Protocol, v, m.Acks, c.Session.Binary())
+	// con el nombre del dispositivo ya se puede preguntar en el PC
+	requestApproval(c.Session)
+
+	return c.Reply(protocol.HelloOk{
+		ID:            c.ID,
+		Type:          protocol.TypeHelloOk,
+		Protocol:      v,
+		MinProtocol:   protocol.MinVersion,
+		DaemonVersion: protocol.DaemonVersion,
+		Session:       c.Session.ID(),
+		Types:         c.Registry().Types(c.Driver),
+		Acks:          m.Acks,
+		Binary:        c.Session.Binary(),
+	})
+}
+
+// --- LOGIN (solo TLS + RequireAccount) ---
+func handleAuthLogin(c *Context) error {
+	if !c.AccountRequired() {
+		// sin cuentas no hay nada que validar
+		return c.Reply(authOk(c, "", sessionPermissions(c)))
+	}
+	if c.Session.Authed() {
+		return c.Reply(authOk(c, c.Session.Username(), c.Session.Permissions()))
+	}
+
+	var m protocol.AuthLogin
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	u := strings.TrimSpace(m.Username)
+	p := m.Password
+
+	if u == "" || p == "" {
+		return &Error{Code: protocol.CodeBadRequest, Detail: "usuario/contraseña requeridos"}
+	}
+
+	// fuerza bruta: si la IP o el usuario están en backoff/bloqueados ni
+	// siquiera corremos bcrypt
+	lim := c.Session.authLimits()
+	keys := authKeys(c.Session, u)
+	if wait := authWait(keys, lim, time.Now()); wait > 0 {
+		log.Printf("[auth] login throttled user=%q remote=%s wait=%s", u, c.Session.RemoteAddr(), wait)
+		lerr := lockedError(wait)
+		audit.Recordf(audit.LoginFailed, c.Session.ID(), u, c.Session.RemoteAddr(), "bloqueado, faltan %s", lerr.RetryAfter)
+		return authFailure(c, lerr)
+	}
+	failed := func() error {
+		log.Printf("[auth] login failed user=%q remote=%s", u, c.Session.RemoteAddr())
+		audit.Recordf(audit.LoginFailed, c.Session.ID(), u, c.Session.RemoteAddr(), "usuario o contraseña inválidos")
+		recordAuthFailure(keys, lim, remoteHost(c.Session.RemoteAddr()), time.Now())
+		return authFailure(c, &Error{Code: protocol.CodeAuthInvalid})
+	}
+
+	row, err := loadUser(u)
+	if err != nil {
+		// sqlite: si tabla no existe, devuelve error: treat as no users
+		if err == sql.ErrNoRows {
+			return failed()
+		}
+		// también cubre "no such table: users"
+		log.Printf("[auth] loadUser error: %v", err)
+		return &Error{Code: protocol.CodeNoUsers}
+	}
+
+	if row.Disabled {
+		audit.Recordf(audit.LoginFailed, c.Session.ID(), row.Username, c.Session.RemoteAddr(), "usuario deshabilitado")
+		return &Error{Code: protocol.CodeUserDisabled}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(row.PasswordHash), []byte(p)) != nil {
+		return failed()
+	}
+	clearAuthFailures(keys)
+
+	markSessionAuthed(c.Session.ID(), row.Username, row.Permissions)
+	markLastLogin(row.Username)
+	log.Printf("[auth] login ok user=%q session=%s permissions=%s", row.Username, c.Session.ID(), row.Permissions)
+	audit.Recordf(audit.Login, c.Session.ID(), row.Username, c.Session.RemoteAddr(), "permissions=%s", row.Permissions)
+
+	return c.Reply(authOk(c, row.Username, row.Permissions))
+}
+
+// ---- mouse ----
+
+func handleMouseMove(c *Context) error {
+	var m protocol.MouseMove
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	return c.Driver.MoveMouse(m.Dx, m.Dy)
+}
+
+// handleMouseButton atiende mouse_click, mouse_down y mouse_up.
+func handleMouseButton(c *Context) error {
+	var m protocol.MouseButton
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	held := heldInput{kind: heldButton, name: normButton(m.Button)}
+	switch c.Type {
+	case protocol.TypeMouseDown:
+		if err := c.Driver.MouseDown(m.Button); err != nil {
+			return err
+		}
+		c.Session.held.press(held)
+		return nil
+	case protocol.TypeMouseUp:
+		c.Session.held.release(held)
+		return c.Driver.MouseUp(m.Button)
+	default:
+		return c.Driver.MouseClick(m.Button)
+	}
+}
+
+func handleMouseScroll(c *Context) error {
+	var m protocol.MouseScroll
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	return c.Driver.MouseScroll(m.Dy)
+}
+
+// ---- keyboard ----
+
+func handleKeyText(c *Context) error {
+	var m protocol.KeyText
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[input] key_text text=%q", m.Text)
+	return c.Driver.KeyText(m.Text)
+}
+
+// handleKey atiende key, key_down y key_up.
+func handleKey(c *Context) error {
+	var m protocol.Key
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[input] %s key=%q", c.Type, m.Key)
+	held := heldInput{kind: heldKey, name: m.Key}
+	switch c.Type {
+	case protocol.TypeKeyDown:
+		if err := c.Driver.KeyDown(m.Key); err != nil {
+			return err
+		}
+		c.Session.held.press(held)
+		return nil
+	case protocol.TypeKeyUp:
+		c.Session.held.release(held)
+		return c.Driver.KeyUp(m.Key)
+	default:
+		return c.Driver.Key(m.Key)
+	}
+}
+
+func handleHotkey(c *Context) error {
+	var m protocol.Hotkey
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[input] hotkey mods=%v key=%q", m.Mods, m.Key)
+	return c.Driver.Hotkey(m.Mods, m.Key)
+}
+
+// handleKeyVK atiende key_vk, key_down_vk y key_up_vk.
+func handleKeyVK(c *Context) error {
+	var m protocol.KeyVK
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[input] %s vk=%d scan=%d ext=%v", c.Type, m.Key.VK, m.Key.Scan, m.Key.Ext)
+	switch c.Type {
+	case protocol.TypeKeyDownVK:
+		return keyDownVK(c, m.Key)
+	case protocol.TypeKeyUpVK:
+		return keyUpVK(c, m.Key)
+	default:
+		return c.Driver.KeyVK(m.Key)
+	}
+}
+
+func handleHotkeyVK(c *Context) error {
+	var m protocol.HotkeyVK
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[input] hotkey_vk mods=%v vk=%d scan=%d ext=%v", m.Mods, m.Key.VK, m.Key.Scan, m.Key.Ext)
+	return c.Driver.HotkeyVK(m.Mods, m.Key)
+}
+
+func handleTextInput(c *Context) error {
+	var m protocol.TextInput
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	text := m.Value()
+	log.Printf("[input] text_input id=%s text=%q", c.ID, text)
+	if text == "" {
+		return nil
+	}
+	return c.Driver.KeyText(text)
+}
+
+// handleInputKey atiende input_key_tap, input_key_down e input_key_up.
+func handleInputKey(c *Context) error {
+	var m protocol.InputKeyFlat
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	ks := m.KeySpec()
+	log.Printf("[input] %s id=%s vk=%d scan=%d ext=%v", c.Type, c.ID, ks.VK, ks.Scan, ks.Ext)
+	if ks.VK == 0 && ks.Scan == 0 {
+		return nil
+	}
+	switch c.Type {
+	case protocol.TypeInputKeyDown:
+		return keyDownVK(c, ks)
+	case protocol.TypeInputKeyUp:
+		return keyUpVK(c, ks)
+	default:
+		return c.Driver.KeyVK(ks)
+	}
+}
+
+func keyDownVK(c *Context, ks input.KeySpec) error {
+	if err := c.Driver.KeyDownVK(ks); err != nil {
+		return err
+	}
+	c.Session.held.press(heldInput{kind: heldVK, spec: ks})
+	return nil
+}
+
+func keyUpVK(c *Context, ks input.KeySpec) error {
+	c.Session.held.release(heldInput{kind: heldVK, spec: ks})
+	return c.Driver.KeyUpVK(ks)
+}
+
+// handleReleaseAll suelta todo lo apretado por esta sesión y por las suyas
+// que quedaron estacionadas al cortarse (ver releaseTargets). Lo de otras
+// sesiones no se toca: el teléfono que reconecta recupera lo suyo con
+// auth_resume.
+func handleReleaseAll(c *Context) error {
+	n := 0
+	for _, se := range releaseTargets(c.Session) {
+		n += se.releaseHeld(c.Driver, "release_all")
+	}
+	log.Printf("[input] release_all id=%s session=%s released=%d", c.ID, c.Session.ID(), n)
+	return nil
+}
+
+// ---- teclas ----
+
+func handleKeysList(c *Context) error {
+	var m protocol.KeysList
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	keys := input.Keys()
+	if len(m.Groups) > 0 {
+		want := map[input.KeyGroup]bool{}
+		for _, g := range m.Groups {
+			want[input.KeyGroup(strings.ToLower(g))] = true
+		}
+		out := keys[:0]
+		for _, k := range keys {
+			if want[k.Group] {
+				out = append(out, k)
+			}
+		}
+		keys = out
+	}
+	return c.Reply(protocol.KeysListResult{ID: m.ID, Type: protocol.TypeKeysListResult, Keys: keys})
+}
+
+// ---- apps ----
+
+func handleAppsList(c *Context) error {
+	var m protocol.AppsList
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	apps, err := c.Driver.ListApps()
+	if err != nil {
+		log.Printf("[apps] list error id=%s: %v", m.ID, err)
+		return err
+	}
+	log.Printf("[apps] list ok id=%s count=%d", m.ID, len(apps))
+	return c.Reply(protocol.AppsListResult{ID: m.ID, Type: protocol.TypeAppsListResult, Apps: apps})
+}
+
+func handleAppAction(c *Context) error {
+	var m protocol.AppAction
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	log.Printf("[apps] action id=%s hwnd=%d action=%s", m.ID, m.Hwnd, m.Action)
+	err := c.Driver.AppAction(m.Hwnd, m.Action)
+	result := "ok"
+	if err != nil {
+		log.Printf("[apps] action error id=%s: %v", m.ID, err)
+		result = err.Error()
+	}
+	audit.Recordf(audit.AppAction, c.Session.ID(), c.Session.Username(), c.Session.RemoteAddr(),
+		"action=%s hwnd=%d result=%s", m.Action, m.Hwnd, result)
+	return err
+}
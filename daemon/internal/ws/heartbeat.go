@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Valores por defecto del heartbeat. Un teléfono que se duerme sin cerrar el
+// socket deja de contestar pings y a los IdleTimeout se corta su sesión.
+const (
+	DefaultPingInterval = 15 * time.Second
+	DefaultIdleTimeout  = 60 * time.Second
+)
+
+// SetHeartbeat configura cada cuánto se manda un ping (control frame) y cuánto
+// tiempo sin tráfico (mensajes o pongs) se tolera antes de cortar la sesión.
+// 0 desactiva cada cosa. Aplica a conexiones nuevas; el reaper usa el valor nuevo
+// enseguida.
+func (s *Server) SetHeartbeat(ping, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pingEvery = ping
+	s.idleTO = idle
+}
+
+func (s *Server) heartbeat() (ping, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pingEvery, s.idleTO
+}
+
+// ping manda un ping control frame (comparte el lock de escritura con WriteJSON).
+func (s *safeConn) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.WriteControl(websocket.PingMessage, nil, time.Now().Add(3*time.Second))
+}
+
+// extendDeadline corre el read deadline de la conexión idle hacia adelante.
+func extendDeadline(c *websocket.Conn, idle time.Duration) {
+	if idle > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(idle))
+	} else {
+		_ = c.SetReadDeadline(time.Time{})
+	}
+}
+
+// pingLoop manda pings hasta que done se cierre o falle la escritura.
+func pingLoop(conn *safeConn, every time.Duration, done <-chan struct{}) {
+	if every <= 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if err := conn.ping(); err != nil {
+				return // el read loop se entera solo (deadline o error)
+			}
+		}
+	}
+}
+
+// reapLoop corta las sesiones de s que llevan más de idleTimeout sin tráfico.
+// Es la red de seguridad para cuando el read deadline no alcanza (p.ej. un
+// handler trabado con el driver).
+func (s *Server) reapLoop(stop <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			if _, idle := s.heartbeat(); idle > 0 {
+				reapIdleSessions(s, idle, now)
+			}
+		}
+	}
+}
+
+func reapIdleSessions(srv *Server, idle time.Duration, now time.Time) int {
+	limit := now.Add(-idle).Unix()
+
+	sessionsMu.Lock()
+	var stale []*Session
+	for _, se := range sessions {
+		if se.srv == srv && se.lastSeenAt < limit {
+			if se.dropReason == "" {
+				se.dropReason = fmt.Sprintf("idle %ds (timeout %s)", now.Unix()-se.lastSeenAt, idle)
+			}
+			stale = append(stale, se)
+		}
+	}
+	sessionsMu.Unlock()
+
+	for _, se := range stale {
+		info := se.Info()
+		log.Printf("[ws] reaping session=%s user=%q remote=%s reason=%s",
+			info.ID, info.Username, info.RemoteAddr, se.reason())
+		se.releaseHeld(srv.inputDriver(), "reaped")
+		if se.conn != nil && se.conn.c != nil {
+			se.conn.close(websocket.CloseGoingAway, "idle timeout")
+		}
+	}
+	return len(stale)
+}
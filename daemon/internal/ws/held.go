@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/input"
+)
+
+// DefaultHoldTimeout: si una sesión deja algo apretado y no manda nada durante
+// este tiempo, se suelta solo (el teléfono pudo perder el Wi-Fi sin cerrar).
+const DefaultHoldTimeout = 30 * time.Second
+
+type heldKind int
+
+const (
+	heldButton heldKind = iota
+	heldKey             // por nombre (key_down)
+	heldVK              // por KeySpec (key_down_vk / input_key_down)
+)
+
+type heldInput struct {
+	kind heldKind
+	name string // botón o tecla
+	spec input.KeySpec
+}
+
+// heldSet es lo que una sesión tiene apretado ahora mismo (botones y teclas).
+// Tiene su propio mutex: soltar llama al driver y no queremos sessionsMu tomado.
+type heldSet struct {
+	mu      sync.Mutex
+	items   map[string]heldInput
+	timeout time.Duration
+	timer   *time.Timer
+	onIdle  func() // se llama si vence timeout con algo apretado
+}
+
+func heldID(h heldInput) string {
+	switch h.kind {
+	case heldButton:
+		return "btn:" + h.name
+	case heldKey:
+		return "key:" + h.name
+	default:
+		if h.spec.VK != 0 {
+			return fmt.Sprintf("vk:%d", h.spec.VK)
+		}
+		return fmt.Sprintf("scan:%d:%v", h.spec.Scan, h.spec.Ext)
+	}
+}
+
+func normButton(button string) string {
+	b := strings.ToLower(strings.TrimSpace(button))
+	if b == "" {
+		return "left"
+	}
+	return b
+}
+
+func (hs *heldSet) press(h heldInput) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.items == nil {
+		hs.items = map[string]heldInput{}
+	}
+	hs.items[heldID(h)] = h
+	hs.armLocked()
+}
+
+func (hs *heldSet) release(h heldInput) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	delete(hs.items, heldID(h))
+	if len(hs.items) == 0 && hs.timer != nil {
+		hs.timer.Stop()
+	}
+}
+
+// touch posterga el timeout: mientras la sesión siga mandando cosas
+// (p.ej. mouse_move durante un drag) no soltamos nada.
+func (hs *heldSet) touch() {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.items) > 0 {
+		hs.armLocked()
+	}
+}
+
+func (hs *heldSet) setTimeout(d time.Duration) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.timeout = d
+	if len(hs.items) > 0 {
+		hs.armLocked()
+	}
+}
+
+func (hs *heldSet) armLocked() {
+	if hs.timer != nil {
+		hs.timer.Stop()
+	}
+	if hs.timeout <= 0 || hs.onIdle == nil {
+		return
+	}
+	hs.timer = time.AfterFunc(hs.timeout, hs.onIdle)
+}
+
+// take vacía el set y devuelve lo que estaba apretado.
+func (hs *heldSet) take() []heldInput {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.timer != nil {
+		hs.timer.Stop()
+	}
+	out := make([]heldInput, 0, len(hs.items))
+	for _, h := range hs.items {
+		out = append(out, h)
+	}
+	hs.items = nil
+	return out
+}
+
+func (hs *heldSet) count() int {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return len(hs.items)
+}
+
+// releaseHeld manda los "up" de todo lo que la sesión tiene apretado.
+// Devuelve cuántos se soltaron.
+func (se *Session) releaseHeld(driver input.InputDriver, reason string) int {
+	items := se.held.take()
+	if len(items) == 0 || driver == nil {
+		return 0
+	}
+	for _, h := range items {
+		var err error
+		switch h.kind {
+		case heldButton:
+			err = driver.MouseUp(h.name)
+		case heldKey:
+			err = driver.KeyUp(h.name)
+		case heldVK:
+			err = driver.KeyUpVK(h.spec)
+		}
+		if err != nil {
+			log.Printf("[ws] release %s session=%s error: %v", heldID(h), se.id, err)
+		}
+	}
+	log.Printf("[ws] released %d held input(s) session=%s reason=%s", len(items), se.id, reason)
+	return len(items)
+}
@@ -0,0 +1,357 @@
+package ws
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// AuthLimits es la política contra fuerza bruta de auth_login. Los fallos se
+// cuentan por IP y por usuario: cada fallo duplica la espera antes del próximo
+// intento y al llegar a MaxFailures la IP/usuario queda bloqueado (en la DB).
+type AuthLimits struct {
+	MaxFailures        int           // fallos seguidos antes de bloquear (0 = nunca se bloquea)
+	LockoutDuration    time.Duration // cuánto dura un bloqueo
+	SessionMaxFailures int           // fallos en una misma conexión antes de cerrarla (0 = nunca)
+	BaseDelay          time.Duration // espera tras el primer fallo; se duplica con cada uno
+}
+
+// DefaultAuthLimits: 5 fallos -> 15 min bloqueado; 3 fallos cierran la conexión.
+var DefaultAuthLimits = AuthLimits{
+	MaxFailures:        5,
+	LockoutDuration:    15 * time.Minute,
+	SessionMaxFailures: 3,
+	BaseDelay:          time.Second,
+}
+
+// SetAuthLimits cambia la política de login en caliente.
+func (s *Server) SetAuthLimits(l AuthLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authLim = l
+}
+
+func (s *Server) authLimits() AuthLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authLim
+}
+
+func (se *Session) authLimits() AuthLimits {
+	if se.srv == nil {
+		return DefaultAuthLimits
+	}
+	return se.srv.authLimits()
+}
+
+// ---- fallos en memoria (backoff) ----
+
+type authFail struct {
+	count int
+	last  time.Time
+}
+
+var (
+	authFailMu sync.Mutex
+	authFails  = map[string]*authFail{}
+)
+
+// authKeys: las claves que cuentan fallos para este intento ("ip:..." y "user:...").
+func authKeys(se *Session, username string) []string {
+	return []string{"ip:" + remoteHost(se.RemoteAddr()), "user:" + strings.ToLower(strings.TrimSpace(username))}
+}
+
+// backoff devuelve la espera obligatoria después de n fallos seguidos.
+func (l AuthLimits) backoff(n int) time.Duration {
+	if n <= 0 || l.BaseDelay <= 0 {
+		return 0
+	}
+	d := l.BaseDelay
+	for i := 1; i < n && d < l.LockoutDuration; i++ {
+		d *= 2
+	}
+	if l.LockoutDuration > 0 && d > l.LockoutDuration {
+		d = l.LockoutDuration
+	}
+	return d
+}
+
+// authWait dice cuánto falta para que keys puedan volver a intentar
+// (bloqueo en la DB o backoff en memoria). 0 = puede intentar ya.
+func authWait(keys []string, l AuthLimits, now time.Time) time.Duration {
+	var wait time.Duration
+
+	authFailMu.Lock()
+	for _, k := range keys {
+		if f, ok := authFails[k]; ok {
+			if d := f.last.Add(l.backoff(f.count)).Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	authFailMu.Unlock()
+
+	until, err := lockedUntil(keys)
+	if err != nil {
+		log.Printf("[auth] lockout lookup error: %v", err)
+	} else if d := until.Sub(now); d > wait {
+		wait = d
+	}
+	return wait
+}
+
+// recordAuthFailure suma un fallo a cada clave; las que llegan a MaxFailures
+// se bloquean (en la DB) y empiezan de cero cuando vence el bloqueo.
+func recordAuthFailure(keys []string, l AuthLimits, ip string, now time.Time) {
+	var lock []string
+	counts := map[string]int{}
+
+	authFailMu.Lock()
+	pruneAuthFailsLocked(l, now)
+	for _, k := range keys {
+		f := authFails[k]
+		if f == nil {
+			f = &authFail{}
+			authFails[k] = f
+		}
+		f.count++
+		f.last = now
+		counts[k] = f.count
+		if l.MaxFailures > 0 && f.count >= l.MaxFailures {
+			lock = append(lock, k)
+			delete(authFails, k)
+		}
+	}
+	authFailMu.Unlock()
+
+	until := now.Add(l.LockoutDuration)
+	for _, k := range lock {
+		log.Printf("[auth] lockout key=%s failures=%d until=%s", k, counts[k], until.Format(time.RFC3339))
+		if err := saveLockout(k, counts[k], until, ip, now); err != nil {
+			log.Printf("[auth] lockout save error key=%s: %v", k, err)
+		}
+		user := ""
+		if kind, v, _ := strings.Cut(k, ":"); kind == "user" {
+			user = v
+		}
+		audit.Recordf(audit.Lockout, "", user, ip, "key=%s failures=%d until=%s", k, counts[k], until.Format(time.RFC3339))
+	}
+}
+
+// clearAuthFailures se llama con un login correcto.
+func clearAuthFailures(keys []string) {
+	authFailMu.Lock()
+	defer authFailMu.Unlock()
+	for _, k := range keys {
+		delete(authFails, k)
+	}
+}
+
+// pruneAuthFailsLocked olvida fallos viejos (que nadie llene el mapa con
+// usuarios inventados).
+func pruneAuthFailsLocked(l AuthLimits, now time.Time) {
+	ttl := l.LockoutDuration
+	if ttl <= 0 {
+		ttl = DefaultAuthLimits.LockoutDuration
+	}
+	for k, f := range authFails {
+		if now.Sub(f.last) > ttl {
+			delete(authFails, k)
+		}
+	}
+}
+
+// ---- por conexión ----
+
+func (se *Session) addAuthFailure() int {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.authFails++
+	return se.authFails
+}
+
+// authFailure informa err y, si la conexión ya juntó SessionMaxFailures
+// fallos, la cierra.
+func authFailure(c *Context, err *Error) error {
+	l := c.Session.authLimits()
+	n := c.Session.addAuthFailure()
+	if l.SessionMaxFailures <= 0 || n < l.SessionMaxFailures {
+		return err
+	}
+	_ = c.Reply(c.ErrorFor(err))
+	log.Printf("[auth] closing session=%s remote=%s after %d failed logins", c.Session.ID(), c.Session.RemoteAddr(), n)
+	audit.Recordf(audit.SessionClosed, c.Session.ID(), "", c.Session.RemoteAddr(), "%d logins fallidos en la conexión", n)
+	c.Session.setDropReason("auth failures")
+	invalidateResume(c.Session.ID())
+	if c.Session.conn != nil && c.Session.conn.c != nil {
+		c.Session.conn.close(websocket.ClosePolicyViolation, "too many failed logins")
+	}
+	return nil
+}
+
+// ---- bloqueos en la DB ----
+
+// Lockout es un bloqueo registrado en la tabla auth_lockouts.
+type Lockout struct {
+	Key         string // "ip:192.168.1.20" o "user:juan"
+	Kind        string // "ip" / "user"
+	Value       string
+	Failures    int
+	LockedUntil int64
+	LastIP      string
+	CreatedAt   int64
+}
+
+// Active indica si el bloqueo sigue vigente.
+func (l Lockout) Active() bool { return l.LockedUntil > time.Now().Unix() }
+
+func openLockoutsDB() (*sql.DB, error) {
+	db, err := openUsersDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureLockoutsSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureLockoutsSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS auth_lockouts (
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  locked_until INTEGER NOT NULL,
+  last_ip TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL
+);
+`)
+	return err
+}
+
+func saveLockout(key string, failures int, until time.Time, ip string, now time.Time) error {
+	db, err := openLockoutsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+INSERT INTO auth_lockouts(key, failures, locked_until, last_ip, created_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET
+  failures=excluded.failures,
+  locked_until=excluded.locked_until,
+  last_ip=excluded.last_ip,
+  created_at=excluded.created_at;
+`, key, failures, until.Unix(), ip, now.Unix())
+	return err
+}
+
+// lockedUntil devuelve el vencimiento del bloqueo más largo de keys (cero si no hay).
+func lockedUntil(keys []string) (time.Time, error) {
+	db, err := openLockoutsDB()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer db.Close()
+
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	var until sql.NullInt64
+	q := `SELECT MAX(locked_until) FROM auth_lockouts WHERE key IN (?` + strings.Repeat(",?", len(keys)-1) + `);`
+	if err := db.QueryRow(q, args...).Scan(&until); err != nil {
+		return time.Time{}, err
+	}
+	if !until.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(until.Int64, 0), nil
+}
+
+// ListLockouts devuelve los bloqueos registrados (vigentes primero).
+func ListLockouts() ([]Lockout, error) {
+	db, err := openLockoutsDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+SELECT key, failures, locked_until, last_ip, created_at
+FROM auth_lockouts
+ORDER BY locked_until DESC;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Lockout{}
+	for rows.Next() {
+		var l Lockout
+		if err := rows.Scan(&l.Key, &l.Failures, &l.LockedUntil, &l.LastIP, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		l.Kind, l.Value, _ = strings.Cut(l.Key, ":")
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// Unlock borra el bloqueo key y sus fallos acumulados.
+func Unlock(key string) error {
+	db, err := openLockoutsDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`DELETE FROM auth_lockouts WHERE key=?;`, key)
+	if err != nil {
+		return err
+	}
+	clearAuthFailures([]string{key})
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("bloqueo no encontrado: %s", key)
+	}
+	log.Printf("[auth] unlocked key=%s", key)
+	audit.Recordf(audit.Unlock, "", "", audit.LocalAddr, "key=%s", key)
+	return nil
+}
+
+// PurgeExpiredLockouts borra los bloqueos vencidos; devuelve cuántos.
+func PurgeExpiredLockouts() (int64, error) {
+	db, err := openLockoutsDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`DELETE FROM auth_lockouts WHERE locked_until <= ?;`, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// lockedError arma el AUTH_LOCKED con lo que falta esperar.
+func lockedError(wait time.Duration) *Error {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return &Error{Code: protocol.CodeAuthLocked, Detail: wait.String(), RetryAfter: wait}
+}
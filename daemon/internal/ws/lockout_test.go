@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+func TestAuthBackoff(t *testing.T) {
+	l := AuthLimits{BaseDelay: time.Second, LockoutDuration: 10 * time.Second}
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for n, w := range want {
+		if got := l.backoff(n); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", n, got, w)
+		}
+	}
+	if got := (AuthLimits{LockoutDuration: time.Minute}).backoff(3); got != 0 {
+		t.Errorf("sin BaseDelay: backoff = %s", got)
+	}
+}
+
+// forgetAuthFailures deja limpio lo que un test de login dejó bloqueado (los
+// fallos son globales y la IP de todos los tests es la misma).
+func forgetAuthFailures(t *testing.T, user string) {
+	t.Cleanup(func() {
+		keys := []string{"ip:127.0.0.1", "user:" + user}
+		for _, k := range keys {
+			_ = Unlock(k)
+		}
+		clearAuthFailures(keys)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	srv, _ := startAccountServer(t)
+	srv.SetAuthLimits(AuthLimits{MaxFailures: 3, LockoutDuration: time.Minute})
+	addTestUser(t, "lock_user", "secreto", Permissions{PermAdmin})
+	forgetAuthFailures(t, "lock_user")
+	conn := mustConnect(t, srv, "")
+
+	for i := 0; i < 3; i++ {
+		if resp := login(t, conn, "lock_user", "mal"); resp["code"] != protocol.CodeAuthInvalid {
+			t.Fatalf("intento %d -> %v", i, resp)
+		}
+	}
+	// bloqueado: ni la contraseña correcta entra
+	resp := login(t, conn, "lock_user", "secreto")
+	if resp["code"] != protocol.CodeAuthLocked {
+		t.Fatalf("bloqueado -> %v", resp)
+	}
+	if ms, _ := resp["retry_after_ms"].(float64); ms < float64(50*time.Second/time.Millisecond) {
+		t.Errorf("retry_after_ms = %v", resp["retry_after_ms"])
+	}
+
+	locked := map[string]bool{}
+	list, err := ListLockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range list {
+		locked[l.Key] = l.Active()
+	}
+	if !locked["user:lock_user"] || !locked["ip:127.0.0.1"] {
+		t.Errorf("bloqueos = %v", locked)
+	}
+
+	// desbloquear el usuario no alcanza mientras la IP siga bloqueada
+	if err := Unlock("user:lock_user"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := login(t, conn, "lock_user", "secreto"); resp["code"] != protocol.CodeAuthLocked {
+		t.Errorf("con la IP bloqueada -> %v", resp)
+	}
+	if err := Unlock("ip:127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := login(t, conn, "lock_user", "secreto"); resp["type"] != protocol.TypeAuthOk {
+		t.Errorf("desbloqueado -> %v", resp)
+	}
+	if err := Unlock("ip:127.0.0.1"); err == nil {
+		t.Error("Unlock de algo no bloqueado no dio error")
+	}
+}
+
+func TestLoginBackoffClosesSession(t *testing.T) {
+	srv, _ := startAccountServer(t)
+	srv.SetAuthLimits(AuthLimits{SessionMaxFailures: 2, BaseDelay: time.Hour, LockoutDuration: 2 * time.Hour})
+	addTestUser(t, "backoff_user", "secreto", Permissions{PermAdmin})
+	forgetAuthFailures(t, "backoff_user")
+	conn := mustConnect(t, srv, "")
+
+	if resp := login(t, conn, "backoff_user", "mal"); resp["code"] != protocol.CodeAuthInvalid {
+		t.Fatalf("primer intento -> %v", resp)
+	}
+	// en backoff: no se prueba la contraseña, y es el segundo fallo de la conexión
+	if resp := login(t, conn, "backoff_user", "secreto"); resp["code"] != protocol.CodeAuthLocked {
+		t.Fatalf("en backoff -> %v", resp)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("la conexión sigue abierta después de SessionMaxFailures")
+	}
+}
@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Macro es una secuencia con nombre de pasos de entrada (mismo formato que los
+// pasos de un batch, con "delay_ms" opcional). Vive en la tabla macros de la
+// DB junto a users, así sobrevive a reinstalar la app del teléfono.
+type Macro struct {
+	Name      string            `json:"name"`
+	Steps     []json.RawMessage `json:"steps"`
+	CreatedAt int64             `json:"created_at,omitempty"`
+	UpdatedAt int64             `json:"updated_at,omitempty"`
+}
+
+// ErrMacroNotFound: no hay macro con ese nombre.
+var ErrMacroNotFound = errors.New("macro no encontrada")
+
+// maxMacroName: los nombres se muestran en botones del teléfono.
+const maxMacroName = 64
+
+func openMacrosDB() (*sql.DB, error) {
+	db, err := openUsersDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMacrosSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureMacrosSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS macros (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+  steps TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+`)
+	return err
+}
+
+func cleanMacroName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Errorf(protocol.CodeBadRequest, "nombre de macro requerido")
+	}
+	if len([]rune(name)) > maxMacroName {
+		return "", Errorf(protocol.CodeBadRequest, "nombre de macro demasiado largo (máximo %d)", maxMacroName)
+	}
+	return name, nil
+}
+
+// ValidateMacro revisa que todos los pasos sean mensajes de entrada que r
+// atiende (lo mismo que exige batch). La UI usa DefaultRegistry.
+func ValidateMacro(r *Registry, m Macro) error {
+	if _, err := cleanMacroName(m.Name); err != nil {
+		return err
+	}
+	if len(m.Steps) == 0 {
+		return Errorf(protocol.CodeBadRequest, "la macro no tiene pasos")
+	}
+	_, err := parseBatch(r, nil, m.Steps)
+	return err
+}
+
+// ListMacros devuelve todas las macros ordenadas por nombre.
+func ListMacros() ([]Macro, error) {
+	db, err := openMacrosDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+SELECT name, steps, created_at, updated_at
+FROM macros
+ORDER BY LOWER(name) ASC;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Macro{}
+	for rows.Next() {
+		var m Macro
+		var steps string
+		if err := rows.Scan(&m.Name, &steps, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(steps), &m.Steps); err != nil {
+			return nil, fmt.Errorf("macro %q: pasos corruptos: %w", m.Name, err)
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// GetMacro busca una macro por nombre (sin distinguir mayúsculas).
+func GetMacro(name string) (*Macro, error) {
+	db, err := openMacrosDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var m Macro
+	var steps string
+	err = db.QueryRow(`
+SELECT name, steps, created_at, updated_at
+FROM macros
+WHERE name = ?
+LIMIT 1;
+`, strings.TrimSpace(name)).Scan(&m.Name, &steps, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(steps), &m.Steps); err != nil {
+		return nil, fmt.Errorf("macro %q: pasos corruptos: %w", m.Name, err)
+	}
+	return &m, nil
+}
+
+// SaveMacro crea o reemplaza (por nombre) una macro. Validar antes con ValidateMacro.
+func SaveMacro(m Macro) error {
+	name, err := cleanMacroName(m.Name)
+	if err != nil {
+		return err
+	}
+	steps, err := json.Marshal(m.Steps)
+	if err != nil {
+		return err
+	}
+
+	db, err := openMacrosDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now().Unix()
+	_, err = db.Exec(`
+INSERT INTO macros(name, steps, created_at, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(name) DO UPDATE SET
+  name=excluded.name,
+  steps=excluded.steps,
+  updated_at=excluded.updated_at;
+`, name, string(steps), now, now)
+	return err
+}
+
+// RenameMacro cambia el nombre de una macro.
+func RenameMacro(oldName, newName string) error {
+	newName, err := cleanMacroName(newName)
+	if err != nil {
+		return err
+	}
+
+	db, err := openMacrosDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`UPDATE macros SET name=?, updated_at=? WHERE name=?;`,
+		newName, time.Now().Unix(), strings.TrimSpace(oldName))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return Errorf(protocol.CodeBadRequest, "ya existe una macro llamada %q", newName)
+		}
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrMacroNotFound, oldName)
+	}
+	return nil
+}
+
+// DeleteMacro borra una macro por nombre.
+func DeleteMacro(name string) error {
+	db, err := openMacrosDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(`DELETE FROM macros WHERE name=?;`, strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"errors"
+	"log"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// macroError traduce errores del store a códigos del protocolo.
+func macroError(op, name string, err error) error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrMacroNotFound):
+		return Errorf(protocol.CodeNotFound, "macro %q", name)
+	}
+	log.Printf("[macro] %s error: %v", op, err)
+	return &Error{Code: protocol.CodeInternal}
+}
+
+func handleMacroList(c *Context) error {
+	list, err := ListMacros()
+	if err != nil {
+		return macroError("list", "", err)
+	}
+	out := make([]protocol.MacroInfo, 0, len(list))
+	for _, m := range list {
+		out = append(out, protocol.MacroInfo{Name: m.Name, Steps: m.Steps, UpdatedAt: m.UpdatedAt})
+	}
+	return c.Reply(protocol.MacroListResult{ID: c.ID, Type: protocol.TypeMacroListResult, Macros: out})
+}
+
+func handleMacroSave(c *Context) error {
+	var m protocol.MacroSave
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	mac := Macro{Name: m.Name, Steps: m.Steps}
+	if err := ValidateMacro(c.Registry(), mac); err != nil {
+		return err
+	}
+	if err := SaveMacro(mac); err != nil {
+		return macroError("save", m.Name, err)
+	}
+	log.Printf("[macro] saved name=%q steps=%d session=%s", m.Name, len(m.Steps), c.Session.ID())
+	return c.Reply(protocol.Ack{ID: c.ID, Type: protocol.TypeAck, Of: c.Type})
+}
+
+func handleMacroDelete(c *Context) error {
+	var m protocol.MacroRef
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	if err := DeleteMacro(m.Name); err != nil {
+		return macroError("delete", m.Name, err)
+	}
+	log.Printf("[macro] deleted name=%q session=%s", m.Name, c.Session.ID())
+	return c.Reply(protocol.Ack{ID: c.ID, Type: protocol.TypeAck, Of: c.Type})
+}
+
+// handleMacroRun ejecuta la macro como un batch (responde batch_done o error).
+func handleMacroRun(c *Context) error {
+	var m protocol.MacroRef
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	mac, err := GetMacro(m.Name)
+	if err != nil {
+		return macroError("run", m.Name, err)
+	}
+	log.Printf("[macro] run name=%q id=%s", mac.Name, c.ID)
+	steps, err := parseBatch(c.Registry(), c.Driver, mac.Steps)
+	if err != nil {
+		return err
+	}
+	return startSteps(c, c.ID, steps)
+}
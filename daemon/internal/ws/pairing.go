@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+)
+
+// Código de emparejamiento: lo que va en el QR en vez del token. Hay uno solo
+// vigente a la vez, vence a los pocos minutos y se gasta con la primera
+// conexión que lo presenta (?pair=<secreto o código>). Esa conexión sólo
+// puede hacer pair_request, que le da la credencial propia del dispositivo.
+
+// DefaultPairingTTL: cuánto vive un código si no se pide otra cosa.
+const DefaultPairingTTL = 5 * time.Minute
+
+// maxPairingAttempts: códigos equivocados antes de anular el vigente (el
+// numérico tiene sólo 6 dígitos).
+const maxPairingAttempts = 5
+
+// PairingCode es un código de emparejamiento. Secret va en el QR; Code es la
+// versión corta para tipear en el teléfono. Cualquiera de los dos sirve.
+type PairingCode struct {
+	Secret    string
+	Code      string
+	ExpiresAt time.Time
+}
+
+// Remaining devuelve cuánto le queda (0 si ya venció).
+func (p PairingCode) Remaining() time.Duration {
+	if d := time.Until(p.ExpiresAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+var (
+	pairingMu       sync.Mutex
+	pairing         *PairingCode
+	pairingAttempts int
+)
+
+// NewPairingCode crea un código que vence en ttl (DefaultPairingTTL si es 0)
+// y anula el anterior.
+func NewPairingCode(ttl time.Duration) (PairingCode, error) {
+	if ttl <= 0 {
+		ttl = DefaultPairingTTL
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return PairingCode{}, err
+	}
+	p := PairingCode{
+		Secret:    randomURLSafe(18),
+		Code:      fmt.Sprintf("%06d", n.Int64()),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	pairingMu.Lock()
+	pairing = &p
+	pairingAttempts = 0
+	pairingMu.Unlock()
+
+	log.Printf("[ws] pairing code issued expires=%s", p.ExpiresAt.Format(time.RFC3339))
+	return p, nil
+}
+
+// CurrentPairingCode devuelve el código vigente, si hay.
+func CurrentPairingCode() (PairingCode, bool) {
+	pairingMu.Lock()
+	defer pairingMu.Unlock()
+	if pairing == nil || pairing.Remaining() == 0 {
+		return PairingCode{}, false
+	}
+	return *pairing, true
+}
+
+// CancelPairingCode anula el código vigente (p.ej. al cerrar el diálogo del QR).
+func CancelPairingCode() {
+	pairingMu.Lock()
+	defer pairingMu.Unlock()
+	pairing = nil
+}
+
+// consumePairingCode gasta el código vigente si got es su secreto o su código
+// numérico. Demasiados intentos errados lo anulan.
+func consumePairingCode(got, remote string) bool {
+	pairingMu.Lock()
+	defer pairingMu.Unlock()
+
+	if pairing == nil || pairing.Remaining() == 0 {
+		pairing = nil
+		log.Printf("[ws] pairing rejected remote=%s: no hay código vigente", remote)
+		audit.Recordf(audit.LoginFailed, "", "", remote, "código de emparejamiento sin código vigente")
+		return false
+	}
+	okSecret := subtle.ConstantTimeCompare([]byte(got), []byte(pairing.Secret)) == 1
+	okCode := subtle.ConstantTimeCompare([]byte(got), []byte(pairing.Code)) == 1
+	if okSecret || okCode {
+		pairing = nil
+		log.Printf("[ws] pairing code used remote=%s", remote)
+		return true
+	}
+
+	pairingAttempts++
+	log.Printf("[ws] pairing rejected remote=%s attempts=%d", remote, pairingAttempts)
+	details := "código de emparejamiento inválido"
+	if pairingAttempts >= maxPairingAttempts {
+		pairing = nil
+		details += fmt.Sprintf(", código anulado tras %d intentos", pairingAttempts)
+	}
+	audit.Recordf(audit.LoginFailed, "", "", remote, "%s", details)
+	return false
+}
@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+func newTestPairingCode(t *testing.T) PairingCode {
+	t.Helper()
+	p, err := NewPairingCode(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CancelPairingCode)
+	return p
+}
+
+func TestPairingCodeSingleUse(t *testing.T) {
+	for _, use := range []string{"secret", "code"} {
+		p := newTestPairingCode(t)
+		got := p.Secret
+		if use == "code" {
+			got = p.Code
+		}
+		if !consumePairingCode(got, "test") {
+			t.Fatalf("%s: primer uso rechazado", use)
+		}
+		if consumePairingCode(got, "test") {
+			t.Errorf("%s: se usó dos veces", use)
+		}
+		if _, ok := CurrentPairingCode(); ok {
+			t.Errorf("%s: sigue vigente después de usarse", use)
+		}
+	}
+}
+
+func TestPairingCodeExpires(t *testing.T) {
+	p := newTestPairingCode(t)
+	pairingMu.Lock()
+	pairing.ExpiresAt = time.Now().Add(-time.Second)
+	pairingMu.Unlock()
+
+	if _, ok := CurrentPairingCode(); ok {
+		t.Error("vencido pero vigente")
+	}
+	if consumePairingCode(p.Secret, "test") {
+		t.Error("se aceptó un código vencido")
+	}
+}
+
+func TestPairingCodeVoidedAfterAttempts(t *testing.T) {
+	p := newTestPairingCode(t)
+	for i := 1; i < maxPairingAttempts; i++ {
+		if consumePairingCode("000000x", "test") {
+			t.Fatal("código inválido aceptado")
+		}
+	}
+	if _, ok := CurrentPairingCode(); !ok {
+		t.Fatalf("anulado antes de %d intentos", maxPairingAttempts)
+	}
+	consumePairingCode("000000x", "test")
+	if consumePairingCode(p.Code, "test") {
+		t.Errorf("el código sirvió después de %d intentos errados", maxPairingAttempts)
+	}
+
+	// uno nuevo arranca con los intentos en cero
+	p = newTestPairingCode(t)
+	for i := 1; i < maxPairingAttempts; i++ {
+		consumePairingCode("000000x", "test")
+	}
+	if !consumePairingCode(p.Code, "test") {
+		t.Error("el código nuevo heredó los intentos del anterior")
+	}
+}
+
+// Con ?pair= la conexión sólo sirve para pair_request; la credencial que
+// devuelve entra sin código y sin volver a pedir aprobación.
+func TestPairingUpgrade(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{
+		RequireTLS: true, RequireToken: true, Token: "compartido",
+		RequireDeviceCredential: true, RequireApproval: true,
+	})
+	p := newTestPairingCode(t)
+
+	if _, err := connect(t, srv, "pair=123"); err == nil {
+		t.Error("upgrade con un código equivocado")
+	}
+	conn := mustConnect(t, srv, "pair="+p.Code)
+	if _, err := connect(t, srv, "pair="+p.Code); err == nil {
+		t.Error("el código sirvió para una segunda conexión")
+	}
+
+	resp := roundTrip(t, conn, map[string]any{"id": "m", "type": protocol.TypeMouseMove, "dx": 1, "ack": true})
+	if resp["code"] != protocol.CodePairingRequired {
+		t.Errorf("mouse_move con el código -> %v", resp)
+	}
+	resp = roundTrip(t, conn, map[string]any{"id": "p", "type": protocol.TypePairRequest, "name": "Teléfono de prueba"})
+	cred, _ := resp["credential"].(string)
+	if resp["type"] != protocol.TypePairOk || cred == "" {
+		t.Fatalf("pair_request -> %v", resp)
+	}
+	deviceID, _ := resp["device_id"].(string)
+	t.Cleanup(func() { _, _ = DeleteDevice(deviceID) })
+
+	dev := mustConnect(t, srv, "token="+cred)
+	resp = roundTrip(t, dev, map[string]any{"id": "m", "type": protocol.TypeMouseMove, "dx": 1, "ack": true})
+	if resp["type"] != protocol.TypeAck {
+		t.Errorf("mouse_move con la credencial -> %v", resp)
+	}
+	waitCalls(t, fake, "MoveMouse", 1)
+
+	// el token compartido ya sólo empareja
+	shared := mustConnect(t, srv, "token=compartido")
+	resp = roundTrip(t, shared, map[string]any{"id": "m", "type": protocol.TypeMouseMove, "dx": 1, "ack": true})
+	if resp["code"] != protocol.CodePairingRequired {
+		t.Errorf("mouse_move con el token compartido -> %v", resp)
+	}
+}
@@ -0,0 +1,223 @@
+package ws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Permisos por usuario. Se guardan en users.permissions como lista separada
+// por comas ("pointer,keyboard"); la sesión los carga al hacer login.
+const (
+	PermMedia    = "media"    // sólo teclas multimedia (volumen, play/pausa, pista)
+	PermPointer  = "pointer"  // mouse
+	PermKeyboard = "keyboard" // teclado completo (incluye multimedia) y captura
+	PermApps     = "apps"     // apps_list / app_action
+	PermMacros   = "macros"   // guardar, borrar y grabar macros
+	PermAdmin    = "admin"    // todo, incluidos tipos de terceros
+
+	// PermAny: el handler no exige permiso (release_all, batch, macro_run...).
+	// Lo que ejecutan por dentro se revisa paso a paso.
+	PermAny = "*"
+)
+
+// AllPermissions en el orden en que se muestran en la UI.
+var AllPermissions = []string{PermMedia, PermPointer, PermKeyboard, PermApps, PermMacros, PermAdmin}
+
+// PermissionPreset es un conjunto con nombre para el editor de la UI.
+type PermissionPreset struct {
+	Name  string
+	Perms Permissions
+}
+
+// PermissionPresets: los casos típicos ("el teléfono de los chicos sólo sube el volumen").
+var PermissionPresets = []PermissionPreset{
+	{Name: "Sólo multimedia", Perms: Permissions{PermMedia}},
+	{Name: "Puntero y teclado", Perms: Permissions{PermPointer, PermKeyboard}},
+	{Name: "Control de apps", Perms: Permissions{PermApps}},
+	{Name: "Admin", Perms: Permissions{PermAdmin}},
+}
+
+// Permissions es un conjunto de permisos (sin repetidos, ordenado).
+type Permissions []string
+
+// ParsePermissions lee "pointer, keyboard". Vacío = sin permisos.
+func ParsePermissions(s string) (Permissions, error) {
+	var out Permissions
+	for _, p := range strings.Split(s, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if !knownPermission(p) {
+			return nil, fmt.Errorf("permiso desconocido: %q", p)
+		}
+		out = append(out, p)
+	}
+	return out.normalize(), nil
+}
+
+func knownPermission(p string) bool {
+	for _, k := range AllPermissions {
+		if p == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps Permissions) normalize() Permissions {
+	seen := map[string]bool{}
+	out := Permissions{}
+	for _, p := range ps {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Has indica si el conjunto incluye p (admin incluye todo).
+func (ps Permissions) Has(p string) bool {
+	for _, x := range ps {
+		if x == p || x == PermAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps Permissions) String() string { return strings.Join(ps, ",") }
+
+// requiredPermission: lo declarado al registrar o, si no, lo que implica la
+// Feature. Sin nada, sólo admin (handlers de terceros que no dijeron nada).
+func requiredPermission(opts HandlerOptions) string {
+	if opts.Permission != "" {
+		return opts.Permission
+	}
+	switch opts.Feature {
+	case input.FeatureMouse:
+		return PermPointer
+	case input.FeatureKeyboard, input.FeatureCapture:
+		return PermKeyboard
+	case input.FeatureApps:
+		return PermApps
+	}
+	return PermAdmin
+}
+
+// sessionPermissions: con login, los del usuario; sin login, los que el server
+// da a cualquiera (SecurityConfig.GuestPermissions, nil = admin).
+func sessionPermissions(c *Context) Permissions {
+	if c.AccountRequired() {
+		return c.Session.Permissions()
+	}
+	if c.sec.GuestPermissions == nil {
+		return Permissions{PermAdmin}
+	}
+	return c.sec.GuestPermissions
+}
+
+// checkSessionPermission es el check del middleware RequirePermission.
+func checkSessionPermission(c *Context) error {
+	need := requiredPermission(c.opts)
+	if need == PermAny {
+		return nil
+	}
+	perms := sessionPermissions(c)
+	if perms.Has(need) {
+		return nil
+	}
+	if need == PermKeyboard && perms.Has(PermMedia) && isMediaKeyMessage(c) {
+		return nil
+	}
+	auditDenied(c, need)
+	return Errorf(protocol.CodePermission, "%s requiere %q", c.Type, need)
+}
+
+// deniedAuditEvery: un teléfono "sólo multimedia" que intenta mover el mouse
+// manda decenas de mouse_move por segundo; se audita uno por tipo y minuto.
+const deniedAuditEvery = time.Minute
+
+func auditDenied(c *Context, need string) {
+	se, now := c.Session, time.Now()
+	sessionsMu.Lock()
+	if last, ok := se.deniedAt[c.Type]; ok && now.Sub(last) < deniedAuditEvery {
+		sessionsMu.Unlock()
+		return
+	}
+	if se.deniedAt == nil {
+		se.deniedAt = map[string]time.Time{}
+	}
+	se.deniedAt[c.Type] = now
+	user := se.username
+	sessionsMu.Unlock()
+
+	audit.Recordf(audit.PermissionDenied, se.ID(), user, se.RemoteAddr(), "type=%s requiere=%s", c.Type, need)
+}
+
+// RequirePermission rechaza con PERMISSION_DENIED lo que los permisos de la
+// sesión no cubren, antes de que llegue al driver. Se loguea cada rechazo.
+var RequirePermission = CheckPermission(checkSessionPermission)
+
+// ---- teclas multimedia ----
+
+// isMediaSpec: el KeySpec es, según el catálogo, una tecla multimedia. Se
+// resuelve igual que en el driver (input.LookupSpec), así lo que pasa el
+// permiso es lo mismo que se termina tecleando.
+func isMediaSpec(k input.KeySpec) bool {
+	info, ok := input.LookupSpec(k)
+	return ok && info.Group == input.GroupMedia
+}
+
+// isMediaKeyMessage: el mensaje de teclado es una sola tecla multimedia
+// (key*, key*_vk, input_key_*). Hotkeys y texto nunca lo son.
+func isMediaKeyMessage(c *Context) bool {
+	switch c.Type {
+	case protocol.TypeKey, protocol.TypeKeyDown, protocol.TypeKeyUp:
+		var m protocol.Key
+		if c.Decode(&m) != nil {
+			return false
+		}
+		k, ok := input.LookupKey(m.Key)
+		return ok && k.Group == input.GroupMedia
+	case protocol.TypeKeyVK, protocol.TypeKeyDownVK, protocol.TypeKeyUpVK:
+		var m protocol.KeyVK
+		return c.Decode(&m) == nil && isMediaSpec(m.Key)
+	case protocol.TypeInputKeyTap, protocol.TypeInputKeyDown, protocol.TypeInputKeyUp:
+		var m protocol.InputKeyFlat
+		return c.Decode(&m) == nil && isMediaSpec(m.KeySpec())
+	}
+	return false
+}
+
+// ---- sesiones ----
+
+// Permissions devuelve los permisos cargados al hacer login.
+func (se *Session) Permissions() Permissions {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.perms
+}
+
+// SetUserPermissions aplica en caliente los permisos nuevos de username a
+// sus sesiones abiertas (la UI lo llama después de guardarlos en la DB).
+func SetUserPermissions(username string, perms Permissions) int {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	n := 0
+	for _, se := range sessions {
+		if se.authed && strings.EqualFold(se.username, username) {
+			se.perms = perms
+			n++
+		}
+	}
+	return n
+}
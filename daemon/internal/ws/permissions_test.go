@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Una sesión "sólo multimedia" no puede colar otra tecla con un VK multimedia
+// y el scan code de otra (el driver de Linux resolvía por scan).
+func TestMediaOnlyKeySpec(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{GuestPermissions: Permissions{PermMedia}})
+	conn := mustConnect(t, srv, "")
+
+	cases := []struct {
+		key map[string]any
+		ok  bool
+	}{
+		{map[string]any{"vk": 0xAD, "ext": true}, true},
+		{map[string]any{"vk": 0xAD, "scan": 0x1E}, true}, // manda el VK: mute, no A
+		{map[string]any{"vk": 0xB3}, true},
+		{map[string]any{"vk": 0x41}, false},
+		{map[string]any{"scan": 0x1E}, false},
+		{map[string]any{"vk": 0xFF, "scan": 0x1E}, false},
+		{map[string]any{"vk": 0xA2}, false}, // ctrl
+	}
+	for i, c := range cases {
+		id := string(rune('a' + i))
+		resp := roundTrip(t, conn, map[string]any{"id": id, "type": protocol.TypeKeyDownVK, "key": c.key, "ack": true})
+		if got := resp["type"] == protocol.TypeAck; got != c.ok {
+			t.Errorf("key_down_vk %v -> %v", c.key, resp)
+		}
+		if !c.ok && resp["code"] != protocol.CodePermission {
+			t.Errorf("key_down_vk %v: code = %v", c.key, resp["code"])
+		}
+	}
+
+	// lo que llegó al driver es multimedia también para el driver
+	for _, call := range fake.Calls() {
+		k := call.Args[0].(input.KeySpec)
+		if info, ok := input.LookupSpec(k); !ok || info.Group != input.GroupMedia {
+			t.Errorf("llegó al driver %+v = %s", k, info.Name)
+		}
+	}
+	waitCalls(t, fake, "KeyDownVK", 3)
+
+	// y por nombre
+	for id, key := range map[string]string{"n1": "mute", "n2": "a"} {
+		resp := roundTrip(t, conn, map[string]any{"id": id, "type": protocol.TypeKey, "key": key, "ack": true})
+		if got := resp["type"] == protocol.TypeAck; got != (key == "mute") {
+			t.Errorf("key %q -> %v", key, resp)
+		}
+	}
+}
+
+// startAccountServer levanta un server con TLS y login obligatorio.
+func startAccountServer(t *testing.T) (*Server, *input.Fake) {
+	t.Helper()
+	return startServer(t, SecurityConfig{RequireTLS: true, RequireAccount: true})
+}
+
+// allowed manda msg (con ack) y dice si se aceptó; si no, exige PERMISSION_DENIED.
+func allowed(t *testing.T, conn *websocket.Conn, msg map[string]any) bool {
+	t.Helper()
+	msg["ack"] = true
+	resp := roundTrip(t, conn, msg)
+	if resp["type"] != protocol.TypeError {
+		return true
+	}
+	if resp["code"] != protocol.CodePermission {
+		t.Errorf("%v -> %v", msg, resp)
+	}
+	return false
+}
+
+func TestPermissionsByRole(t *testing.T) {
+	srv, fake := startAccountServer(t)
+
+	mouse := func(id string) map[string]any {
+		return map[string]any{"id": id, "type": protocol.TypeMouseMove, "dx": 1, "dy": 1}
+	}
+	key := func(id, k string) map[string]any {
+		return map[string]any{"id": id, "type": protocol.TypeKey, "key": k}
+	}
+	hotkey := func(id string) map[string]any {
+		return map[string]any{"id": id, "type": protocol.TypeHotkey, "mods": []string{"ctrl"}, "key": "vol_up"}
+	}
+	apps := func(id string) map[string]any {
+		return map[string]any{"id": id, "type": protocol.TypeAppsList}
+	}
+	batch := func(id string) map[string]any {
+		return map[string]any{"id": id, "type": protocol.TypeBatch, "steps": []map[string]any{
+			{"type": protocol.TypeKey, "key": "vol_up"},
+			{"type": protocol.TypeMouseMove, "dx": 1, "dy": 1},
+		}}
+	}
+
+	cases := []struct {
+		user  string
+		perms Permissions
+		want  map[string]bool // por tipo de prueba
+	}{
+		{"perm_media", Permissions{PermMedia}, map[string]bool{"mouse": false, "media": true, "letter": false, "hotkey": false, "apps": false, "batch": false}},
+		{"perm_pointer", Permissions{PermPointer}, map[string]bool{"mouse": true, "media": false, "letter": false, "hotkey": false, "apps": false, "batch": false}},
+		{"perm_kbd", Permissions{PermPointer, PermKeyboard}, map[string]bool{"mouse": true, "media": true, "letter": true, "hotkey": true, "apps": false, "batch": true}},
+		{"perm_apps", Permissions{PermApps}, map[string]bool{"mouse": false, "media": false, "letter": false, "hotkey": false, "apps": true, "batch": false}},
+		{"perm_admin", Permissions{PermAdmin}, map[string]bool{"mouse": true, "media": true, "letter": true, "hotkey": true, "apps": true, "batch": true}},
+		{"perm_none", Permissions{}, map[string]bool{"mouse": false, "media": false, "letter": false, "hotkey": false, "apps": false, "batch": false}},
+	}
+	for _, c := range cases {
+		t.Run(c.user, func(t *testing.T) {
+			addTestUser(t, c.user, "secreto", c.perms)
+			conn := mustConnect(t, srv, "")
+			if resp := login(t, conn, c.user, "secreto"); resp["type"] != protocol.TypeAuthOk {
+				t.Fatalf("login -> %v", resp)
+			}
+			fake.Reset()
+
+			got := map[string]bool{
+				"mouse":  allowed(t, conn, mouse("m")),
+				"media":  allowed(t, conn, key("v", "vol_up")),
+				"letter": allowed(t, conn, key("l", "a")),
+				"hotkey": allowed(t, conn, hotkey("h")),
+				"apps":   allowed(t, conn, apps("p")),
+			}
+			// el batch se revisa entero antes de ejecutar: o todo o nada
+			resp := roundTrip(t, conn, batch("b"))
+			got["batch"] = resp["type"] == protocol.TypeBatchDone
+			if !got["batch"] && resp["code"] != protocol.CodePermission {
+				t.Errorf("batch -> %v", resp)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("permitido = %v, want %v", got, c.want)
+			}
+
+			// lo rechazado no llegó al driver
+			n := 0
+			for _, ok := range c.want {
+				if ok {
+					n++
+				}
+			}
+			if c.want["batch"] {
+				n++ // el batch hace dos llamadas
+			}
+			if got := calls(fake); len(got) != n {
+				t.Errorf("llamadas al driver = %q, want %d", got, n)
+			}
+		})
+	}
+}
+
+func TestPermissionsBeforeLoginAndHotUpdate(t *testing.T) {
+	srv, fake := startAccountServer(t)
+	addTestUser(t, "perm_hot", "secreto", Permissions{PermMedia})
+	conn := mustConnect(t, srv, "")
+
+	resp := roundTrip(t, conn, map[string]any{"id": "1", "type": protocol.TypeKey, "key": "vol_up", "ack": true})
+	if resp["code"] != protocol.CodeAuthRequired {
+		t.Errorf("sin login -> %v", resp)
+	}
+	if resp := login(t, conn, "perm_hot", "secreto"); resp["type"] != protocol.TypeAuthOk {
+		t.Fatalf("login -> %v", resp)
+	}
+	if allowed(t, conn, map[string]any{"id": "2", "type": protocol.TypeMouseMove, "dx": 1}) {
+		t.Error("media movió el mouse")
+	}
+
+	// la UI cambia los permisos: aplican a la sesión abierta
+	if n := SetUserPermissions("perm_hot", Permissions{PermPointer}); n != 1 {
+		t.Errorf("SetUserPermissions tocó %d sesiones", n)
+	}
+	if !allowed(t, conn, map[string]any{"id": "3", "type": protocol.TypeMouseMove, "dx": 1}) {
+		t.Error("pointer no pudo mover el mouse")
+	}
+	if allowed(t, conn, map[string]any{"id": "4", "type": protocol.TypeKey, "key": "vol_up"}) {
+		t.Error("pointer sigue con multimedia")
+	}
+	if got := calls(fake); !reflect.DeepEqual(got, []string{"MoveMouse[1 0]"}) {
+		t.Errorf("calls = %q", got)
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	ps, err := ParsePermissions(" Keyboard, pointer,,keyboard ")
+	if err != nil || !reflect.DeepEqual(ps, Permissions{PermKeyboard, PermPointer}) {
+		t.Errorf("ParsePermissions = %v, %v", ps, err)
+	}
+	if _, err := ParsePermissions("pointer,root"); err == nil {
+		t.Error("permiso desconocido aceptado")
+	}
+	if !(Permissions{PermAdmin}).Has(PermApps) || (Permissions{PermMedia}).Has(PermKeyboard) {
+		t.Error("Has")
+	}
+}
@@ -0,0 +1,169 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// DefaultQueueSize: cuántos mensajes puede tener pendientes una sesión antes de
+// empezar a descartar. Con el PC cargado un burst de mouse_move se fusiona en
+// vez de ocupar lugares, así que esto sólo se llena si el driver se trabó.
+const DefaultQueueSize = 256
+
+// queued es un mensaje esperando al worker. move != nil si es un mouse_move
+// sin id/ack, que se puede fusionar con el siguiente; es el mismo c.pre que
+// lee el handler.
+type queued struct {
+	c    *Context
+	move *protocol.MouseMove
+}
+
+// inputQueue desacopla la lectura del socket de la ejecución en el driver:
+// el read loop encola y un worker por sesión despacha en orden.
+type inputQueue struct {
+	mu      sync.Mutex
+	items   []queued
+	max     int
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+	dropped int64
+	merged  int64
+}
+
+func newInputQueue(max int) *inputQueue {
+	if max <= 0 {
+		max = DefaultQueueSize
+	}
+	return &inputQueue{max: max, wake: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// mergeable decodifica una sola vez el mouse_move fusionable y lo deja en
+// c.pre (los frames binarios ya vienen decodificados).
+func mergeable(c *Context) *protocol.MouseMove {
+	if c.Type != protocol.TypeMouseMove || c.base.ID != "" || c.base.Ack {
+		return nil
+	}
+	if pre, ok := c.pre.(*protocol.MouseMove); ok {
+		return pre
+	}
+	m := new(protocol.MouseMove)
+	if err := json.Unmarshal(c.Raw, m); err != nil {
+		return nil // que el handler informe el error
+	}
+	c.pre = m
+	return m
+}
+
+// push encola c. Si el último pendiente es un mouse_move fusionable y c también,
+// se suman los deltas. Devuelve false si la cola estaba llena y c se descartó.
+func (q *inputQueue) push(c *Context) bool {
+	it := queued{c: c, move: mergeable(c)}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+
+	if n := len(q.items); n > 0 && it.move != nil {
+		if last := &q.items[n-1]; last.move != nil {
+			last.move.Dx += it.move.Dx
+			last.move.Dy += it.move.Dy
+			q.merged++
+			return true
+		}
+	}
+
+	if len(q.items) >= q.max {
+		// lo primero que sacrificamos es movimiento viejo; teclas nunca
+		if it.move != nil || !q.evictMoveLocked() {
+			q.dropped++
+			return false
+		}
+		q.dropped++
+	}
+
+	q.items = append(q.items, it)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *inputQueue) evictMoveLocked() bool {
+	for i, it := range q.items {
+		if it.move != nil {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *inputQueue) pop() (*Context, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			it := q.items[0]
+			q.items[0] = queued{}
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return it.c, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.wake
+	}
+}
+
+// close descarta lo pendiente y hace terminar al worker.
+func (q *inputQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.items = nil
+	}
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stats devuelve (pendientes, descartados, fusionados).
+func (q *inputQueue) stats() (depth int, dropped, merged int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.dropped, q.merged
+}
+
+// run es el worker de la sesión: despacha en orden hasta que se cierre la cola.
+func (q *inputQueue) run(reg *Registry) {
+	defer close(q.done)
+	for {
+		c, ok := q.pop()
+		if !ok {
+			return
+		}
+		dispatchSafe(reg, c)
+	}
+}
+
+func dispatchSafe(reg *Registry, c *Context) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[ws] PANIC in handler type=%s id=%s: %v\n%s", c.Type, c.ID, rec, string(debug.Stack()))
+			_ = c.Conn.WriteJSON(c.ErrorFor(&Error{Code: protocol.CodeInternal, Detail: "panic in handler"}))
+		}
+	}()
+	reg.dispatch(c)
+}
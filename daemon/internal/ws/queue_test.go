@@ -0,0 +1,38 @@
+package ws
+
+import (
+	"testing"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Los mouse_move seguidos se suman sobre el mismo c.pre que lee el handler.
+func TestQueueMergesMoves(t *testing.T) {
+	q := newInputQueue(0)
+	push := func(c *Context) {
+		t.Helper()
+		if !q.push(c) {
+			t.Fatal("push descartó")
+		}
+	}
+	move := func(id string, raw string, pre any) *Context {
+		c := &Context{ID: id, Type: protocol.TypeMouseMove, base: protocol.Base{ID: id, Type: protocol.TypeMouseMove}, pre: pre}
+		if raw != "" {
+			c.Raw = []byte(raw)
+		}
+		return c
+	}
+	push(move("", `{"type":"mouse_move","dx":1,"dy":2}`, nil))
+	push(move("", "", &protocol.MouseMove{Type: protocol.TypeMouseMove, Dx: 10, Dy: -1}))
+	push(move("", `{"type":"mouse_move","dx":100,"dy":0}`, nil))
+	push(move("con-id", `{"id":"con-id","type":"mouse_move","dx":5,"dy":5}`, nil))
+
+	if depth, _, merged := q.stats(); depth != 2 || merged != 2 {
+		t.Fatalf("depth=%d merged=%d, want 2 y 2", depth, merged)
+	}
+	c, _ := q.pop()
+	var m protocol.MouseMove
+	if err := c.Decode(&m); err != nil || m.Dx != 111 || m.Dy != 1 {
+		t.Errorf("fusionado = %+v (%v)", m, err)
+	}
+}
@@ -0,0 +1,210 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Grabación de macros: entre macro_record_start y macro_record_stop, el
+// middleware Record anota cada mensaje de entrada que la sesión ejecutó bien
+// (JSON, binario o pasos de batch) con el momento en que ocurrió. Lo grabado
+// respeta los límites de un batch (maxBatchSteps, maxStepDelay y
+// maxBatchDuration) para que el borrador siempre se pueda guardar y correr.
+
+// mergeMoveWindow: con merge_moves, los mouse_move seguidos que llegan con
+// menos de esto entre sí se suman en un solo paso.
+const mergeMoveWindow = 50 * time.Millisecond
+
+type recEvent struct {
+	at     time.Duration // desde el start
+	typ    string
+	fields map[string]json.RawMessage
+}
+
+type recorder struct {
+	mu         sync.Mutex
+	started    time.Time
+	mergeMoves bool
+	events     []recEvent
+	truncated  bool
+}
+
+// Record es el middleware que alimenta la grabación de la sesión (si hay una).
+// Sólo graba mensajes de entrada (los mismos que acepta batch) que no fallaron.
+func Record(next Handler) Handler {
+	return func(c *Context) error {
+		err := next(c)
+		if err == nil && batchAllowed(handlerEntry{opts: c.opts}) {
+			if rec := c.Session.recorder(); rec != nil {
+				rec.add(c)
+			}
+		}
+		return err
+	}
+}
+
+func (r *recorder) add(c *Context) {
+	raw := c.Raw
+	if c.pre != nil {
+		// frame binario o mouse_move ya fusionado: se guarda como el JSON
+		// equivalente
+		b, err := json.Marshal(c.pre)
+		if err != nil {
+			return
+		}
+		raw = b
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return
+	}
+	// id/ack/delay_ms son de la transmisión, no del paso
+	delete(fields, "id")
+	delete(fields, "ack")
+	delete(fields, "delay_ms")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev := recEvent{at: time.Since(r.started), typ: c.Type, fields: fields}
+	// con merge_moves se suman acá, así el tope cuenta pasos de la macro
+	if n := len(r.events); r.mergeMoves && n > 0 && ev.typ == protocol.TypeMouseMove && r.events[n-1].typ == protocol.TypeMouseMove {
+		last := &r.events[n-1]
+		if ev.at-last.at <= mergeMoveWindow {
+			last.fields["dx"] = addDelta(last.fields["dx"], ev.fields["dx"])
+			last.fields["dy"] = addDelta(last.fields["dy"], ev.fields["dy"])
+			return
+		}
+	}
+	if r.truncated || len(r.events) >= maxBatchSteps {
+		r.truncated = true
+		return
+	}
+	r.events = append(r.events, ev)
+}
+
+// draft arma los pasos de la macro con delay_ms relativo al paso anterior.
+// Una pausa larga queda en maxStepDelay; lo que pasa de maxBatchDuration se
+// corta (truncated).
+func (r *recorder) draft() (steps []json.RawMessage, dur time.Duration, truncated bool) {
+	r.mu.Lock()
+	events := append([]recEvent(nil), r.events...)
+	truncated = r.truncated
+	dur = time.Since(r.started)
+	r.mu.Unlock()
+
+	steps = make([]json.RawMessage, 0, len(events))
+	var prev, total time.Duration
+	for _, ev := range events {
+		d := min(ev.at-prev, maxStepDelay)
+		prev = ev.at
+		if total+d > maxBatchDuration {
+			truncated = true
+			break
+		}
+		total += d
+		if ms := d.Milliseconds(); ms > 0 {
+			ev.fields["delay_ms"], _ = json.Marshal(ms)
+		}
+		b, err := json.Marshal(ev.fields)
+		if err != nil {
+			continue
+		}
+		steps = append(steps, b)
+	}
+	return steps, dur, truncated
+}
+
+func addDelta(a, b json.RawMessage) json.RawMessage {
+	var x, y int32
+	_ = json.Unmarshal(a, &x)
+	_ = json.Unmarshal(b, &y)
+	out, _ := json.Marshal(x + y)
+	return out
+}
+
+// ---- estado por sesión ----
+
+func (se *Session) recorder() *recorder {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.rec
+}
+
+func (se *Session) startRecording(mergeMoves bool) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if se.rec != nil {
+		return false
+	}
+	se.rec = &recorder{started: time.Now(), mergeMoves: mergeMoves}
+	return true
+}
+
+func (se *Session) stopRecording() *recorder {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	rec := se.rec
+	se.rec = nil
+	return rec
+}
+
+// ---- handlers ----
+
+func handleMacroRecordStart(c *Context) error {
+	var m protocol.MacroRecordStart
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	if !c.Session.startRecording(m.MergeMoves) {
+		return Errorf(protocol.CodeBadRequest, "ya hay una grabación activa")
+	}
+	log.Printf("[macro] record start session=%s merge_moves=%v", c.Session.ID(), m.MergeMoves)
+	return nil
+}
+
+// handleMacroRecordStop termina la grabación y responde macro_draft con los
+// pasos; con "save": true y un nombre también la guarda en el store.
+func handleMacroRecordStop(c *Context) error {
+	var m protocol.MacroRecordStop
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	rec := c.Session.stopRecording()
+	if rec == nil {
+		return Errorf(protocol.CodeBadRequest, "no hay grabación activa")
+	}
+	steps, dur, truncated := rec.draft()
+	log.Printf("[macro] record stop session=%s steps=%d duration=%s truncated=%v", c.Session.ID(), len(steps), dur, truncated)
+
+	draft := protocol.MacroDraft{
+		ID:         c.ID,
+		Type:       protocol.TypeMacroDraft,
+		Name:       m.Name,
+		Steps:      steps,
+		DurationMs: dur.Milliseconds(),
+		Truncated:  truncated,
+	}
+	if m.Save {
+		// si no se puede guardar, el borrador vuelve igual (con el motivo)
+		mac := Macro{Name: m.Name, Steps: steps}
+		err := ValidateMacro(c.Registry(), mac)
+		if err == nil {
+			if err = SaveMacro(mac); err != nil {
+				err = macroError("save", m.Name, err)
+			}
+		}
+		if err != nil {
+			log.Printf("[macro] recorded name=%q not saved: %v", m.Name, err)
+			resp := errorResponse(c.Session.Lang(), "", err)
+			draft.SaveError = &resp
+		} else {
+			draft.Saved = true
+			log.Printf("[macro] saved recorded name=%q steps=%d", m.Name, len(steps))
+		}
+	}
+	return c.Reply(draft)
+}
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+func recMove(dx int) *Context {
+	return &Context{Type: protocol.TypeMouseMove, Raw: json.RawMessage(fmt.Sprintf(`{"type":%q,"dx":%d,"dy":0}`, protocol.TypeMouseMove, dx))}
+}
+
+// Lo grabado siempre pasa parseBatch y checkBatchDuration.
+func TestRecorderBatchLimits(t *testing.T) {
+	r := &recorder{started: time.Now()}
+	for i := 0; i < maxBatchSteps+10; i++ {
+		r.add(recMove(1))
+	}
+	// pausas de 10 min: cada una queda en maxStepDelay y el total corta el borrador
+	for i := range r.events {
+		r.events[i].at = time.Duration(i) * 10 * time.Minute
+	}
+	steps, _, truncated := r.draft()
+	if !truncated {
+		t.Error("no se marcó truncated")
+	}
+	if want := int(maxBatchDuration/maxStepDelay) + 1; len(steps) != want {
+		t.Errorf("pasos = %d, want %d", len(steps), want)
+	}
+	parsed, err := parseBatch(NewRegistry(), nil, steps)
+	if err != nil {
+		t.Fatalf("parseBatch del borrador: %v", err)
+	}
+	if err := checkBatchDuration(parsed); err != nil {
+		t.Errorf("checkBatchDuration del borrador: %v", err)
+	}
+
+	// sin pausas el tope es de pasos
+	r = &recorder{started: time.Now()}
+	for i := 0; i < maxBatchSteps+10; i++ {
+		r.add(recMove(1))
+	}
+	if steps, _, truncated := r.draft(); len(steps) != maxBatchSteps || !truncated {
+		t.Errorf("pasos = %d truncated = %v", len(steps), truncated)
+	}
+}
+
+// Con merge_moves el tope cuenta pasos ya sumados, no mensajes.
+func TestRecorderMergeMoves(t *testing.T) {
+	r := &recorder{started: time.Now(), mergeMoves: true}
+	for i := 0; i < maxBatchSteps+10; i++ {
+		r.add(recMove(2))
+	}
+	steps, _, truncated := r.draft()
+	if len(steps) >= maxBatchSteps || truncated {
+		t.Fatalf("pasos = %d truncated = %v", len(steps), truncated)
+	}
+	var dx int32
+	for _, raw := range steps {
+		var m protocol.MouseMove
+		if err := json.Unmarshal(raw, &m); err != nil {
+			t.Fatalf("paso %s: %v", raw, err)
+		}
+		dx += m.Dx
+	}
+	if dx != 2*(maxBatchSteps+10) {
+		t.Errorf("dx sumado = %d", dx)
+	}
+}
+
+// Si no se puede guardar, el borrador vuelve igual.
+func TestRecordStopKeepsDraftWhenSaveFails(t *testing.T) {
+	srv, _ := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	for _, m := range []map[string]any{
+		{"id": "s", "type": protocol.TypeMacroRecordStart, "ack": true},
+		{"id": "m", "type": protocol.TypeMouseMove, "dx": 5, "ack": true},
+	} {
+		if resp := roundTrip(t, conn, m); resp["type"] == protocol.TypeError {
+			t.Fatalf("%v -> %v", m, resp)
+		}
+	}
+	resp := roundTrip(t, conn, map[string]any{"id": "x", "type": protocol.TypeMacroRecordStop, "save": true, "name": ""})
+	steps, _ := resp["steps"].([]any)
+	saveErr, _ := resp["save_error"].(map[string]any)
+	if resp["type"] != protocol.TypeMacroDraft || resp["saved"] != false || len(steps) != 1 {
+		t.Fatalf("macro_record_stop -> %v", resp)
+	}
+	if saveErr["code"] != protocol.CodeBadRequest {
+		t.Errorf("save_error = %v", resp["save_error"])
+	}
+}
@@ -0,0 +1,294 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Writer es lo que un handler necesita para responder por la conexión.
+// Es seguro usarlo desde varias goroutines.
+type Writer interface {
+	WriteJSON(v any) error
+}
+
+// Handler atiende un tipo de mensaje. Si devuelve error (idealmente *Error),
+// el dispatcher responde "error" al cliente; si no, manda ack cuando se pidió.
+type Handler func(c *Context) error
+
+// Middleware envuelve un Handler (auth, logs, permisos, ...).
+type Middleware func(next Handler) Handler
+
+// HandlerOptions describe cómo se despacha un tipo de mensaje.
+type HandlerOptions struct {
+	// Feature que el driver debe soportar ("" = ninguna). Si el driver no la
+	// soporta el tipo no se anuncia en hello_ok y se responde UNSUPPORTED.
+	Feature input.Feature
+
+	// Public: se atiende antes del login (ping, hello, auth_login).
+	Public bool
+
+	// AllowPending: se atiende mientras el PC no aprobó la conexión (ping,
+	// hello, auth_resume). Public no alcanza: pair_request y auth_login
+	// también esperan la aprobación.
+	AllowPending bool
+
+	// Quiet: los errores sólo se informan si el cliente pidió ack
+	// (input de alta frecuencia: mouse/teclas). Si es false siempre se informan.
+	Quiet bool
+
+	// Permission que tiene que tener la sesión (Perm*). "" = el que implica
+	// Feature, o admin si no hay Feature. PermAny = cualquiera.
+	Permission string
+}
+
+type handlerEntry struct {
+	opts HandlerOptions
+	h    Handler
+}
+
+// Registry mapea type -> handler. Es seguro registrar mientras el server corre.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]handlerEntry
+	mws      []Middleware
+}
+
+// NewRegistry crea un registry con los handlers y middlewares incluidos
+// (Logging, RequireAuth, RequirePermission y Record). Terceros agregan los
+// suyos con Handle/Register/Use.
+func NewRegistry() *Registry {
+	r := NewEmptyRegistry()
+	r.Use(Logging, RequireAuth, RequirePermission, Record)
+	registerBuiltins(r)
+	return r
+}
+
+// NewEmptyRegistry crea un registry sin handlers ni middlewares.
+func NewEmptyRegistry() *Registry {
+	return &Registry{handlers: map[string]handlerEntry{}}
+}
+
+// DefaultRegistry es el que usa Start.
+var DefaultRegistry = NewRegistry()
+
+// Register agrega (o reemplaza) el handler de msgType.
+func (r *Registry) Register(msgType string, opts HandlerOptions, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = handlerEntry{opts: opts, h: h}
+}
+
+// Handle registra un handler sin opciones especiales (requiere login si aplica).
+func (r *Registry) Handle(msgType string, h Handler) {
+	r.Register(msgType, HandlerOptions{}, h)
+}
+
+// Remove quita el handler de msgType (p.ej. para deshabilitar app_action).
+func (r *Registry) Remove(msgType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.handlers, msgType)
+}
+
+// Use agrega middlewares. El primero agregado es el más externo.
+func (r *Registry) Use(mws ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mws = append(r.mws, mws...)
+}
+
+func (r *Registry) lookup(msgType string) (handlerEntry, []Middleware, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.handlers[msgType]
+	return e, r.mws, ok
+}
+
+// Types devuelve (ordenados) los tipos que este registry atiende con driver.
+func (r *Registry) Types(driver input.InputDriver) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.handlers))
+	for t, e := range r.handlers {
+		if e.opts.Feature == "" || input.Supports(driver, e.opts.Feature) {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// exec corre el handler de c.Type con los middlewares, sin responder nada.
+// ok es false si el tipo no existe o el driver no lo soporta.
+func (r *Registry) exec(c *Context) (e handlerEntry, ok bool, err error) {
+	e, mws, ok := r.lookup(c.Type)
+	if !ok || (e.opts.Feature != "" && !input.Supports(c.Driver, e.opts.Feature)) {
+		log.Printf("[ws] unsupported type=%s id=%s", c.Type, c.ID)
+		return e, false, &Error{Code: protocol.CodeUnsupported, Detail: c.Type}
+	}
+	c.public = e.opts.Public
+	c.opts = e.opts
+	h := e.h
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return e, true, h(c)
+}
+
+// dispatch ejecuta el mensaje c.Type y responde ack/error según corresponda.
+func (r *Registry) dispatch(c *Context) {
+	e, ok, err := r.exec(c)
+
+	reply := wantReply(c.base, c.Session.Acks())
+	if err != nil {
+		// los errores de gating (auth/unsupported) siempre se informan
+		if !ok || !e.opts.Quiet || reply || c.gated {
+			if werr := c.Conn.WriteJSON(c.ErrorFor(err)); werr != nil {
+				log.Printf("[ws] error write failed type=%s id=%s: %v", c.Type, c.ID, werr)
+			}
+		}
+		return
+	}
+	if reply && !c.replied {
+		_ = c.Conn.WriteJSON(protocol.Ack{ID: c.ID, Type: protocol.TypeAck, Of: c.Type})
+	}
+}
+
+// wantReply decide si un mensaje recibe ack/error. Sin id no hay respuesta;
+// mouse_move sólo con "ack": true explícito (es de alta frecuencia).
+func wantReply(b protocol.Base, sessionAcks bool) bool {
+	if b.ID == "" {
+		return false
+	}
+	if b.Ack {
+		return true
+	}
+	return sessionAcks && b.Type != protocol.TypeMouseMove
+}
+
+// ---- Context ----
+
+// Context es lo que recibe un Handler por cada mensaje.
+type Context struct {
+	ID   string
+	Type string
+	Raw  []byte // JSON tal cual llegó (nil si vino en un frame binario)
+
+	Session *Session
+	Conn    Writer
+	Driver  input.InputDriver
+
+	base     protocol.Base
+	pre      any // mensaje ya decodificado (frames binarios, mouse_move encolados)
+	sec      SecurityConfig
+	registry *Registry
+	opts     HandlerOptions
+	public   bool
+	gated    bool // un middleware de gating (auth/permisos) rechazó el mensaje
+	replied  bool
+}
+
+// Decode parsea el mensaje completo en v (un puntero a un tipo de protocol).
+// Si llegó como frame binario, copia el struct ya decodificado.
+func (c *Context) Decode(v any) error {
+	if c.pre != nil {
+		dst, src := reflect.ValueOf(v), reflect.ValueOf(c.pre)
+		if dst.Kind() != reflect.Pointer || dst.Type() != src.Type() {
+			return Errorf(protocol.CodeBadRequest, "%s no se puede leer como %T", c.Type, v)
+		}
+		dst.Elem().Set(src.Elem())
+		return nil
+	}
+	if err := json.Unmarshal(c.Raw, v); err != nil {
+		return &Error{Code: protocol.CodeBadRequest, Detail: err.Error()}
+	}
+	return nil
+}
+
+// Reply responde al cliente; con eso el dispatcher ya no manda ack.
+func (c *Context) Reply(v any) error {
+	c.replied = true
+	return c.Conn.WriteJSON(v)
+}
+
+// MarkReplied indica que la respuesta se mandará después (p.ej. desde una goroutine).
+func (c *Context) MarkReplied() { c.replied = true }
+
+// ErrorFor arma la respuesta "error" para err, con el id y el idioma de la sesión.
+func (c *Context) ErrorFor(err error) protocol.ErrorResponse {
+	return errorResponse(c.Session.Lang(), c.ID, err)
+}
+
+// AccountRequired indica si el server exige login (TLS + RequireAccount).
+func (c *Context) AccountRequired() bool { return requireAccountActive(c.sec) }
+
+// Options devuelve las opciones con que se registró el handler actual.
+func (c *Context) Options() HandlerOptions { return c.opts }
+
+// Public indica si el handler actual se atiende sin login.
+func (c *Context) Public() bool { return c.public }
+
+// Registry devuelve el registry que está despachando (para anunciar tipos, etc.).
+func (c *Context) Registry() *Registry { return c.registry }
+
+// ---- Middlewares incluidos ----
+
+// Logging registra cada mensaje recibido y los errores de su handler.
+func Logging(next Handler) Handler {
+	return func(c *Context) error {
+		log.Printf("[ws] recv type=%s id=%s bytes=%d", c.Type, c.ID, len(c.Raw))
+		err := next(c)
+		if err != nil {
+			log.Printf("[ws] %s id=%s error: %v", c.Type, c.ID, err)
+		}
+		return err
+	}
+}
+
+// RequireAuth rechaza con AUTH_REQUIRED todo lo que no sea público mientras
+// el server exija login y la sesión no lo haya hecho, con PAIRING_REQUIRED
+// si la conexión entró con el token compartido y ya no alcanza (ver
+// SecurityConfig.RequireDeviceCredential) y con APPROVAL_PENDING mientras
+// el PC no apruebe la conexión.
+func RequireAuth(next Handler) Handler {
+	return func(c *Context) error {
+		if !c.public && c.pairingOnly() {
+			c.gated = true
+			return &Error{Code: protocol.CodePairingRequired}
+		}
+		if !c.opts.AllowPending && c.Session.ApprovalPending() {
+			c.gated = true
+			requestApproval(c.Session)
+			return &Error{Code: protocol.CodeApprovalPending}
+		}
+		if c.AccountRequired() && !c.public && !c.Session.Authed() {
+			c.gated = true
+			return &Error{Code: protocol.CodeAuthRequired}
+		}
+		return next(c)
+	}
+}
+
+// CheckPermission crea un middleware que llama check antes de cada handler no
+// público; si devuelve error el mensaje no llega al driver.
+func CheckPermission(check func(c *Context) error) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) error {
+			if !c.public {
+				if err := check(c); err != nil {
+					c.gated = true
+					log.Printf("[ws] denied type=%s id=%s session=%s user=%q: %v",
+						c.Type, c.ID, c.Session.ID(), c.Session.Username(), err)
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"deskcontrol/daemon/internal/audit"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// Reanudar sesión: cada auth_ok trae un resume_token. Si la conexión se corta
+// (p.ej. el teléfono cambia de access point), la sesión queda "estacionada"
+// DefaultResumeTTL con lo que tenía apretado y la captura pendiente; una
+// conexión nueva que manda auth_resume con el token la recupera sin volver a
+// mandar la contraseña. El token sirve una sola vez (el auth_ok del resume
+// trae otro) y se anula con auth_logout, al cortar la sesión desde el PC o
+// al vencer.
+
+// DefaultResumeTTL: cuánto se puede reanudar después de la desconexión.
+const DefaultResumeTTL = 2 * time.Minute
+
+type resumeEntry struct {
+	se      *Session
+	expires time.Time   // cero mientras la conexión sigue viva
+	timer   *time.Timer // vence el estacionamiento
+}
+
+var (
+	resumeMu sync.Mutex
+	resumes  = map[string]*resumeEntry{} // sha256(token) -> entrada
+	resumeOf = map[string]string{}       // session id -> sha256(token)
+)
+
+func hashResumeToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueResumeToken da un token nuevo para se y anula el anterior.
+func issueResumeToken(se *Session) string {
+	tok := randomURLSafe(32)
+	h := hashResumeToken(tok)
+
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	if old, ok := resumeOf[se.id]; ok {
+		delete(resumes, old)
+	}
+	resumes[h] = &resumeEntry{se: se}
+	resumeOf[se.id] = h
+	return tok
+}
+
+// invalidateResume anula el token de la sesión id (logout, kick, revocación).
+// Si la sesión estaba estacionada, suelta lo que tenía apretado.
+func invalidateResume(id string) {
+	resumeMu.Lock()
+	h, ok := resumeOf[id]
+	var e *resumeEntry
+	if ok {
+		e = resumes[h]
+		delete(resumes, h)
+		delete(resumeOf, id)
+	}
+	resumeMu.Unlock()
+
+	if e != nil && e.timer != nil {
+		e.timer.Stop()
+		e.se.releaseParked("resume invalidated")
+	}
+}
+
+// takeResume consume el token (si existe y no venció) y devuelve su sesión.
+func takeResume(tok string) *Session {
+	h := hashResumeToken(tok)
+
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	e, ok := resumes[h]
+	if !ok {
+		return nil
+	}
+	delete(resumes, h)
+	delete(resumeOf, e.se.id)
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		return nil
+	}
+	return e.se
+}
+
+// parkForResume se llama al desconectar: si se tiene un token vigente, la
+// sesión queda esperando un auth_resume y no se suelta nada todavía.
+// Devuelve false si no hay nada que esperar (hay que soltar ya).
+func parkForResume(se *Session) bool {
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	h, ok := resumeOf[se.id]
+	if !ok {
+		return false
+	}
+	e := resumes[h]
+	e.expires = time.Now().Add(DefaultResumeTTL)
+	e.timer = time.AfterFunc(DefaultResumeTTL, func() {
+		resumeMu.Lock()
+		if resumeOf[se.id] == h {
+			delete(resumes, h)
+			delete(resumeOf, se.id)
+		}
+		resumeMu.Unlock()
+		se.releaseParked("resume expired")
+	})
+	log.Printf("[ws] session parked for resume session=%s held=%d ttl=%s", se.id, se.held.count(), DefaultResumeTTL)
+	return true
+}
+
+// parkedSessions devuelve las sesiones desconectadas que esperan auth_resume.
+func parkedSessions() []*Session {
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	var out []*Session
+	for _, e := range resumes {
+		if !e.expires.IsZero() {
+			out = append(out, e.se)
+		}
+	}
+	return out
+}
+
+// releaseParked suelta lo que una sesión estacionada dejó apretado (el hold
+// timeout normalmente ya lo hizo) y corta su captura, si sigue.
+func (se *Session) releaseParked(reason string) {
+	se.stopCapture(reason)
+	if se.srv != nil {
+		se.releaseHeld(se.srv.inputDriver(), reason)
+	}
+}
+
+func (se *Session) markGone() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.gone = true
+}
+
+func (se *Session) isGone() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.gone
+}
+
+// releaseRejected: el token ya se consumió pero no se reanudó; si la sesión
+// estaba estacionada suelta lo que tenía apretado.
+func releaseRejected(old *Session) {
+	if old.isGone() {
+		old.releaseParked("resume rejected")
+	}
+}
+
+// authOk arma el auth_ok de se con un resume_token nuevo.
+func authOk(c *Context, username string, perms Permissions) protocol.AuthOk {
+	return protocol.AuthOk{
+		ID:          c.ID,
+		Type:        protocol.TypeAuthOk,
+		Username:    username,
+		Session:     c.Session.ID(),
+		Permissions: perms,
+		ResumeToken: issueResumeToken(c.Session),
+		ResumeTTLMs: int(DefaultResumeTTL / time.Millisecond),
+	}
+}
+
+// handleAuthResume pasa a esta conexión la identidad y el estado de la sesión
+// anterior (login, dispositivo, preferencias, teclas apretadas y captura).
+func handleAuthResume(c *Context) error {
+	var m protocol.AuthResume
+	if err := c.Decode(&m); err != nil {
+		return err
+	}
+	if m.Token == "" {
+		return Errorf(protocol.CodeBadRequest, "token requerido")
+	}
+	old := takeResume(m.Token)
+	if old == nil || old == c.Session {
+		log.Printf("[auth] resume rejected session=%s remote=%s", c.Session.ID(), c.Session.RemoteAddr())
+		audit.Recordf(audit.LoginFailed, c.Session.ID(), "", c.Session.RemoteAddr(), "resume_token inválido o vencido")
+		return authFailure(c, &Error{Code: protocol.CodeResumeInvalid})
+	}
+
+	// la conexión nueva no puede usar el resume para cambiar de dispositivo
+	if dev := old.DeviceID(); dev != "" && dev != c.Session.DeviceID() {
+		log.Printf("[auth] resume rejected session=%s: device %q != %q", c.Session.ID(), c.Session.DeviceID(), dev)
+		releaseRejected(old)
+		return &Error{Code: protocol.CodeResumeInvalid}
+	}
+
+	// la cuenta pudo deshabilitarse o cambiar de permisos mientras tanto
+	sessionsMu.Lock()
+	authed, username, perms := old.authed, old.username, old.perms
+	sessionsMu.Unlock()
+	if authed && c.AccountRequired() {
+		row, err := loadUser(username)
+		if err != nil || row.Disabled {
+			log.Printf("[auth] resume rejected session=%s: user %q no longer valid", c.Session.ID(), username)
+			releaseRejected(old)
+			return &Error{Code: protocol.CodeResumeInvalid}
+		}
+		perms = row.Permissions
+	}
+
+	// lo apretado y la captura pasan a esta conexión; si la vieja sigue viva
+	// (el TCP todavía no se enteró) se corta sin soltar nada
+	stillOpen := !old.isGone()
+	old.markGone()
+	held := old.held.take()
+	sessionsMu.Lock()
+	capture := old.capture
+	old.capture = nil
+	sessionsMu.Unlock()
+	if stillOpen {
+		old.setDropReason("resumed by " + c.Session.ID())
+		if old.conn != nil && old.conn.c != nil {
+			_ = old.conn.c.Close()
+		}
+	}
+
+	se := c.Session
+	sessionsMu.Lock()
+	se.authed, se.username, se.perms = authed, username, perms
+	if se.deviceID == "" {
+		se.deviceID, se.deviceName = old.deviceID, old.deviceName
+	}
+	if se.helloName == "" {
+		se.helloName = old.helloName
+	}
+	if se.client == "" {
+		se.client, se.appVersion, se.protocol = old.client, old.appVersion, old.protocol
+	}
+	se.lang, se.acks = old.lang, old.acks
+	se.binary = se.binary || old.binary
+	// si el PC ya había aprobado la conexión anterior no se vuelve a preguntar
+	if !old.approvalPending {
+		se.approvalPending = false
+	}
+	sessionsMu.Unlock()
+
+	for _, h := range held {
+		se.held.press(h)
+	}
+
+	log.Printf("[auth] resume ok user=%q session=%s from=%s held=%d capture=%v", se.Username(), se.ID(), old.id, len(held), capture != nil)
+	audit.Recordf(audit.Login, se.ID(), se.Username(), se.RemoteAddr(), "resume de la sesión %s", old.id)
+
+	ok := authOk(c, se.Username(), sessionPermissions(c))
+	ok.Resumed = true
+	ok.Held = len(held)
+	ok.CapturePending = capture != nil
+	err := c.Reply(ok)
+	// después del auth_ok: si el capture_key ya llegó, sale ahora
+	if capture != nil {
+		capture.moveTo(se)
+	}
+	return err
+}
+
+// handleAuthLogout cierra el login (la conexión sigue abierta) y anula el
+// resume_token.
+func handleAuthLogout(c *Context) error {
+	invalidateResume(c.Session.ID())
+	user := c.Session.Username()
+	sessionsMu.Lock()
+	c.Session.authed = false
+	c.Session.username = ""
+	c.Session.perms = nil
+	sessionsMu.Unlock()
+	log.Printf("[auth] logout user=%q session=%s", user, c.Session.ID())
+	return c.Reply(protocol.Ack{ID: c.ID, Type: protocol.TypeAck, Of: protocol.TypeAuthLogout})
+}
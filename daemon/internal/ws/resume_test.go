@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// resumeToken hace auth_login y devuelve el resume_token.
+func resumeToken(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	resp := login(t, conn, "", "")
+	tok, _ := resp["resume_token"].(string)
+	if resp["type"] != protocol.TypeAuthOk || tok == "" {
+		t.Fatalf("auth_login -> %v", resp)
+	}
+	return tok
+}
+
+func resume(t *testing.T, conn *websocket.Conn, tok string) map[string]any {
+	t.Helper()
+	return roundTrip(t, conn, map[string]any{"id": "r", "type": protocol.TypeAuthResume, "token": tok})
+}
+
+func TestResumeSingleUse(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	a := mustConnect(t, srv, "")
+	tok := resumeToken(t, a)
+	if resp := roundTrip(t, a, map[string]any{"id": "k", "type": protocol.TypeKeyDown, "key": "shift", "ack": true}); resp["type"] != protocol.TypeAck {
+		t.Fatalf("key_down -> %v", resp)
+	}
+	a.Close()
+
+	b := mustConnect(t, srv, "")
+	resp := resume(t, b, tok)
+	if resp["type"] != protocol.TypeAuthOk || resp["resumed"] != true || resp["held"] != float64(1) {
+		t.Fatalf("auth_resume -> %v", resp)
+	}
+	if resp["resume_token"] == tok || resp["resume_token"] == nil {
+		t.Errorf("el resume no trajo un token nuevo: %v", resp["resume_token"])
+	}
+	// lo apretado pasó a b: no se soltó al cortarse a
+	waitCalls(t, fake, "KeyUp", 0)
+
+	c := mustConnect(t, srv, "")
+	if resp := resume(t, c, tok); resp["code"] != protocol.CodeResumeInvalid {
+		t.Errorf("token usado dos veces -> %v", resp)
+	}
+	if resp := resume(t, c, "inventado"); resp["code"] != protocol.CodeResumeInvalid {
+		t.Errorf("token inventado -> %v", resp)
+	}
+}
+
+// La captura en curso sigue mientras la sesión está estacionada (con su
+// timeout) y la respuesta va a la conexión que reanuda.
+func TestResumeKeepsCapture(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	a := mustConnect(t, srv, "")
+	tok := resumeToken(t, a)
+	send(t, a, map[string]any{"id": "cap-resume", "type": protocol.TypeCaptureStart, "timeout_ms": 500})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+	a.Close()
+
+	parked := func() bool {
+		for _, se := range parkedSessions() {
+			if r := se.currentCapture(); r != nil && r.id == "cap-resume" {
+				return true
+			}
+		}
+		return false
+	}
+	for deadline := time.Now().Add(5 * time.Second); !parked(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("la sesión no quedó estacionada con la captura")
+		}
+	}
+
+	b := mustConnect(t, srv, "")
+	if resp := resume(t, b, tok); resp["type"] != protocol.TypeAuthOk || resp["capture_pending"] != true {
+		t.Fatalf("auth_resume -> %v", resp)
+	}
+	// no se canceló al cortarse a: termina por su timeout
+	if resp := replies(t, b, "cap-resume")["cap-resume"]; resp["code"] != protocol.CodeCaptureTimeout {
+		t.Errorf("captura tras el resume -> %v", resp)
+	}
+}
+
+func TestResumeAfterLogout(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	a := mustConnect(t, srv, "")
+	tok := resumeToken(t, a)
+	send(t, a, map[string]any{"id": "k", "type": protocol.TypeKeyDown, "key": "shift"})
+	if resp := roundTrip(t, a, map[string]any{"id": "o", "type": protocol.TypeAuthLogout}); resp["type"] != protocol.TypeAck {
+		t.Fatalf("auth_logout -> %v", resp)
+	}
+	a.Close()
+	// sin token vigente no se estaciona: se suelta al desconectar
+	waitCalls(t, fake, "KeyUp", 1)
+
+	b := mustConnect(t, srv, "")
+	if resp := resume(t, b, tok); resp["code"] != protocol.CodeResumeInvalid {
+		t.Errorf("resume después de logout -> %v", resp)
+	}
+}
+
+func TestResumeExpired(t *testing.T) {
+	se := &Session{id: "resume-expired"}
+	tok := issueResumeToken(se)
+	resumeMu.Lock()
+	resumes[hashResumeToken(tok)].expires = time.Now().Add(-time.Second)
+	resumeMu.Unlock()
+
+	if got := takeResume(tok); got != nil {
+		t.Error("se reanudó con un token vencido")
+	}
+	resumeMu.Lock()
+	_, left := resumeOf[se.id]
+	resumeMu.Unlock()
+	if left {
+		t.Error("el token vencido quedó registrado")
+	}
+
+	// uno nuevo anula el anterior
+	first := issueResumeToken(se)
+	second := issueResumeToken(se)
+	if takeResume(first) != nil {
+		t.Error("el token anterior sigue sirviendo")
+	}
+	if takeResume(second) != se {
+		t.Error("el token nuevo no sirve")
+	}
+}
+
+func TestResumeOtherDevice(t *testing.T) {
+	srv, _ := startServer(t, SecurityConfig{RequireTLS: true, RequireToken: true, Token: "compartido"})
+	var creds []string
+	for _, name := range []string{"resume A", "resume B"} {
+		d, cred, err := createDevice(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _, _ = DeleteDevice(d.ID) })
+		creds = append(creds, cred)
+	}
+
+	a := mustConnect(t, srv, "token="+creds[0])
+	tok := resumeToken(t, a)
+	a.Close()
+
+	b := mustConnect(t, srv, "token="+creds[1])
+	if resp := resume(t, b, tok); resp["code"] != protocol.CodeResumeInvalid {
+		t.Errorf("resume desde otro dispositivo -> %v", resp)
+	}
+	// y el token ya se gastó: tampoco sirve desde el dispositivo correcto
+	a2 := mustConnect(t, srv, "token="+creds[0])
+	if resp := resume(t, a2, tok); resp["code"] != protocol.CodeResumeInvalid {
+		t.Errorf("resume tras el rechazo -> %v", resp)
+	}
+}
@@ -1,21 +1,24 @@
 package ws
 
 import (
+	"context"
 	"crypto/subtle"
-	"database/sql"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"time"
 
 	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
 
 	"github.com/gorilla/websocket"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type SecurityConfig struct {
@@ -26,7 +29,21 @@ type SecurityConfig struct {
 	RequireToken bool
 	Token        string
 
+	// Con token: el compartido (QR) sólo sirve para pair_request y el resto
+	// exige la credencial propia del dispositivo. false = el compartido sigue
+	// dando acceso completo, como antes de los dispositivos.
+	RequireDeviceCredential bool
+
+	// Las conexiones de dispositivos/IPs no aprobados esperan a que alguien
+	// las apruebe en el PC (ver approval.go). 0 = DefaultApprovalTimeout.
+	RequireApproval bool
+	ApprovalTimeout time.Duration
+
 	RequireAccount bool // SOLO con TLS
+
+	// Permisos de las sesiones sin login (sin TLS o sin RequireAccount).
+	// nil = admin, como siempre.
+	GuestPermissions Permissions
 }
 
 var upgrader = websocket.Upgrader{
@@ -38,529 +55,451 @@ type safeConn struct {
 	mu sync.Mutex // gorilla/websocket: un solo writer a la vez
 }
 
-func (s *safeConn) writeJSON(v any) error {
+func (s *safeConn) WriteJSON(v any) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	_ = s.c.SetWriteDeadline(time.Now().Add(3 * time.Second))
 	return s.c.WriteJSON(v)
 }
 
-// ---- Incoming messages ----
-type baseMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
+// close manda un close frame (best effort) y cierra el socket.
+func (s *safeConn) close(code int, reason string) {
+	s.mu.Lock()
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = s.c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	s.mu.Unlock()
+	_ = s.c.Close()
 }
 
-type authLoginMsg struct {
-	ID       string `json:"id,omitempty"`
-	Type     string `json:"type"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+func tokenFromRequest(r *http.Request) string {
+	if t := r.Header.Get("X-DeskControl-Token"); t != "" {
+		return t
+	}
+	u, err := url.Parse(r.URL.String())
+	if err == nil {
+		if q := u.Query().Get("token"); q != "" {
+			return q
+		}
+	}
+	return ""
 }
 
-type mouseMoveMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	Dx   int32  `json:"dx"`
-	Dy   int32  `json:"dy"`
+// checkToken valida el token del upgrade: un código de emparejamiento
+// (?pair=, se gasta acá), la credencial de un dispositivo (tabla devices) o el
+// token compartido. Devuelve con cuál entró (tokenPairing, tokenDevice y el
+// dispositivo, tokenShared o tokenNone si no hacía falta).
+func checkToken(sec SecurityConfig, r *http.Request) (string, *Device, bool) {
+	// Política: token solo tiene sentido con TLS
+	if !sec.RequireTLS {
+		return tokenNone, nil, true
+	}
+	if code := r.URL.Query().Get("pair"); code != "" {
+		return tokenPairing, nil, consumePairingCode(code, r.RemoteAddr)
+	}
+	got := tokenFromRequest(r)
+	if _, _, ok := splitDeviceCredential(got); ok {
+		d, err := deviceForCredential(got)
+		if err == nil {
+			return tokenDevice, d, true
+		}
+		log.Printf("[ws] device credential rejected remote=%s: %v", r.RemoteAddr, err)
+		return tokenNone, nil, !sec.RequireToken
+	}
+	if !sec.RequireToken {
+		return tokenNone, nil, true
+	}
+	if sec.Token == "" {
+		return tokenNone, nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(sec.Token)) == 1 {
+		return tokenShared, nil, true
+	}
+	return tokenNone, nil, false
 }
 
-type mouseClickMsg struct {
-	ID     string `json:"id,omitempty"`
-	Type   string `json:"type"`
-	Button string `json:"button"`
+func requireAccountActive(sec SecurityConfig) bool {
+	// cuenta solo con TLS
+	if !sec.RequireTLS {
+		return false
+	}
+	return sec.RequireAccount
 }
 
-type mouseScrollMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	Dy   int32  `json:"dy"`
+// decodeBinaryFrame llena c con un frame binario (sólo si la sesión lo negoció).
+func decodeBinaryFrame(c *Context, raw []byte) error {
+	if !c.Session.Binary() {
+		return Errorf(protocol.CodeBadRequest, "frames binarios no negociados (usa ?enc=%s o hello binary)", protocol.EncodingBinary)
+	}
+	t, msg, err := protocol.DecodeBinary(raw)
+	if err != nil {
+		return err
+	}
+	c.base = protocol.Base{Type: t}
+	c.pre = msg
+	return nil
 }
 
-type keyTextMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	Text string `json:"text"`
+// workerStopTimeout: cuánto esperamos al mensaje en curso al desconectar.
+const workerStopTimeout = 3 * time.Second
+
+// Server es el endpoint /ws. Se crea con NewServer, arranca con Start (que
+// devuelve los errores de listen en vez de matar el proceso) y se detiene con
+// Shutdown, que corta las sesiones propias y espera a que terminen.
+type Server struct {
+	addr     string
+	driver   input.InputDriver
+	sec      SecurityConfig
+	registry *Registry
+
+	holdTO    time.Duration
+	pingEvery time.Duration
+	idleTO    time.Duration
+	authLim   AuthLimits
+
+	approvalPrompt func(ApprovalRequest)
+
+	mu       sync.Mutex
+	httpSrv  *http.Server
+	ln       net.Listener
+	closing  bool
+	reapStop chan struct{}
+	handlers sync.WaitGroup // una por conexión websocket viva
 }
 
-type keyMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	Key  string `json:"key"`
+// NewServer prepara (sin escuchar todavía) un server que usa DefaultRegistry.
+func NewServer(addr string, driver input.InputDriver, sec SecurityConfig) *Server {
+	return &Server{
+		addr:      addr,
+		driver:    driver,
+		sec:       sec,
+		registry:  DefaultRegistry,
+		holdTO:    DefaultHoldTimeout,
+		pingEvery: DefaultPingInterval,
+		idleTO:    DefaultIdleTimeout,
+		authLim:   DefaultAuthLimits,
+	}
 }
 
-type hotkeyMsg struct {
-	ID   string   `json:"id,omitempty"`
-	Type string   `json:"type"`
-	Mods []string `json:"mods"`
-	Key  string   `json:"key"`
+// SetRegistry cambia el registry con el que se despachan los mensajes.
+// Llamar antes de Start.
+func (s *Server) SetRegistry(reg *Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registry = reg
 }
 
-type keyVKMsg struct {
-	ID   string        `json:"id,omitempty"`
-	Type string        `json:"type"`
-	Key  input.KeySpec `json:"key"`
+// Addr devuelve la dirección real de escucha (útil con puerto 0) o la configurada.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+	return s.addr
 }
 
-type hotkeyVKMsg struct {
-	ID   string        `json:"id,omitempty"`
-	Type string        `json:"type"`
-	Mods []string      `json:"mods"`
-	Key  input.KeySpec `json:"key"`
-}
+// Start abre el listener (y carga el certificado si hay TLS) y sirve en segundo
+// plano. Un puerto ocupado o un cert inválido vuelven como error.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.httpSrv != nil {
+		return errors.New("ws: server ya iniciado")
+	}
 
-type captureStartMsg struct {
-	ID        string `json:"id,omitempty"`
-	Type      string `json:"type"`
-	TimeoutMs int    `json:"timeout_ms,omitempty"`
-}
+	var tlsCfg *tls.Config
+	if s.sec.RequireTLS {
+		cert, err := tls.LoadX509KeyPair(s.sec.CertPath, s.sec.KeyPath)
+		if err != nil {
+			return fmt.Errorf("ws: cargando cert/key TLS: %w", err)
+		}
+		tlsCfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
 
-type appsListMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-}
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("ws: listen %s: %w", s.addr, err)
+	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
 
-type appActionMsg struct {
-	ID     string  `json:"id,omitempty"`
-	Type   string  `json:"type"`
-	Hwnd   uintptr `json:"hwnd"`
-	Action string  `json:"action"`
-}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWS)
 
-// ✅ NUEVOS (compatibles root y payload)
-type inputKeyFlatMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	VK   uint16 `json:"vk"`
-	Scan uint16 `json:"scan,omitempty"`
-	Ext  bool   `json:"ext,omitempty"`
-
-	Payload *struct {
-		VK   uint16 `json:"vk"`
-		Scan uint16 `json:"scan,omitempty"`
-		Ext  bool   `json:"ext,omitempty"`
-	} `json:"payload,omitempty"`
-}
+	s.ln = ln
+	s.httpSrv = &http.Server{Handler: mux}
+	s.reapStop = make(chan struct{})
+	go s.reapLoop(s.reapStop)
 
-type textInputMsg struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
-	Text string `json:"text"`
+	if s.sec.RequireTLS {
+		log.Println("[ws] TLS ENABLED: only wss:// is allowed (ws:// will NOT be served)")
+		log.Printf("[ws] Daemon listening (TLS) on %s endpoint /ws cert=%q key=%q token=%v account=%v",
+			ln.Addr(), s.sec.CertPath, s.sec.KeyPath, s.sec.RequireToken, s.sec.RequireAccount)
+	} else {
+		log.Println("[ws] TLS disabled: serving ws:// on", ln.Addr(), "endpoint /ws")
+	}
 
-	Payload *struct {
-		Text string `json:"text"`
-	} `json:"payload,omitempty"`
+	go func(srv *http.Server, ln net.Listener) {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[ws] serve error: %v", err)
+		}
+	}(s.httpSrv, ln)
+	return nil
 }
 
-func parseKeySpec(raw []byte) (input.KeySpec, bool) {
-	var m inputKeyFlatMsg
-	if err := json.Unmarshal(raw, &m); err != nil {
-		return input.KeySpec{}, false
-	}
-	if m.VK != 0 || m.Scan != 0 {
-		return input.KeySpec{VK: m.VK, Scan: m.Scan, Ext: m.Ext}, true
+// Shutdown deja de aceptar conexiones, cierra (con close frame) las sesiones
+// de este server y espera a que sus handlers terminen o venza ctx.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.httpSrv
+	wasClosing := s.closing
+	s.closing = true
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
 	}
-	if m.Payload != nil {
-		return input.KeySpec{VK: m.Payload.VK, Scan: m.Payload.Scan, Ext: m.Payload.Ext}, true
+	if !wasClosing {
+		close(s.reapStop)
 	}
-	return input.KeySpec{}, true
-}
 
-func parseText(raw []byte) (string, bool) {
-	var m textInputMsg
-	if err := json.Unmarshal(raw, &m); err != nil {
-		return "", false
-	}
-	if m.Text != "" {
-		return m.Text, true
-	}
-	if m.Payload != nil {
-		return m.Payload.Text, true
+	log.Printf("[ws] shutting down %s", s.Addr())
+
+	// http.Server.Shutdown no toca las conexiones hijackeadas (websocket)
+	err := srv.Shutdown(ctx)
+	n := dropServerSessions(s, "server shutting down")
+	log.Printf("[ws] shutdown: %d session(s) closed", n)
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
 	}
-	return "", true
+	return err
 }
 
-// ---- Outgoing ----
-type errResp struct {
-	ID    string `json:"id,omitempty"`
-	Type  string `json:"type"`
-	Error string `json:"error"`
+// SetSecurity cambia token/cuenta en caliente: aplica a las conexiones nuevas
+// y a los próximos mensajes de las existentes (no se corta a nadie).
+// TLS on/off o cambio de cert requieren un Server nuevo (es el listener).
+// Si se apaga RequireApproval, lo que estaba pendiente queda aprobado.
+func (s *Server) SetSecurity(sec SecurityConfig) {
+	s.mu.Lock()
+	s.sec = sec
+	s.mu.Unlock()
+	log.Printf("[ws] security updated token=%v device_credential=%v account=%v approval=%v guest_permissions=%v",
+		sec.RequireToken, sec.RequireDeviceCredential, sec.RequireAccount, sec.RequireApproval, sec.GuestPermissions)
+	if !sec.RequireApproval {
+		approveServerSessions(s)
+	}
 }
 
-type authOkResp struct {
-	ID       string `json:"id,omitempty"`
-	Type     string `json:"type"`
-	Username string `json:"username"`
-	Session  string `json:"session"`
+// SetHoldTimeout cambia cuánto puede quedar algo apretado sin que la sesión
+// mande nada antes de soltarlo (0 = nunca). Aplica también a las sesiones vivas.
+func (s *Server) SetHoldTimeout(d time.Duration) {
+	s.mu.Lock()
+	s.holdTO = d
+	s.mu.Unlock()
+	setServerHoldTimeout(s, d)
 }
 
-type captureResp struct {
-	ID     string              `json:"id,omitempty"`
-	Type   string              `json:"type"`
-	Result input.CaptureResult `json:"result"`
+func (s *Server) holdTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holdTO
 }
 
-type appsListResp struct {
-	ID   string          `json:"id,omitempty"`
-	Type string          `json:"type"`
-	Apps []input.AppInfo `json:"apps"`
+func (s *Server) inputDriver() input.InputDriver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.driver
 }
 
-type pongResp struct {
-	ID   string `json:"id,omitempty"`
-	Type string `json:"type"`
+func (s *Server) security() SecurityConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sec
 }
 
-func tokenFromRequest(r *http.Request) string {
-	if t := r.Header.Get("X-DeskControl-Token"); t != "" {
-		return t
-	}
-	u, err := url.Parse(r.URL.String())
-	if err == nil {
-		if q := u.Query().Get("token"); q != "" {
-			return q
+// Broadcast manda v a todas las sesiones de este server. Devuelve a cuántas llegó.
+func (s *Server) Broadcast(v any) int {
+	n := 0
+	for _, c := range serverConns(s) {
+		if err := c.WriteJSON(v); err == nil {
+			n++
 		}
 	}
-	return ""
+	return n
 }
 
-func checkToken(sec SecurityConfig, r *http.Request) bool {
-	// Política: token solo tiene sentido con TLS
-	if !sec.RequireTLS {
-		return true
-	}
-	if !sec.RequireToken {
-		return true
-	}
-	if sec.Token == "" {
-		return false
-	}
-	got := tokenFromRequest(r)
-	return subtle.ConstantTimeCompare([]byte(got), []byte(sec.Token)) == 1
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
 }
 
-func requireAccountActive(sec SecurityConfig) bool {
-	// cuenta solo con TLS
-	if !sec.RequireTLS {
-		return false
+// Start es un atajo: NewServer + Start.
+func Start(addr string, driver input.InputDriver, sec SecurityConfig) (*Server, error) {
+	s := NewServer(addr, driver, sec)
+	if err := s.Start(); err != nil {
+		return nil, err
 	}
-	return sec.RequireAccount
+	return s, nil
 }
 
-func Start(addr string, driver input.InputDriver, sec SecurityConfig) {
-	mux := http.NewServeMux()
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	if s.isClosing() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.handlers.Add(1)
+	defer s.handlers.Done()
 
-	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		// Gates BEFORE upgrade
-		if !checkToken(sec, r) {
-			http.Error(w, "unauthorized (token)", http.StatusUnauthorized)
-			return
-		}
+	s.mu.Lock()
+	reg, driver := s.registry, s.driver
+	s.mu.Unlock()
+	sec := s.security()
+
+	// Gates BEFORE upgrade
+	kind, device, ok := checkToken(sec, r)
+	if !ok {
+		http.Error(w, "unauthorized (token)", http.StatusUnauthorized)
+		return
+	}
 
-		rawConn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Println("[ws] upgrade error:", err)
-			return
-		}
-		defer rawConn.Close()
+	rawConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("[ws] upgrade error:", err)
+		return
+	}
+	defer rawConn.Close()
 
-		conn := &safeConn{c: rawConn}
+	conn := &safeConn{c: rawConn}
+	if device != nil {
+		log.Printf("[ws] client connected from %s device=%s name=%q", r.RemoteAddr, device.ID, device.Name)
+	} else {
 		log.Println("[ws] client connected from", r.RemoteAddr)
+	}
 
-		// Register session slot (even before auth) so UI can see connections
-		se := registerSession(conn, r.RemoteAddr)
-		sessionID := se.id
+	// Register session slot (even before auth) so UI can see connections
+	se := registerSession(s, conn, r.RemoteAddr, normLang(r.URL.Query().Get("lang")))
+	sessionID := se.id
+	se.setDevice(kind, device)
+	if needsApproval(sec, kind, device, r.RemoteAddr) {
+		se.setApprovalPending()
+		_ = conn.WriteJSON(protocol.Status{Type: protocol.TypeStatus, State: protocol.StatusPending})
+	}
+	if r.URL.Query().Get("enc") == protocol.EncodingBinary {
+		se.setBinary(true)
+	}
 
-		authed := false
-		username := ""
+	// el driver corre en el worker de la sesión; este loop sólo lee y encola
+	queue := newInputQueue(DefaultQueueSize)
+	sessionsMu.Lock()
+	se.queue = queue
+	sessionsMu.Unlock()
+	go queue.run(reg)
+
+	// heartbeat: pings del server + read deadline que se corre con cada mensaje/pong
+	pingEvery, idle := s.heartbeat()
+	extendDeadline(rawConn, idle)
+	rawConn.SetPongHandler(func(string) error {
+		touchSession(sessionID)
+		extendDeadline(rawConn, idle)
+		return nil
+	})
+	done := make(chan struct{})
+	go pingLoop(conn, pingEvery, done)
 
-		defer func() {
-			if rec := recover(); rec != nil {
-				log.Printf("[ws] PANIC in handler: %v\n%s", rec, string(debug.Stack()))
+	defer func() {
+		close(done)
+		if rec := recover(); rec != nil {
+			log.Printf("[ws] PANIC in handler: %v\n%s", rec, string(debug.Stack()))
+		}
+		// lo pendiente se descarta; esperamos al mensaje en curso antes de soltar teclas
+		queue.close()
+		select {
+		case <-queue.done:
+		case <-time.After(workerStopTimeout):
+			log.Printf("[ws] worker still busy after %s session=%s", workerStopTimeout, sessionID)
+		}
+		if batchDone := se.cancelBatch(); batchDone != nil {
+			select {
+			case <-batchDone:
+			case <-time.After(workerStopTimeout):
+				log.Printf("[ws] batch still running after %s session=%s", workerStopTimeout, sessionID)
 			}
-			unregisterSession(sessionID)
+		}
+		// con resume_token vigente lo apretado y la captura esperan al
+		// auth_resume (el hold timeout y el de la captura corren igual)
+		se.markGone()
+		if !parkForResume(se) {
+			se.stopCapture("disconnect")
+			se.releaseHeld(driver, "disconnect")
+		}
+		unregisterSession(sessionID)
+		if reason := se.reason(); reason != "" {
+			log.Printf("[ws] client disconnected: %s (session=%s reason=%s)", r.RemoteAddr, sessionID, reason)
+		} else {
 			log.Println("[ws] client disconnected:", r.RemoteAddr)
-		}()
-
-		for {
-			_, raw, err := rawConn.ReadMessage()
-			if err != nil {
-				log.Println("[ws] read error:", err)
-				return
-			}
-
-			touchSession(sessionID)
+		}
+	}()
 
-			var b baseMsg
-			if err := json.Unmarshal(raw, &b); err != nil {
-				log.Println("[ws] invalid json:", err)
-				continue
+	for {
+		msgType, raw, err := rawConn.ReadMessage()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				se.setDropReason(fmt.Sprintf("read timeout (sin tráfico en %s)", idle))
 			}
+			log.Println("[ws] read error:", err)
+			return
+		}
 
-			log.Printf("[ws] recv type=%s id=%s bytes=%d", b.Type, b.ID, len(raw))
+		touchSession(sessionID)
+		extendDeadline(rawConn, idle)
+		se.held.touch()
 
-			// ✅ siempre responder ping
-			if b.Type == "ping" {
-				if err := conn.writeJSON(pongResp{ID: b.ID, Type: "pong"}); err != nil {
-					log.Printf("[ws] pong write error: %v", err)
-					return
-				}
+		c := &Context{
+			Session:  se,
+			Conn:     conn,
+			Driver:   driver,
+			sec:      s.security(),
+			registry: reg,
+		}
+		if msgType == websocket.BinaryMessage {
+			if err := decodeBinaryFrame(c, raw); err != nil {
+				log.Printf("[ws] binary frame rejected session=%s: %v", sessionID, err)
+				_ = conn.WriteJSON(c.ErrorFor(err))
 				continue
 			}
-
-			// --- LOGIN gating (solo TLS + RequireAccount) ---
-			if requireAccountActive(sec) && !authed {
-				if b.Type != "auth_login" {
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "unauthorized: login requerido"})
-					continue
-				}
-
-				var m authLoginMsg
-				if err := json.Unmarshal(raw, &m); err != nil {
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "auth_login inválido"})
-					continue
-				}
-				u := strings.TrimSpace(m.Username)
-				p := m.Password
-
-				if u == "" || p == "" {
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "usuario/contraseña requeridos"})
-					continue
-				}
-
-				row, err := loadUser(u)
-				if err != nil {
-					// sqlite: si tabla no existe, devuelve error: treat as no users
-					if err == sql.ErrNoRows {
-						_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "usuario o contraseña inválidos"})
-						continue
-					}
-					// también cubre "no such table: users"
-					log.Printf("[auth] loadUser error: %v", err)
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "no hay usuarios configurados (crea uno en la UI)"})
-					continue
-				}
-
-				if row.Disabled {
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "usuario deshabilitado"})
-					continue
-				}
-
-				if bcrypt.CompareHashAndPassword([]byte(row.PasswordHash), []byte(p)) != nil {
-					_ = conn.writeJSON(errResp{ID: b.ID, Type: "error", Error: "usuario o contraseña inválidos"})
-					continue
-				}
-
-				authed = true
-				username = row.Username
-				markSessionAuthed(sessionID, username)
-				markLastLogin(username)
-
-				if err := conn.writeJSON(authOkResp{ID: b.ID, Type: "auth_ok", Username: username, Session: sessionID}); err != nil {
-					log.Printf("[auth] write auth_ok error: %v", err)
-					return
-				}
+		} else {
+			if err := json.Unmarshal(raw, &c.base); err != nil {
+				log.Println("[ws] invalid json:", err)
 				continue
 			}
+			c.Raw = raw
+		}
+		b := c.base
+		c.ID, c.Type = b.ID, b.Type
 
-			// ---- Normal actions ----
-			switch b.Type {
-
-			case "mouse_move":
-				var m mouseMoveMsg
-				if json.Unmarshal(raw, &m) == nil {
-					_ = driver.MoveMouse(m.Dx, m.Dy)
-				}
-
-			case "mouse_click":
-				var m mouseClickMsg
-				if json.Unmarshal(raw, &m) == nil {
-					_ = driver.MouseClick(m.Button)
-				}
-
-			case "mouse_down":
-				var m mouseClickMsg
-				if json.Unmarshal(raw, &m) == nil {
-					_ = driver.MouseDown(m.Button)
-				}
-
-			case "mouse_up":
-				var m mouseClickMsg
-				if json.Unmarshal(raw, &m) == nil {
-					_ = driver.MouseUp(m.Button)
-				}
-
-			case "mouse_scroll":
-				var m mouseScrollMsg
-				if json.Unmarshal(raw, &m) == nil {
-					_ = driver.MouseScroll(m.Dy)
-				}
-
-			case "key_text":
-				var m keyTextMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_text text=%q", m.Text)
-					_ = driver.KeyText(m.Text)
-				}
-
-			case "key":
-				var m keyMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key key=%q", m.Key)
-					_ = driver.Key(m.Key)
-				}
-
-			case "key_down":
-				var m keyMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_down key=%q", m.Key)
-					_ = driver.KeyDown(m.Key)
-				}
-
-			case "key_up":
-				var m keyMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_up key=%q", m.Key)
-					_ = driver.KeyUp(m.Key)
-				}
-
-			case "hotkey":
-				var m hotkeyMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] hotkey mods=%v key=%q", m.Mods, m.Key)
-					_ = driver.Hotkey(m.Mods, m.Key)
-				}
-
-			case "key_vk":
-				var m keyVKMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_vk vk=%d scan=%d ext=%v", m.Key.VK, m.Key.Scan, m.Key.Ext)
-					_ = driver.KeyVK(m.Key)
-				}
-			case "key_down_vk":
-				var m keyVKMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_down_vk vk=%d scan=%d ext=%v", m.Key.VK, m.Key.Scan, m.Key.Ext)
-					_ = driver.KeyDownVK(m.Key)
-				}
-			case "key_up_vk":
-				var m keyVKMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] key_up_vk vk=%d scan=%d ext=%v", m.Key.VK, m.Key.Scan, m.Key.Ext)
-					_ = driver.KeyUpVK(m.Key)
-				}
-			case "hotkey_vk":
-				var m hotkeyVKMsg
-				if json.Unmarshal(raw, &m) == nil {
-					log.Printf("[input] hotkey_vk mods=%v vk=%d scan=%d ext=%v", m.Mods, m.Key.VK, m.Key.Scan, m.Key.Ext)
-					_ = driver.HotkeyVK(m.Mods, m.Key)
-				}
-
-			case "text_input":
-				if text, ok := parseText(raw); ok {
-					log.Printf("[input] text_input id=%s text=%q", b.ID, text)
-					if text != "" {
-						_ = driver.KeyText(text)
-					}
-				}
-
-			case "input_key_tap":
-				if ks, ok := parseKeySpec(raw); ok {
-					log.Printf("[input] input_key_tap id=%s vk=%d scan=%d ext=%v", b.ID, ks.VK, ks.Scan, ks.Ext)
-					if ks.VK != 0 || ks.Scan != 0 {
-						_ = driver.KeyVK(ks)
-					}
-				}
-
-			case "input_key_down":
-				if ks, ok := parseKeySpec(raw); ok {
-					log.Printf("[input] input_key_down id=%s vk=%d scan=%d ext=%v", b.ID, ks.VK, ks.Scan, ks.Ext)
-					if ks.VK != 0 || ks.Scan != 0 {
-						_ = driver.KeyDownVK(ks)
-					}
-				}
-
-			case "input_key_up":
-				if ks, ok := parseKeySpec(raw); ok {
-					log.Printf("[input] input_key_up id=%s vk=%d scan=%d ext=%v", b.ID, ks.VK, ks.Scan, ks.Ext)
-					if ks.VK != 0 || ks.Scan != 0 {
-						_ = driver.KeyUpVK(ks)
-					}
-				}
-
-			case "capture_start":
-				var m captureStartMsg
-				if json.Unmarshal(raw, &m) != nil {
-					continue
-				}
-				timeout := m.TimeoutMs
-				if timeout <= 0 {
-					timeout = 10000
-				}
-
-				log.Printf("[capture] start id=%s timeoutMs=%d", m.ID, timeout)
-
-				go func(reqID string, t int) {
-					defer func() {
-						if rec := recover(); rec != nil {
-							log.Printf("[capture] PANIC id=%s: %v\n%s", reqID, rec, string(debug.Stack()))
-							_ = conn.writeJSON(errResp{ID: reqID, Type: "error", Error: "panic in capture (check daemon logs)"})
-						}
-					}()
-
-					res, err := driver.CaptureNextKey(t)
-					if err != nil {
-						log.Printf("[capture] error id=%s: %v", reqID, err)
-						_ = conn.writeJSON(errResp{ID: reqID, Type: "error", Error: err.Error()})
-						return
-					}
-
-					if err := conn.writeJSON(captureResp{ID: reqID, Type: "capture_key", Result: res}); err != nil {
-						log.Printf("[capture] writeJSON failed id=%s: %v", reqID, err)
-					} else {
-						log.Printf("[capture] response sent id=%s", reqID)
-					}
-				}(m.ID, timeout)
-
-			case "apps_list":
-				var m appsListMsg
-				if json.Unmarshal(raw, &m) != nil {
-					continue
-				}
-				apps, err := driver.ListApps()
-				if err != nil {
-					log.Printf("[apps] list error id=%s: %v", m.ID, err)
-					_ = conn.writeJSON(errResp{ID: m.ID, Type: "error", Error: err.Error()})
-					continue
-				}
-				log.Printf("[apps] list ok id=%s count=%d", m.ID, len(apps))
-				_ = conn.writeJSON(appsListResp{ID: m.ID, Type: "apps_list_result", Apps: apps})
-
-			case "app_action":
-				var m appActionMsg
-				if json.Unmarshal(raw, &m) != nil {
-					continue
-				}
-				log.Printf("[apps] action id=%s hwnd=%d action=%s", m.ID, m.Hwnd, m.Action)
-				if err := driver.AppAction(m.Hwnd, m.Action); err != nil {
-					log.Printf("[apps] action error id=%s: %v", m.ID, err)
-					_ = conn.writeJSON(errResp{ID: m.ID, Type: "error", Error: err.Error()})
-				}
-
-			default:
-				// ignore
+		// ping se contesta ya: sirve para medir latencia y no debe esperar al driver
+		if b.Type == protocol.TypePing {
+			dispatchSafe(reg, c)
+			continue
+		}
+		if !queue.push(c) {
+			depth, dropped, _ := queue.stats()
+			log.Printf("[ws] queue full session=%s type=%s id=%s depth=%d dropped=%d", sessionID, b.Type, b.ID, depth, dropped)
+			if b.Type != protocol.TypeMouseMove || wantReply(b, se.Acks()) {
+				_ = conn.WriteJSON(c.ErrorFor(&Error{Code: protocol.CodeQueueFull}))
 			}
 		}
-	})
-
-	if sec.RequireTLS {
-		log.Println("[ws] TLS ENABLED: only wss:// is allowed (ws:// will NOT be served)")
-		log.Printf("[ws] Daemon listening (TLS) on %s endpoint /ws cert=%q key=%q token=%v account=%v",
-			addr, sec.CertPath, sec.KeyPath, sec.RequireToken, sec.RequireAccount)
-		log.Fatal(http.ListenAndServeTLS(addr, sec.CertPath, sec.KeyPath, mux))
-		return
 	}
-
-	log.Println("[ws] TLS disabled: serving ws:// on", addr, "endpoint /ws")
-	log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -0,0 +1,463 @@
+package ws
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+
+	"deskcontrol/daemon/internal/input"
+	"deskcontrol/daemon/internal/protocol"
+)
+
+// startServer levanta un Server con sec sobre un input.Fake. Con RequireTLS
+// y sin CertPath usa un certificado autofirmado de prueba.
+func startServer(t *testing.T, sec SecurityConfig) (*Server, *input.Fake) {
+	t.Helper()
+	if sec.RequireTLS && sec.CertPath == "" {
+		sec.CertPath, sec.KeyPath = testCert(t)
+	}
+	fake := input.NewFake()
+	srv := NewServer("127.0.0.1:0", fake, sec)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	return srv, fake
+}
+
+// testCert escribe un certificado autofirmado para 127.0.0.1.
+func testCert(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "deskcontrol-test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// connect abre /ws?query contra srv (wss con TLS). No falla el test: que el
+// upgrade se rechace puede ser lo que se prueba.
+func connect(t *testing.T, srv *Server, query string) (*websocket.Conn, error) {
+	t.Helper()
+	scheme, d := "ws", *websocket.DefaultDialer
+	if srv.security().RequireTLS {
+		scheme = "wss"
+		d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	u := scheme + "://" + srv.Addr() + "/ws"
+	if query != "" {
+		u += "?" + query
+	}
+	conn, _, err := d.Dial(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, nil
+}
+
+func mustConnect(t *testing.T, srv *Server, query string) *websocket.Conn {
+	t.Helper()
+	conn, err := connect(t, srv, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// addTestUser crea o reemplaza un usuario en la DB del daemon (en los tests,
+// la que queda junto al binario de test). Mismo esquema que la UI.
+func addTestUser(t *testing.T, name, password string, perms Permissions) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := openUsersDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+  password_hash TEXT NOT NULL,
+  disabled INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  last_login_at INTEGER NOT NULL DEFAULT 0,
+  permissions TEXT NOT NULL DEFAULT 'admin'
+);`)
+	if err == nil {
+		_, err = db.Exec(`INSERT OR REPLACE INTO users(username, password_hash, created_at, permissions) VALUES (?, ?, ?, ?);`,
+			name, string(hash), time.Now().Unix(), perms.String())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login hace auth_login y devuelve la respuesta.
+func login(t *testing.T, conn *websocket.Conn, user, password string) map[string]any {
+	t.Helper()
+	return roundTrip(t, conn, map[string]any{"id": "login", "type": protocol.TypeAuthLogin, "username": user, "password": password})
+}
+
+// waitStatus lee hasta el próximo mensaje status.
+func waitStatus(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg map[string]any
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("esperando status: %v", err)
+		}
+		if msg["type"] == protocol.TypeStatus {
+			return msg
+		}
+	}
+}
+
+// calls devuelve las llamadas al fake como texto (ver input.Call.String).
+func calls(fake *input.Fake) []string {
+	var out []string
+	for _, c := range fake.Calls() {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+// send manda msg sin esperar respuesta.
+func send(t *testing.T, conn *websocket.Conn, msg map[string]any) {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replies lee hasta tener una respuesta para cada id.
+func replies(t *testing.T, conn *websocket.Conn, ids ...string) map[string]map[string]any {
+	t.Helper()
+	out := map[string]map[string]any{}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(out) < len(ids) {
+		var resp map[string]any
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("esperando %v (llegaron %v): %v", ids, out, err)
+		}
+		for _, id := range ids {
+			if resp["id"] == id {
+				out[id] = resp
+			}
+		}
+	}
+	return out
+}
+
+// waitCalls espera a que el fake haya registrado n llamadas a method.
+func waitCalls(t *testing.T, fake *input.Fake, method string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := 0
+		for _, c := range fake.Calls() {
+			if c.Method == method {
+				got++
+			}
+		}
+		if got == n {
+			return
+		}
+		if got > n || time.Now().After(deadline) {
+			t.Fatalf("%s: %d llamadas, want %d", method, got, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// roundTrip manda msg y lee hasta la respuesta con su id.
+func roundTrip(t *testing.T, conn *websocket.Conn, msg map[string]any) map[string]any {
+	t.Helper()
+	send(t, conn, msg)
+	id, _ := msg["id"].(string)
+	return replies(t, conn, id)[id]
+}
+
+func TestServerDrivesFake(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	msgs := []map[string]any{
+		{"id": "1", "type": protocol.TypeMouseMove, "dx": 3, "dy": -4, "ack": true},
+		{"id": "2", "type": protocol.TypeKey, "key": "enter", "ack": true},
+		{"id": "3", "type": protocol.TypeHotkeyVK, "mods": []string{"ctrl"}, "key": map[string]any{"vk": 0x43}, "ack": true},
+		{"id": "4", "type": protocol.TypeMouseClick, "button": "middle", "ack": true},
+	}
+	for _, m := range msgs {
+		if resp := roundTrip(t, conn, m); resp["type"] != protocol.TypeAck {
+			t.Fatalf("%v -> %v", m, resp)
+		}
+	}
+
+	got := calls(fake)
+	want := []string{"MoveMouse[3 -4]", "Key[enter]", "HotkeyVK[[ctrl] {67 0 false}]", "MouseClick[middle]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestServerFakeScripted(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	fake.FailOn("Key", errors.New("boom"))
+	resp := roundTrip(t, conn, map[string]any{"id": "k", "type": protocol.TypeKey, "key": "a", "ack": true})
+	if resp["type"] != protocol.TypeError || resp["code"] != protocol.CodeDriverError {
+		t.Errorf("key con FailOn -> %v", resp)
+	}
+
+	fake.SetApps([]input.AppInfo{{Hwnd: 7, Title: "Notas"}})
+	resp = roundTrip(t, conn, map[string]any{"id": "a", "type": protocol.TypeAppsList})
+	apps, _ := resp["apps"].([]any)
+	if resp["type"] != protocol.TypeAppsListResult || len(apps) != 1 {
+		t.Errorf("apps_list -> %v", resp)
+	}
+
+	fake.QueueCapture(input.CaptureResult{Key: input.KeySpec{VK: 0x41}, Mods: []string{"shift"}}, nil)
+	resp = roundTrip(t, conn, map[string]any{"id": "c", "type": protocol.TypeCaptureStart, "timeout_ms": 1000})
+	if resp["type"] != protocol.TypeCaptureKey {
+		t.Errorf("capture_start -> %v", resp)
+	}
+}
+
+func TestServerKeysList(t *testing.T) {
+	srv, _ := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	resp := roundTrip(t, conn, map[string]any{"id": "k", "type": protocol.TypeKeysList, "groups": []string{"media"}})
+	keys, _ := resp["keys"].([]any)
+	if resp["type"] != protocol.TypeKeysListResult || len(keys) == 0 {
+		t.Fatalf("keys_list -> %v", resp)
+	}
+	for _, k := range keys {
+		if g := k.(map[string]any)["group"]; g != string(input.GroupMedia) {
+			t.Errorf("keys_list media devolvió %v", k)
+		}
+	}
+}
+
+func TestCaptureCancel(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	send(t, conn, map[string]any{"id": "c1", "type": protocol.TypeCaptureStart})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+
+	// un id que no es de esta sesión
+	resp := roundTrip(t, conn, map[string]any{"id": "x0", "type": protocol.TypeCaptureCancel, "capture_id": "otro"})
+	if resp["code"] != protocol.CodeNotFound {
+		t.Errorf("cancel de otro id -> %v", resp)
+	}
+
+	send(t, conn, map[string]any{"id": "x1", "type": protocol.TypeCaptureCancel, "capture_id": "c1", "ack": true})
+	got := replies(t, conn, "c1", "x1")
+	if got["c1"]["code"] != protocol.CodeCaptureCanceled || got["x1"]["type"] != protocol.TypeAck {
+		t.Errorf("capture_cancel -> %v", got)
+	}
+
+	// mismo id que el capture_start, sin capture_id
+	send(t, conn, map[string]any{"id": "c2", "type": protocol.TypeCaptureStart})
+	waitCalls(t, fake, "CaptureNextKey", 2)
+	send(t, conn, map[string]any{"id": "c2", "type": protocol.TypeCaptureCancel})
+	if resp := replies(t, conn, "c2")["c2"]; resp["code"] != protocol.CodeCaptureCanceled {
+		t.Errorf("capture_cancel con el mismo id -> %v", resp)
+	}
+}
+
+func TestCaptureTakeoverSameSession(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	send(t, conn, map[string]any{"id": "c1", "type": protocol.TypeCaptureStart})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+	fake.QueueCapture(input.CaptureResult{Key: input.KeySpec{VK: 0x42}}, nil)
+	send(t, conn, map[string]any{"id": "c2", "type": protocol.TypeCaptureStart})
+
+	got := replies(t, conn, "c1", "c2")
+	if got["c1"]["code"] != protocol.CodeCaptureCanceled {
+		t.Errorf("c1 -> %v", got["c1"])
+	}
+	if got["c2"]["type"] != protocol.TypeCaptureKey {
+		t.Errorf("c2 -> %v", got["c2"])
+	}
+}
+
+func TestCaptureQueueAcrossSessions(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	a, b := mustConnect(t, srv, ""), mustConnect(t, srv, "")
+
+	send(t, a, map[string]any{"id": "a1", "type": protocol.TypeCaptureStart})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+	send(t, b, map[string]any{"id": "b1", "type": protocol.TypeCaptureStart, "timeout_ms": 5000})
+
+	// b1 espera a que a1 suelte el hook: ni error ni otra llamada al driver
+	time.Sleep(50 * time.Millisecond)
+	waitCalls(t, fake, "CaptureNextKey", 1)
+
+	// a se desconecta: su captura se cancela y le toca a b1
+	fake.QueueCapture(input.CaptureResult{Key: input.KeySpec{VK: 0x43}}, nil)
+	a.Close()
+	resp := replies(t, b, "b1")["b1"]
+	if resp["type"] != protocol.TypeCaptureKey {
+		t.Errorf("b1 -> %v", resp)
+	}
+	waitCalls(t, fake, "CaptureNextKey", 2)
+}
+
+func TestCaptureTimeoutClamped(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{})
+	conn := mustConnect(t, srv, "")
+
+	send(t, conn, map[string]any{"id": "c1", "type": protocol.TypeCaptureStart, "timeout_ms": 2147483647})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+	left, err := time.ParseDuration(fake.Calls()[0].Args[0].(string))
+	if err != nil || left > maxCaptureTimeout || left < maxCaptureTimeout-5*time.Second {
+		t.Errorf("plazo de la captura = %v (%v), want %s", left, err, maxCaptureTimeout)
+	}
+	send(t, conn, map[string]any{"id": "c1", "type": protocol.TypeCaptureCancel})
+	replies(t, conn, "c1")
+}
+
+func TestCaptureQueueWaitBounded(t *testing.T) {
+	prev := captureQueueWait
+	captureQueueWait = 100 * time.Millisecond
+	t.Cleanup(func() { captureQueueWait = prev })
+
+	srv, fake := startServer(t, SecurityConfig{})
+	a, b := mustConnect(t, srv, ""), mustConnect(t, srv, "")
+
+	send(t, a, map[string]any{"id": "a1", "type": protocol.TypeCaptureStart})
+	waitCalls(t, fake, "CaptureNextKey", 1)
+
+	// a1 no suelta el hook: b1 se rinde en vez de esperar para siempre
+	resp := roundTrip(t, b, map[string]any{"id": "b1", "type": protocol.TypeCaptureStart})
+	if resp["code"] != protocol.CodeCaptureBusy {
+		t.Errorf("b1 -> %v", resp)
+	}
+	waitCalls(t, fake, "CaptureNextKey", 1)
+
+	// y la cola quedó sana: cuando a1 termina, la siguiente entra directo
+	send(t, a, map[string]any{"id": "a1", "type": protocol.TypeCaptureCancel})
+	replies(t, a, "a1")
+	fake.QueueCapture(input.CaptureResult{Key: input.KeySpec{VK: 0x44}}, nil)
+	if resp := roundTrip(t, b, map[string]any{"id": "b2", "type": protocol.TypeCaptureStart}); resp["type"] != protocol.TypeCaptureKey {
+		t.Errorf("b2 -> %v", resp)
+	}
+}
+
+// release_all sólo suelta lo propio: otra sesión viva desde la misma IP no se
+// toca; lo que dejó estacionado el mismo dispositivo, sí.
+func TestReleaseAllOwnSessionsOnly(t *testing.T) {
+	srv, fake := startServer(t, SecurityConfig{RequireTLS: true, RequireToken: true, Token: "compartido"})
+	var creds []string
+	for _, name := range []string{"release A", "release B"} {
+		d, cred, err := createDevice(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _, _ = DeleteDevice(d.ID) })
+		creds = append(creds, cred)
+	}
+	keyDown := func(conn *websocket.Conn, key string) {
+		t.Helper()
+		if resp := roundTrip(t, conn, map[string]any{"id": "d", "type": protocol.TypeKeyDown, "key": key, "ack": true}); resp["type"] != protocol.TypeAck {
+			t.Fatalf("key_down %s -> %v", key, resp)
+		}
+	}
+	releaseAll := func(conn *websocket.Conn) {
+		t.Helper()
+		if resp := roundTrip(t, conn, map[string]any{"id": "r", "type": protocol.TypeReleaseAll, "ack": true}); resp["type"] != protocol.TypeAck {
+			t.Fatalf("release_all -> %v", resp)
+		}
+	}
+	keyUps := func() []string {
+		var out []string
+		for _, c := range fake.Calls() {
+			if c.Method == "KeyUp" {
+				out = append(out, c.String())
+			}
+		}
+		return out
+	}
+
+	// B (otro dispositivo, misma IP) aprieta algo y sigue conectado;
+	// el shared token sin dispositivo tampoco puede soltarlo
+	b := mustConnect(t, srv, "token="+creds[1])
+	keyDown(b, "ctrl")
+	guest := mustConnect(t, srv, "token=compartido")
+	releaseAll(guest)
+
+	// A deja shift apretado y se corta con resume_token: queda estacionada
+	a := mustConnect(t, srv, "token="+creds[0])
+	resumeToken(t, a)
+	keyDown(a, "shift")
+	a.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(parkedSessions()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	a2 := mustConnect(t, srv, "token="+creds[0])
+	releaseAll(a2)
+	if got := keyUps(); !reflect.DeepEqual(got, []string{"KeyUp[shift]"}) {
+		t.Errorf("release_all de A soltó %q", got)
+	}
+
+	releaseAll(b)
+	if got := keyUps(); !reflect.DeepEqual(got, []string{"KeyUp[shift]", "KeyUp[ctrl]"}) {
+		t.Errorf("release_all de B soltó %q", got)
+	}
+}
@@ -3,8 +3,14 @@ package ws
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"net"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
+
+	"deskcontrol/daemon/internal/audit"
 )
 
 type SessionInfo struct {
@@ -14,23 +20,97 @@ type SessionInfo struct {
 	ConnectedAt int64
 	LastSeenAt  int64
 	Authed      bool
+
+	// Lo que el cliente declaró en hello (vacío/0 si no mandó hello)
+	Client     string
+	AppVersion string
+	Protocol   int
+
+	// Permisos cargados al hacer login (vacío sin login)
+	Permissions Permissions
+
+	// Esperando que la aprueben en el PC (SecurityConfig.RequireApproval)
+	PendingApproval bool
+
+	// Dispositivo emparejado con el que se conectó ("" si usó el token compartido)
+	DeviceID   string
+	DeviceName string
+
+	// Botones/teclas que la sesión tiene apretados ahora
+	Held int
+
+	// Cola de entrada: pendientes, descartados por cola llena y mouse_move fusionados
+	QueueDepth int
+	Dropped    int64
+	Merged     int64
 }
 
-type sessionEntry struct {
+// Session es una conexión websocket (exista login o no).
+// Los campos se protegen con sessionsMu; los handlers usan los métodos.
+type Session struct {
 	id          string
 	username    string
 	remoteAddr  string
 	connectedAt int64
 	lastSeenAt  int64
 	authed      bool
+	perms       Permissions // del usuario (users.permissions), sólo con login
+	authFails   int         // auth_login fallidos en esta conexión
+
+	// con qué token entró (tokenShared/tokenDevice) y, si fue una
+	// credencial de dispositivo, cuál
+	tokenKind  string
+	deviceID   string
+	deviceName string
+
+	// último permission_denied auditado por tipo (ver auditDenied)
+	deniedAt map[string]time.Time
+
+	client     string
+	appVersion string
+	protocol   int
+	helloName  string // device_name del hello
+
+	// aprobación desde el PC: pendiente, plazo (timer != nil = ya se preguntó)
+	approvalPending  bool
+	approvalTimer    *time.Timer
+	approvalDeadline time.Time
+
+	// negociado en hello (binary también con ?enc=bin)
+	lang   string
+	acks   bool
+	binary bool
+
+	// lo que tiene apretado (se suelta al desconectar o por timeout)
+	held heldSet
+
+	// mensajes pendientes para el driver (nil hasta que arranca el read loop)
+	queue *inputQueue
+
+	// batch corriendo (nil si no hay)
+	batch *batchRun
+
+	// grabación de macro en curso (nil si no hay)
+	rec *recorder
+
+	// capture_start esperando turno o tecla (nil si no hay); se cancela al
+	// desconectar y su respuesta pasa a la sesión que reanude con auth_resume
+	capture *captureRun
+
+	// la conexión ya se cerró (la sesión puede quedar esperando auth_resume)
+	gone bool
+
+	// por qué la cortamos nosotros (reaper, UI, ...); "" = la cerró el cliente
+	dropReason string
 
 	// puntero para poder cortar
 	conn *safeConn
+	srv  *Server
 }
 
 var (
 	sessionsMu sync.Mutex
-	sessions   = map[string]*sessionEntry{}
+	sessions   = map[string]*Session{}
 )
 
 func newSessionID() string {
@@ -39,21 +119,27 @@ func newSessionID() string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
-func registerSession(conn *safeConn, remote string) *sessionEntry {
+func registerSession(srv *Server, conn *safeConn, remote, lang string) *Session {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 
 	id := newSessionID()
 	now := time.Now().Unix()
 
-	se := &sessionEntry{
+	se := &Session{
 		id:          id,
 		username:    "",
 		remoteAddr:  remote,
 		connectedAt: now,
 		lastSeenAt:  now,
 		authed:      false,
+		lang:        lang,
 		conn:        conn,
+		srv:         srv,
+	}
+	if srv != nil {
+		se.held.timeout = srv.holdTimeout()
+		se.held.onIdle = func() { se.releaseHeld(srv.inputDriver(), "hold timeout") }
 	}
 	sessions[id] = se
 	return se
@@ -62,19 +148,34 @@ func registerSession(conn *safeConn, remote string) *sessionEntry {
 func unregisterSession(id string) {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
+	if se, ok := sessions[id]; ok && se.approvalTimer != nil {
+		se.approvalTimer.Stop()
+	}
 	delete(sessions, id)
 }
 
-func markSessionAuthed(id, username string) {
+func markSessionAuthed(id, username string, perms Permissions) {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 	if se, ok := sessions[id]; ok {
 		se.authed = true
 		se.username = username
+		se.perms = perms
 		se.lastSeenAt = time.Now().Unix()
 	}
 }
 
+func setSessionClient(id string, protocol int, client, appVersion, deviceName string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if se, ok := sessions[id]; ok {
+		se.protocol = protocol
+		se.client = client
+		se.appVersion = appVersion
+		se.helloName = cleanDeviceName(deviceName)
+	}
+}
+
 func touchSession(id string) {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
@@ -83,20 +184,109 @@ func touchSession(id string) {
 	}
 }
 
+// ---- accessors (para handlers) ----
+
+func (se *Session) ID() string { return se.id }
+
+func (se *Session) RemoteAddr() string { return se.remoteAddr }
+
+func (se *Session) Username() string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.username
+}
+
+func (se *Session) Authed() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.authed
+}
+
+// Lang es el idioma elegido por el cliente para los textos de error.
+func (se *Session) Lang() string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.lang
+}
+
+// Acks indica si el cliente pidió ack/error para todo mensaje con id.
+func (se *Session) Acks() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.acks
+}
+
+func (se *Session) setDropReason(reason string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if se.dropReason == "" {
+		se.dropReason = reason
+	}
+}
+
+func (se *Session) reason() string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.dropReason
+}
+
+// Binary indica si la sesión negoció frames binarios.
+func (se *Session) Binary() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.binary
+}
+
+func (se *Session) setBinary(v bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.binary = v
+}
+
+func (se *Session) setPrefs(lang string, acks bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	se.lang = lang
+	se.acks = acks
+}
+
+func (se *Session) info() SessionInfo {
+	info := SessionInfo{
+		ID:              se.id,
+		Username:        se.username,
+		RemoteAddr:      se.remoteAddr,
+		ConnectedAt:     se.connectedAt,
+		LastSeenAt:      se.lastSeenAt,
+		Authed:          se.authed,
+		Client:          se.client,
+		AppVersion:      se.appVersion,
+		Protocol:        se.protocol,
+		Permissions:     se.perms,
+		PendingApproval: se.approvalPending,
+		DeviceID:        se.deviceID,
+		DeviceName:      se.deviceName,
+		Held:            se.held.count(),
+	}
+	if se.queue != nil {
+		info.QueueDepth, info.Dropped, info.Merged = se.queue.stats()
+	}
+	return info
+}
+
+// Info devuelve una copia del estado actual de la sesión.
+func (se *Session) Info() SessionInfo {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return se.info()
+}
+
 func ListSessions() []SessionInfo {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 
 	out := make([]SessionInfo, 0, len(sessions))
 	for _, se := range sessions {
-		out = append(out, SessionInfo{
-			ID:          se.id,
-			Username:    se.username,
-			RemoteAddr:  se.remoteAddr,
-			ConnectedAt: se.connectedAt,
-			LastSeenAt:  se.lastSeenAt,
-			Authed:      se.authed,
-		})
+		out = append(out, se.info())
 	}
 	return out
 }
@@ -109,6 +299,84 @@ func DropSession(id string) bool {
 	if !ok || se == nil || se.conn == nil || se.conn.c == nil {
 		return false
 	}
+	se.setDropReason("dropped")
+	invalidateResume(se.id)
+	info := se.Info()
+	audit.Recordf(audit.SessionKick, info.ID, info.Username, info.RemoteAddr, "client=%q", info.Client)
+	if se.srv != nil {
+		se.releaseHeld(se.srv.inputDriver(), "dropped")
+	}
 	_ = se.conn.c.Close() // esto dispara el loop de lectura y limpiará
 	return true
 }
+
+// serverConns devuelve las conexiones vivas de las sesiones de srv.
+func serverConns(srv *Server) []*safeConn {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var conns []*safeConn
+	for _, se := range sessions {
+		if se.srv == srv && se.conn != nil && se.conn.c != nil {
+			conns = append(conns, se.conn)
+		}
+	}
+	return conns
+}
+
+// dropServerSessions cierra todas las sesiones de srv avisando el motivo.
+func dropServerSessions(srv *Server, reason string) int {
+	conns := serverConns(srv)
+	for _, c := range conns {
+		c.close(websocket.CloseGoingAway, reason)
+	}
+	return len(conns)
+}
+
+func remoteHost(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// releaseTargets devuelve se y las sesiones suyas que quedaron estacionadas
+// esperando auth_resume: ya desconectadas y del mismo dispositivo y/o usuario.
+// Es lo que suelta release_all. Las sesiones vivas de otros nunca entran,
+// aunque vengan de la misma IP (NAT, un teléfono con dos usuarios); sin
+// dispositivo ni login no hay forma de saber de quién es nada más que se.
+func releaseTargets(se *Session) []*Session {
+	out := []*Session{se}
+	sessionsMu.Lock()
+	dev, user := se.deviceID, se.username
+	sessionsMu.Unlock()
+	if dev == "" && user == "" {
+		return out
+	}
+	for _, o := range parkedSessions() {
+		if o == se || !o.isGone() {
+			continue
+		}
+		sessionsMu.Lock()
+		same := o.deviceID == dev && strings.EqualFold(o.username, user)
+		sessionsMu.Unlock()
+		if same {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// setServerHoldTimeout aplica d a las sesiones vivas de srv.
+func setServerHoldTimeout(srv *Server, d time.Duration) {
+	sessionsMu.Lock()
+	var list []*Session
+	for _, se := range sessions {
+		if se.srv == srv {
+			list = append(list, se)
+		}
+	}
+	sessionsMu.Unlock()
+	for _, se := range list {
+		se.held.setTimeout(d)
+	}
+}
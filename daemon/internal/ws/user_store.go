@@ -3,6 +3,7 @@ package ws
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +16,7 @@ type userRow struct {
 	Username     string
 	PasswordHash string
 	Disabled     bool
+	Permissions  Permissions
 }
 
 func usersDBPath() (string, error) {
@@ -53,23 +55,66 @@ func loadUser(username string) (*userRow, error) {
 	defer db.Close()
 
 	// users table creada por tu UI
+	if err := ensurePermissionsColumn(db); err != nil {
+		return nil, err
+	}
+
 	var u userRow
 	var disabledInt int
+	var perms string
 
 	err = db.QueryRow(`
-SELECT username, password_hash, disabled
+SELECT username, password_hash, disabled, permissions
 FROM users
 WHERE username = ?
 LIMIT 1;
-`, username).Scan(&u.Username, &u.PasswordHash, &disabledInt)
+`, username).Scan(&u.Username, &u.PasswordHash, &disabledInt, &perms)
 
 	if err != nil {
 		return nil, err
 	}
 	u.Disabled = disabledInt != 0
+	// un valor roto en la DB no da permisos de más: queda sin ninguno
+	if u.Permissions, err = ParsePermissions(perms); err != nil {
+		log.Printf("[auth] user=%q permissions inválidos (%v): sin permisos", u.Username, err)
+		u.Permissions = Permissions{}
+	}
 	return &u, nil
 }
 
+// ensurePermissionsColumn agrega users.permissions a DBs de antes de los
+// permisos. Los usuarios que ya existían quedan como admin (como hasta ahora).
+func ensurePermissionsColumn(db *sql.DB) error {
+	rows, err := db.Query(`PRAGMA table_info(users);`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			def              sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &def, &pk); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, "permissions") {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN permissions TEXT NOT NULL DEFAULT 'admin';`)
+	return err
+}
+
 func markLastLogin(username string) {
 	username = strings.TrimSpace(username)
 	if username == "" {
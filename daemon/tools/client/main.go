@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"deskcontrol/daemon/internal/discovery"
@@ -20,10 +24,17 @@ func main() {
 	fmt.Printf("  (Ctrl+C para salir)\n\n")
 
 	// Responder a "discover" por UDP con "announce"
-	go discovery.StartUDP(*name, *wsPort, *udpPort, *listenIP, false)
-
-	// Bloquea para mantener vivo el proceso
-	for {
-		time.Sleep(1 * time.Hour)
+	resp, err := discovery.StartUDP(*name, *wsPort, *udpPort, *listenIP, false)
+	if err != nil {
+		log.Fatal(err)
 	}
+
+	// Bloquea hasta Ctrl+C
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = resp.Shutdown(ctx)
 }